@@ -4,7 +4,9 @@ import (
 	"net/http"
 
 	"github.com/Sayan-995/dwop/internal/controllers"
+	"github.com/Sayan-995/dwop/internal/storage"
 	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 func NewServer(addr string) *http.Server {
@@ -13,5 +15,35 @@ func NewServer(addr string) *http.Server {
 	r.HandleFunc("/upload", controllers.UploadWorkflow).Methods(http.MethodPost)
 	r.HandleFunc("/update", controllers.UpdateWorkflow).Methods(http.MethodPost)
 	r.HandleFunc("/cancel", controllers.CancelWorkflow).Methods(http.MethodPost)
+	r.HandleFunc("/workflows/validate", controllers.ValidateWorkflow).Methods(http.MethodPost)
+	r.HandleFunc("/workflows", controllers.ListWorkflows).Methods(http.MethodGet)
+	r.HandleFunc("/workflows/{id}", controllers.GetWorkflow).Methods(http.MethodGet)
+	r.HandleFunc("/workflows/{id}/tasks/{name}/runs", controllers.ListTaskRuns).Methods(http.MethodGet)
+	r.HandleFunc("/workflows/{id}/runs/{runId}", controllers.GetTaskRun).Methods(http.MethodGet)
+	r.HandleFunc("/workflows/{id}/runs/{runId}/logs", controllers.GetTaskRunLogs).Methods(http.MethodGet)
+	r.HandleFunc("/workflows/{id}/tasks/{name}/retry", controllers.RetryTask).Methods(http.MethodPost)
+	r.HandleFunc("/workflows/{id}/resume", controllers.ResumeWorkflow).Methods(http.MethodPost)
+	r.HandleFunc("/schedules", controllers.CreateSchedule).Methods(http.MethodPost)
+	r.HandleFunc("/schedules", controllers.ListSchedules).Methods(http.MethodGet)
+	r.HandleFunc("/schedules/{id}", controllers.GetSchedule).Methods(http.MethodGet)
+	r.HandleFunc("/schedules/{id}/runs", controllers.ListScheduleRuns).Methods(http.MethodGet)
+	r.HandleFunc("/schedules/{id}/pause", controllers.PauseSchedule).Methods(http.MethodPost)
+	r.HandleFunc("/schedules/{id}/resume", controllers.ResumeSchedule).Methods(http.MethodPost)
+	r.HandleFunc("/schedules/{id}/backfill", controllers.BackfillSchedule).Methods(http.MethodPost)
+	r.HandleFunc("/definitions", controllers.CreateDefinition).Methods(http.MethodPost)
+	r.HandleFunc("/definitions", controllers.ListDefinitions).Methods(http.MethodGet)
+	r.HandleFunc("/definitions/{name}/versions", controllers.ListDefinitionVersions).Methods(http.MethodGet)
+	r.HandleFunc("/definitions/{name}/versions/{version}", controllers.GetDefinitionVersion).Methods(http.MethodGet)
+	r.HandleFunc("/definitions/{name}/versions/{version}/runs", controllers.TriggerDefinition).Methods(http.MethodPost)
+	r.HandleFunc("/definitions/{name}/versions/{version}/runs", controllers.ListDefinitionRuns).Methods(http.MethodGet)
+	r.HandleFunc("/definitions/{name}/diff", controllers.DiffDefinition).Methods(http.MethodGet)
+	r.HandleFunc("/admin/outbox/stuck", controllers.ListStuckOutboxEvents).Methods(http.MethodGet)
+	r.HandleFunc("/admin/outbox/dead-letter", controllers.ListDeadLetterOutboxEvents).Methods(http.MethodGet)
+	r.HandleFunc("/admin/outbox/events/{id}", controllers.GetOutboxEvent).Methods(http.MethodGet)
+	r.HandleFunc("/admin/outbox/events/{id}/replay", controllers.ReplayOutboxEvent).Methods(http.MethodPost)
+	r.Handle("/metrics", promhttp.Handler()).Methods(http.MethodGet)
+	if h := storage.Handler(); h != nil {
+		r.PathPrefix("/artifacts/").Handler(h).Methods(http.MethodGet, http.MethodPut)
+	}
 	return &http.Server{Addr: addr, Handler: r}
 }
@@ -2,23 +2,30 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
-	"path/filepath"
-	"strings"
 	"syscall"
 	"time"
+	// Schedules name IANA timezones, which minimal images do not ship.
+	_ "time/tzdata"
 
 	api "github.com/Sayan-995/dwop/cmd/api"
+	cronscheduler "github.com/Sayan-995/dwop/cmd/cron-scheduler"
 	inboxpublisher "github.com/Sayan-995/dwop/cmd/inbox-publisher"
 	jobobserver "github.com/Sayan-995/dwop/cmd/job-observer"
 	outboxclaimer "github.com/Sayan-995/dwop/cmd/outbox-claimer"
-	"github.com/Sayan-995/dwop/internal/utils"
+	"github.com/Sayan-995/dwop/internal/dev"
+	"github.com/Sayan-995/dwop/internal/executor"
+	natsjetstream "github.com/Sayan-995/dwop/internal/natsJetStream"
+	"github.com/Sayan-995/dwop/internal/queue"
+	rabitmq "github.com/Sayan-995/dwop/internal/rabitMQ"
+	"github.com/Sayan-995/dwop/internal/repository"
+	"github.com/Sayan-995/dwop/internal/storage"
 	"github.com/joho/godotenv"
-	"k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/tools/clientcmd"
+	"github.com/nats-io/nats.go"
 )
 
 func main() {
@@ -27,36 +34,36 @@ func main() {
 	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM, syscall.SIGINT)
 	defer stop()
 
-	home, _ := os.UserHomeDir()
-	kubeconfig := filepath.Join(home, ".kube", "config")
-	if v := os.Getenv("KUBECONFIG"); v != "" {
-		kubeconfig = v
+	port := os.Getenv("DWOP_PORT")
+	if port == "" {
+		port = "8080"
 	}
 
-	cfg, err := clientcmd.BuildConfigFromFlags("", kubeconfig)
-	if err != nil {
-		log.Fatalf("error setting up the config: %v", err)
-	}
-	k8s, err := kubernetes.NewForConfig(cfg)
-	if err != nil {
-		log.Fatalf("error setting up the K8s client: %v", err)
+	var err error
+	if repository.Limits, err = repository.LimitsFromEnv(); err != nil {
+		log.Fatalf("error reading concurrency limits: %v", err)
 	}
 
-	namespace := os.Getenv("DWOP_NAMESPACE")
-	if namespace == "" {
-		namespace = "default"
-	}
-	namespace = strings.Trim(namespace, "\"")
-	imageName := os.Getenv("DWOP_IMAGE")
-	if imageName == "" {
-		log.Fatalf("DWOP_IMAGE must be set")
+	if len(os.Args) > 1 && os.Args[1] == "dev" {
+		baseURL := os.Getenv("DWOP_STORAGE_URL")
+		if baseURL == "" {
+			baseURL = "http://localhost:" + port
+		}
+		if err := dev.Setup(baseURL); err != nil {
+			log.Fatalf("error setting up dev mode: %v", err)
+		}
+		fmt.Printf("[Dev] Running with in-memory state on port %s, nothing is persisted\n", port)
+	} else if err := setupCluster(); err != nil {
+		log.Fatalf("%v", err)
 	}
-	utils.Conf = &utils.Kubeconfigs{K8s: k8s, Namespace: namespace, ImageName: imageName}
 
-	port := os.Getenv("DWOP_PORT")
-	if port == "" {
-		port = "8080"
-	}
+	go func() {
+		err := jobobserver.Run(ctx, executor.Default)
+		if err != nil && ctx.Err() == nil {
+			log.Printf("observer error: %v", err)
+			stop()
+		}
+	}()
 
 	srv := api.NewServer(":" + port)
 	go func() {
@@ -67,16 +74,50 @@ func main() {
 
 	go inboxpublisher.Run(ctx)
 	go outboxclaimer.Run(ctx)
-	go func() {
-		err := jobobserver.Run(ctx, k8s, namespace)
-		if err != nil && ctx.Err() == nil {
-			log.Printf("observer error: %v", err)
-			stop()
-		}
-	}()
+	go cronscheduler.Run(ctx)
 
 	<-ctx.Done()
 	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 	_ = srv.Shutdown(shutdownCtx)
 }
+
+// setupCluster connects to the database, artifact store, task queue and
+// executor configured in the environment.
+func setupCluster() error {
+	var err error
+	repository.Repo, err = repository.NewFromEnv()
+	if err != nil {
+		return fmt.Errorf("error setting up the DB connection: %v", err)
+	}
+	storage.Store, err = storage.NewFromEnv()
+	if err != nil {
+		return fmt.Errorf("error setting up the artifact store: %v", err)
+	}
+	queue.Tasks, err = newTaskQueue()
+	if err != nil {
+		return fmt.Errorf("error setting up the task queue: %v", err)
+	}
+	executor.Default, err = executor.NewFromEnv()
+	if err != nil {
+		return fmt.Errorf("error setting up the executor: %v", err)
+	}
+	return nil
+}
+
+// newTaskQueue connects to the broker selected by DWOP_QUEUE: rabbitmq (the
+// default) or nats.
+func newTaskQueue() (queue.TaskQueue, error) {
+	switch backend := os.Getenv("DWOP_QUEUE"); backend {
+	case "", "rabbitmq":
+		return rabitmq.NewTaskQueue(os.Getenv("RABBITMQ_CONNECTION_URL"))
+	case "nats":
+		url := os.Getenv("NATS_URL")
+		if url == "" {
+			url = nats.DefaultURL
+		}
+		return natsjetstream.NewTaskQueue(url)
+	default:
+		return nil, fmt.Errorf("unknown DWOP_QUEUE %q, expected rabbitmq or nats", backend)
+	}
+}
@@ -0,0 +1,26 @@
+package cronscheduler
+
+import (
+	"context"
+	"time"
+
+	"github.com/Sayan-995/dwop/internal/service"
+)
+
+const pollInterval = 10 * time.Second
+
+// Run starts the runs of due schedules every pollInterval. Cron ticks have a
+// resolution of one minute, so runs start at most pollInterval late.
+func Run(ctx context.Context) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		service.RunDueSchedules(time.Now())
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
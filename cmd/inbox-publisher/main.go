@@ -3,13 +3,13 @@ package inboxpublisher
 import (
 	"context"
 
-	rabitmq "github.com/Sayan-995/dwop/internal/rabitMQ"
+	"github.com/Sayan-995/dwop/internal/queue"
 	workerpool "github.com/Sayan-995/dwop/internal/workerPool"
 )
 
 func Run(ctx context.Context) {
-	for i := 0; i < rabitmq.ConsumerChannelCount; i++ {
-		workerpool.JobChan <- workerpool.GetJob(workerpool.ConsumeRabitMQJob)
+	for i := 0; i < queue.ConsumerCount; i++ {
+		workerpool.JobChan <- workerpool.GetJob(workerpool.ConsumeTaskQueueJob)
 	}
 	<-ctx.Done()
 }
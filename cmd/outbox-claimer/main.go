@@ -4,9 +4,13 @@ import (
 	"context"
 	"time"
 
+	repo "github.com/Sayan-995/dwop/internal/repository"
 	workerpool "github.com/Sayan-995/dwop/internal/workerPool"
 )
 
+// Run claims as soon as the repository signals new outbox events, and polls
+// as a fallback for events it is not told about, such as those inserted by
+// other orchestrators without LISTEN/NOTIFY or whose backoff has passed.
 func Run(ctx context.Context) {
 	ticker := time.NewTicker(10 * time.Second)
 	defer ticker.Stop()
@@ -16,7 +20,21 @@ func Run(ctx context.Context) {
 		case <-ctx.Done():
 			return
 		case <-ticker.C:
-			workerpool.JobChan <- workerpool.GetJob(workerpool.OutboxClaimJob)
+		case <-repo.OutboxReady():
+		}
+		for ctx.Err() == nil {
+			// A full batch means more events are probably waiting.
+			if claimBatch() < repo.ClaimBatchSize {
+				break
+			}
 		}
 	}
 }
+
+func claimBatch() int {
+	claimed := make(chan int, 1)
+	workerpool.JobChan <- workerpool.GetJob(func(id int) {
+		claimed <- workerpool.OutboxClaimJob(id)
+	})
+	return <-claimed
+}
@@ -0,0 +1,8 @@
+// Package pyworker ships the Python entrypoint that runs every task, so
+// executors outside the worker image can run it too.
+package pyworker
+
+import _ "embed"
+
+//go:embed worker.py
+var Script []byte
@@ -5,7 +5,7 @@ import urllib.request
 import json
 import traceback
 
-TERMINATION_LOG = "/dev/termination-log"
+TERMINATION_LOG = os.getenv("DWOP_TERMINATION_LOG") or "/dev/termination-log"
 
 REQUIRED_ENVS = (
     "CODE_URL",
@@ -44,8 +44,7 @@ def install_dependencies():
     
 def run_task():
     result = subprocess.run(
-        [sys.executable, "/app/task.py"],
-        cwd="/app",
+        [sys.executable, "task.py"],
         stdout=subprocess.PIPE,
         stderr=subprocess.PIPE,
         text=True,
@@ -107,6 +106,7 @@ def main():
         pred_urls_json = os.getenv("PRED_URLS_JSON")
         func_arg_map_json = os.getenv("FUNC_ARG_MAP_JSON")
         output_url = os.getenv("OUTPUT_SIGNED_URL")
+        params_json = os.getenv("PARAMS_JSON") or "{}"
 
         user_code = get_content(code_url)
             
@@ -124,10 +124,15 @@ def main():
             with open(arg_name, "wb") as f:
                 f.write(result)
 
+        with open("params.json", "w") as f:
+            f.write(params_json)
+
         with open("task.py","wb")as f:
             f.write(user_code)
         
-        install_dependencies()
+        # executors that prepare the environment themselves set this
+        if os.getenv("DWOP_SKIP_INSTALL") != "1":
+            install_dependencies()
         run_task()
         with open("output.txt","rb") as f:
             output=f.read()
@@ -0,0 +1,114 @@
+package cache
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/Sayan-995/dwop/internal/mapper"
+	"github.com/Sayan-995/dwop/internal/repository"
+	"github.com/Sayan-995/dwop/internal/storage"
+	u "github.com/Sayan-995/dwop/internal/utils"
+	"github.com/google/uuid"
+)
+
+func Path(key string) string {
+	return fmt.Sprintf("cache/%s/output.txt", key)
+}
+
+// Key derives the content address of a task's result from its code, image,
+// the workflow's requirements and params, and the outputs it reads from its
+// predecessors. Two tasks with the same key produce the same output.
+func Key(workflow u.Workflow, task u.Task) (string, error) {
+	h := sha256.New()
+	codeHash := task.CodeHash
+	if codeHash == "" {
+		code, err := storage.Store.Download(storage.CodeBucket, task.CodeLink)
+		if err != nil {
+			return "", fmt.Errorf("error while downloading code of %s: %v", task.Name, err)
+		}
+		codeHash = digest(code)
+	}
+	fmt.Fprintf(h, "code:%s\nimage:%s\n", codeHash, task.Image)
+
+	env, err := storage.Store.Download(storage.EnvBucket, workflow.EnvLink)
+	if err != nil {
+		return "", fmt.Errorf("error while downloading requirements of %s: %v", workflow.WorkflowId, err)
+	}
+	params, _ := json.Marshal(workflow.Params)
+	fmt.Fprintf(h, "requirements:%s\nparams:%s\n", digest(env), digest(params))
+
+	preds := append([]string(nil), task.Predecessors...)
+	sort.Strings(preds)
+	for _, pred := range preds {
+		path := mapper.OutputPath(workflow.WorkflowId, pred)
+		if task.ParentTaskId != nil && pred == task.MapOver {
+			path = mapper.InputPath(workflow.WorkflowId, task.Name)
+		}
+		out, err := storage.Store.Download(storage.OutputBucket, path)
+		if err != nil {
+			return "", fmt.Errorf("error while downloading output of %s: %v", pred, err)
+		}
+		fmt.Fprintf(h, "pred:%s:%s:%s\n", pred, task.FuncArgMap[pred], digest(out))
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Resolve records the cache key of a task about to run. On a hit the cached
+// output is copied into place and the run is completed without a Job, which
+// enqueues the task's successors.
+func Resolve(workflow u.Workflow, task u.Task, runId uuid.UUID) (bool, error) {
+	key, err := Key(workflow, task)
+	if err != nil {
+		// a key left over from an earlier attempt must not be used to store
+		// this attempt's output
+		_ = repository.SetTaskCacheKey(task.TaskId, "")
+		return false, err
+	}
+	if err := repository.SetTaskCacheKey(task.TaskId, key); err != nil {
+		return false, err
+	}
+	out, err := storage.Store.Download(storage.OutputBucket, Path(key))
+	if err != nil {
+		fmt.Printf("[Cache] Miss for task %s (key %s)\n", task.Name, key)
+		return false, nil
+	}
+	if err := upload(mapper.OutputPath(workflow.WorkflowId, task.Name), out); err != nil {
+		return false, fmt.Errorf("error while uploading cached output of %s: %v", task.Name, err)
+	}
+	if err := repository.CompleteRunAndEnqueueSuccessors(runId.String()); err != nil {
+		return false, err
+	}
+	fmt.Printf("[Cache] Hit for task %s (key %s), skipped job\n", task.Name, key)
+	return true, nil
+}
+
+// Store saves the output of a cached task that ran to completion under the
+// key recorded when it was dispatched.
+func Store(taskId uuid.UUID) error {
+	task, err := repository.GetTaskByID(taskId)
+	if err != nil || task == nil || !task.Cache || task.CacheKey == "" {
+		return err
+	}
+	out, err := storage.Store.Download(storage.OutputBucket, mapper.OutputPath(task.WorkflowId, task.Name))
+	if err != nil {
+		return fmt.Errorf("error while downloading output of %s: %v", task.Name, err)
+	}
+	if err := upload(Path(task.CacheKey), out); err != nil {
+		return fmt.Errorf("error while caching output of %s: %v", task.Name, err)
+	}
+	fmt.Printf("[Cache] Stored output of task %s (key %s)\n", task.Name, task.CacheKey)
+	return nil
+}
+
+func upload(path string, data []byte) error {
+	return storage.Store.Upload(storage.OutputBucket, path, bytes.NewReader(data))
+}
+
+func digest(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,205 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/Sayan-995/dwop/internal/service"
+	u "github.com/Sayan-995/dwop/internal/utils"
+	"github.com/gorilla/mux"
+)
+
+// CreateDefinition takes name, plus the workflow file and requirements like
+// /upload. It answers 201 with the new version, or 200 with the latest one
+// when nothing changed.
+func CreateDefinition(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		writeJSONError(w, http.StatusBadRequest, fmt.Errorf("invalid multipart form: %w", err))
+		return
+	}
+	name := r.FormValue("name")
+	if name == "" {
+		writeJSONError(w, http.StatusBadRequest, fmt.Errorf("missing name"))
+		return
+	}
+
+	workflowFile, err := multipartToTempFile(r, "file")
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+	defer os.Remove(workflowFile.Name())
+	defer workflowFile.Close()
+
+	reqFile, err := multipartToTempFile(r, "requirements")
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+	defer os.Remove(reqFile.Name())
+	defer reqFile.Close()
+
+	definition, created, err := service.CreateDefinition(name, workflowFile, reqFile)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	writeJSON(w, status, definition)
+}
+
+func ListDefinitions(w http.ResponseWriter, r *http.Request) {
+	opts, err := listOptions(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+	if opts.Status != "" {
+		writeJSONError(w, http.StatusBadRequest, fmt.Errorf("status is not supported for definitions"))
+		return
+	}
+
+	page, err := service.ListDefinitions(opts)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, page)
+}
+
+func ListDefinitionVersions(w http.ResponseWriter, r *http.Request) {
+	opts, err := listOptions(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+	if opts.Status != "" {
+		writeJSONError(w, http.StatusBadRequest, fmt.Errorf("status is not supported for definitions"))
+		return
+	}
+
+	page, err := service.ListDefinitionVersions(mux.Vars(r)["name"], opts)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, page)
+}
+
+func GetDefinitionVersion(w http.ResponseWriter, r *http.Request) {
+	version, err := pathVersion(r, "version")
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+	definition, err := service.GetDefinitionVersion(mux.Vars(r)["name"], version)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, definition)
+}
+
+// DiffDefinition compares the versions given by the from and to query
+// parameters, either of which may be "latest".
+func DiffDefinition(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	var versions [2]int
+	for i, name := range []string{"from", "to"} {
+		v := q.Get(name)
+		if v == "" {
+			writeJSONError(w, http.StatusBadRequest, fmt.Errorf("missing %s", name))
+			return
+		}
+		n, err := parseVersion(name, v)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err)
+			return
+		}
+		versions[i] = n
+	}
+
+	diff, err := service.DiffDefinitions(mux.Vars(r)["name"], versions[0], versions[1])
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, diff)
+}
+
+// TriggerDefinition starts a run of a version, taking params and priority
+// like /upload, and answers with the run's Workflow.
+func TriggerDefinition(w http.ResponseWriter, r *http.Request) {
+	version, err := pathVersion(r, "version")
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+	params, err := formParams(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+	priority, err := formPriority(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	workflow, err := service.TriggerDefinition(mux.Vars(r)["name"], version, params, priority)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, workflow)
+}
+
+// ListDefinitionRuns lists the workflows started from a version, filtered
+// and paged like /workflows.
+func ListDefinitionRuns(w http.ResponseWriter, r *http.Request) {
+	version, err := pathVersion(r, "version")
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+	opts, err := listOptions(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+	switch u.RunStatus(opts.Status) {
+	case "", u.RunRunning, u.RunSucceeded, u.RunFailed, u.RunCanceled:
+	default:
+		writeJSONError(w, http.StatusBadRequest, fmt.Errorf("unknown workflow status %q", opts.Status))
+		return
+	}
+
+	page, err := service.ListDefinitionRuns(mux.Vars(r)["name"], version, opts)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, page)
+}
+
+func pathVersion(r *http.Request, name string) (int, error) {
+	return parseVersion(name, mux.Vars(r)[name])
+}
+
+// parseVersion maps "latest" to 0, which the service reads as the latest
+// version.
+func parseVersion(name, v string) (int, error) {
+	if v == "latest" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("%s must be a positive version number or \"latest\"", name)
+	}
+	return n, nil
+}
@@ -0,0 +1,88 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	repo "github.com/Sayan-995/dwop/internal/repository"
+	"github.com/Sayan-995/dwop/internal/service"
+)
+
+// ListStuckOutboxEvents takes older_than as a Go duration, defaulting to the
+// claim lease.
+func ListStuckOutboxEvents(w http.ResponseWriter, r *http.Request) {
+	opts, err := listOptions(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+	if opts.Status != "" {
+		writeJSONError(w, http.StatusBadRequest, fmt.Errorf("status is not supported for outbox events"))
+		return
+	}
+	olderThan := repo.OutboxLease
+	if v := r.URL.Query().Get("older_than"); v != "" {
+		olderThan, err = time.ParseDuration(v)
+		if err != nil || olderThan < 0 {
+			writeJSONError(w, http.StatusBadRequest, fmt.Errorf("invalid older_than %q", v))
+			return
+		}
+	}
+
+	page, err := service.ListStuckOutboxEvents(olderThan, opts)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, page)
+}
+
+func ListDeadLetterOutboxEvents(w http.ResponseWriter, r *http.Request) {
+	opts, err := listOptions(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+	if opts.Status != "" {
+		writeJSONError(w, http.StatusBadRequest, fmt.Errorf("status is not supported for outbox events"))
+		return
+	}
+
+	page, err := service.ListDeadLetterOutboxEvents(opts)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, page)
+}
+
+func GetOutboxEvent(w http.ResponseWriter, r *http.Request) {
+	eventId, err := pathUUID(r, "id")
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	event, err := service.GetOutboxEvent(eventId)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, event)
+}
+
+func ReplayOutboxEvent(w http.ResponseWriter, r *http.Request) {
+	eventId, err := pathUUID(r, "id")
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	event, err := service.ReplayOutboxEvent(eventId)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, event)
+}
@@ -0,0 +1,163 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	repo "github.com/Sayan-995/dwop/internal/repository"
+	"github.com/Sayan-995/dwop/internal/service"
+	u "github.com/Sayan-995/dwop/internal/utils"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 200
+)
+
+func ListWorkflows(w http.ResponseWriter, r *http.Request) {
+	opts, err := listOptions(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+	switch u.RunStatus(opts.Status) {
+	case "", u.RunRunning, u.RunSucceeded, u.RunFailed, u.RunCanceled:
+	default:
+		writeJSONError(w, http.StatusBadRequest, fmt.Errorf("unknown workflow status %q", opts.Status))
+		return
+	}
+
+	page, err := service.ListWorkflows(opts)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, page)
+}
+
+func GetWorkflow(w http.ResponseWriter, r *http.Request) {
+	workflowId, err := pathUUID(r, "id")
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	workflow, err := service.GetWorkflow(workflowId)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, workflow)
+}
+
+func ListTaskRuns(w http.ResponseWriter, r *http.Request) {
+	workflowId, err := pathUUID(r, "id")
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+	opts, err := listOptions(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	runs, err := service.ListTaskRuns(workflowId, mux.Vars(r)["name"], opts)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, runs)
+}
+
+func GetTaskRun(w http.ResponseWriter, r *http.Request) {
+	workflowId, err := pathUUID(r, "id")
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+	runId, err := pathUUID(r, "runId")
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	run, err := service.GetTaskRun(workflowId, runId)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, run)
+}
+
+func GetTaskRunLogs(w http.ResponseWriter, r *http.Request) {
+	workflowId, err := pathUUID(r, "id")
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+	runId, err := pathUUID(r, "runId")
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	logs, err := service.GetTaskRunLogs(workflowId, runId)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"runId": runId.String(), "logs": logs})
+}
+
+func pathUUID(r *http.Request, name string) (uuid.UUID, error) {
+	id, err := uuid.Parse(mux.Vars(r)[name])
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("invalid %s: %w", name, err)
+	}
+	return id, nil
+}
+
+func listOptions(r *http.Request) (repo.ListOptions, error) {
+	q := r.URL.Query()
+	opts := repo.ListOptions{
+		Status: q.Get("status"),
+		Limit:  defaultPageSize,
+	}
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxPageSize {
+			return opts, fmt.Errorf("limit must be between 1 and %d", maxPageSize)
+		}
+		opts.Limit = n
+	}
+	if v := q.Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return opts, fmt.Errorf("offset must be a non-negative integer")
+		}
+		opts.Offset = n
+	}
+	for _, bound := range []struct {
+		name string
+		dst  **time.Time
+	}{
+		{"created_after", &opts.CreatedAfter},
+		{"created_before", &opts.CreatedBefore},
+	} {
+		v := q.Get(bound.name)
+		if v == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return opts, fmt.Errorf("%s must be an RFC 3339 timestamp: %w", bound.name, err)
+		}
+		*bound.dst = &t
+	}
+	return opts, nil
+}
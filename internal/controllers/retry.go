@@ -0,0 +1,48 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/Sayan-995/dwop/internal/service"
+	"github.com/gorilla/mux"
+)
+
+func RetryTask(w http.ResponseWriter, r *http.Request) {
+	workflowId, err := pathUUID(r, "id")
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+	cascade := false
+	if raw := r.FormValue("cascade"); raw != "" {
+		cascade, err = strconv.ParseBool(raw)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, fmt.Errorf("invalid cascade: %w", err))
+			return
+		}
+	}
+
+	result, err := service.RetryTask(workflowId, mux.Vars(r)["name"], cascade)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+func ResumeWorkflow(w http.ResponseWriter, r *http.Request) {
+	workflowId, err := pathUUID(r, "id")
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	result, err := service.ResumeWorkflow(workflowId)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
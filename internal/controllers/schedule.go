@@ -0,0 +1,160 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/Sayan-995/dwop/internal/service"
+	u "github.com/Sayan-995/dwop/internal/utils"
+	"github.com/google/uuid"
+)
+
+// CreateSchedule takes the workflow file and requirements like /upload, plus
+// cron, and optional name, timezone and catch_up fields.
+func CreateSchedule(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		writeJSONError(w, http.StatusBadRequest, fmt.Errorf("invalid multipart form: %w", err))
+		return
+	}
+	spec := service.ScheduleSpec{
+		Name:     r.FormValue("name"),
+		Cron:     r.FormValue("cron"),
+		Timezone: r.FormValue("timezone"),
+		CatchUp:  u.CatchUpPolicy(r.FormValue("catch_up")),
+	}
+	if spec.Cron == "" {
+		writeJSONError(w, http.StatusBadRequest, fmt.Errorf("missing cron"))
+		return
+	}
+
+	workflowFile, err := multipartToTempFile(r, "file")
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+	defer os.Remove(workflowFile.Name())
+	defer workflowFile.Close()
+
+	reqFile, err := multipartToTempFile(r, "requirements")
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+	defer os.Remove(reqFile.Name())
+	defer reqFile.Close()
+
+	if spec.Params, err = formParams(r); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+	if spec.Priority, err = formPriority(r); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	schedule, err := service.CreateSchedule(spec, workflowFile, reqFile)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, schedule)
+}
+
+func ListSchedules(w http.ResponseWriter, r *http.Request) {
+	opts, err := listOptions(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+	switch u.ScheduleStatus(opts.Status) {
+	case "", u.ScheduleActive, u.SchedulePaused:
+	default:
+		writeJSONError(w, http.StatusBadRequest, fmt.Errorf("unknown schedule status %q", opts.Status))
+		return
+	}
+
+	page, err := service.ListSchedules(opts)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, page)
+}
+
+func GetSchedule(w http.ResponseWriter, r *http.Request) {
+	scheduleSvc(w, r, service.GetSchedule)
+}
+
+func PauseSchedule(w http.ResponseWriter, r *http.Request) {
+	scheduleSvc(w, r, service.PauseSchedule)
+}
+
+func ResumeSchedule(w http.ResponseWriter, r *http.Request) {
+	scheduleSvc(w, r, service.ResumeSchedule)
+}
+
+func scheduleSvc(w http.ResponseWriter, r *http.Request, fn func(uuid.UUID) (*u.Schedule, error)) {
+	scheduleId, err := pathUUID(r, "id")
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+	schedule, err := fn(scheduleId)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, schedule)
+}
+
+// ListScheduleRuns lists the workflows created by a schedule, filtered and
+// paged like /workflows.
+func ListScheduleRuns(w http.ResponseWriter, r *http.Request) {
+	scheduleId, err := pathUUID(r, "id")
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+	opts, err := listOptions(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	page, err := service.ListScheduleRuns(scheduleId, opts)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, page)
+}
+
+// BackfillSchedule takes from and to as RFC 3339 timestamps, both inclusive.
+func BackfillSchedule(w http.ResponseWriter, r *http.Request) {
+	scheduleId, err := pathUUID(r, "id")
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+	var bounds [2]time.Time
+	for i, name := range []string{"from", "to"} {
+		v := r.FormValue(name)
+		if v == "" {
+			writeJSONError(w, http.StatusBadRequest, fmt.Errorf("missing %s", name))
+			return
+		}
+		if bounds[i], err = time.Parse(time.RFC3339, v); err != nil {
+			writeJSONError(w, http.StatusBadRequest, fmt.Errorf("%s must be an RFC 3339 timestamp: %w", name, err))
+			return
+		}
+	}
+
+	runs, err := service.BackfillSchedule(scheduleId, bounds[0], bounds[1])
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"runs": runs})
+}
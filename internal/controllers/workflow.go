@@ -2,12 +2,16 @@ package controllers
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
+	"strconv"
 
+	"github.com/Sayan-995/dwop/internal/parser"
 	"github.com/Sayan-995/dwop/internal/service"
+	u "github.com/Sayan-995/dwop/internal/utils"
 )
 
 func UploadWorkflow(w http.ResponseWriter, r *http.Request) {
@@ -36,15 +40,60 @@ func UploadWorkflow(w http.ResponseWriter, r *http.Request) {
 	defer os.Remove(reqFile.Name())
 	defer reqFile.Close()
 
-	workflow, err := service.UploadWorkflowfile(workflowFile, reqFile)
+	params, err := formParams(r)
 	if err != nil {
-		writeJSONError(w, http.StatusInternalServerError, err)
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	priority, err := formPriority(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	workflow, err := service.UploadWorkflowfile(workflowFile, reqFile, params, priority)
+	if err != nil {
+		writeServiceError(w, err)
 		return
 	}
 
 	writeJSON(w, http.StatusOK, workflow)
 }
 
+func ValidateWorkflow(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		writeJSONError(w, http.StatusBadRequest, fmt.Errorf("invalid multipart form: %w", err))
+		return
+	}
+
+	workflowFile, err := multipartToTempFile(r, "file")
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+	defer os.Remove(workflowFile.Name())
+	defer workflowFile.Close()
+
+	params, err := formParams(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	spec, err := service.ValidateWorkflow(workflowFile, params)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{"valid": true, "priority": spec.Priority, "tasks": spec.Tasks})
+}
+
 func UpdateWorkflow(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		w.WriteHeader(http.StatusMethodNotAllowed)
@@ -80,9 +129,21 @@ func UpdateWorkflow(w http.ResponseWriter, r *http.Request) {
 	defer os.Remove(reqFile.Name())
 	defer reqFile.Close()
 
-	workflow, err := service.UpdateWorkflow(workflowID, workflowFile, reqFile)
+	params, err := formParams(r)
 	if err != nil {
-		writeJSONError(w, http.StatusInternalServerError, err)
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	priority, err := formPriority(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	workflow, err := service.UpdateWorkflow(workflowID, workflowFile, reqFile, params, priority)
+	if err != nil {
+		writeServiceError(w, err)
 		return
 	}
 
@@ -112,6 +173,32 @@ func CancelWorkflow(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusOK, map[string]any{"ok": true})
 }
 
+func formParams(r *http.Request) (map[string]any, error) {
+	raw := r.FormValue("params")
+	if raw == "" {
+		return nil, nil
+	}
+	var params map[string]any
+	if err := json.Unmarshal([]byte(raw), &params); err != nil {
+		return nil, fmt.Errorf("params must be a JSON object: %w", err)
+	}
+	return params, nil
+}
+
+// formPriority returns nil when the form has no priority, leaving the one
+// declared in the workflow file.
+func formPriority(r *http.Request) (*int, error) {
+	raw := r.FormValue("priority")
+	if raw == "" {
+		return nil, nil
+	}
+	priority, err := strconv.Atoi(raw)
+	if err != nil || priority < 0 || priority > u.MaxPriority {
+		return nil, fmt.Errorf("priority must be an integer from 0 to %d, got %q", u.MaxPriority, raw)
+	}
+	return &priority, nil
+}
+
 func multipartToTempFile(r *http.Request, field string) (*os.File, error) {
 	src, _, err := r.FormFile(field)
 	if err != nil {
@@ -145,3 +232,27 @@ func writeJSON(w http.ResponseWriter, status int, v any) {
 func writeJSONError(w http.ResponseWriter, status int, err error) {
 	writeJSON(w, status, map[string]any{"error": err.Error()})
 }
+
+func writeServiceError(w http.ResponseWriter, err error) {
+	var verr *parser.ValidationError
+	if errors.As(err, &verr) {
+		writeJSON(w, http.StatusBadRequest, map[string]any{
+			"error":       verr.Error(),
+			"diagnostics": verr.Diagnostics,
+		})
+		return
+	}
+	if errors.Is(err, service.ErrNotFound) {
+		writeJSONError(w, http.StatusNotFound, err)
+		return
+	}
+	if errors.Is(err, service.ErrInvalid) {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+	if errors.Is(err, service.ErrConflict) {
+		writeJSONError(w, http.StatusConflict, err)
+		return
+	}
+	writeJSONError(w, http.StatusInternalServerError, err)
+}
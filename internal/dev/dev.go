@@ -0,0 +1,31 @@
+// Package dev wires the in-memory implementations together so the whole
+// orchestrator runs in one process with no external services.
+package dev
+
+import (
+	"os"
+
+	"github.com/Sayan-995/dwop/internal/executor"
+	"github.com/Sayan-995/dwop/internal/queue"
+	"github.com/Sayan-995/dwop/internal/repository"
+	"github.com/Sayan-995/dwop/internal/storage"
+)
+
+// Setup installs an in-memory repository, queue and artifact store and a
+// local executor. baseURL is where worker processes reach the API server,
+// which serves the artifact store's signed URLs.
+func Setup(baseURL string) error {
+	store, err := storage.NewMemoryStore(baseURL, os.Getenv("DWOP_STORAGE_SECRET"))
+	if err != nil {
+		return err
+	}
+	exec, err := executor.NewLocalExecutorFromEnv()
+	if err != nil {
+		return err
+	}
+	repository.Repo = repository.NewMemoryRepository()
+	storage.Store = store
+	queue.Tasks = queue.NewMemoryQueue()
+	executor.Default = exec
+	return nil
+}
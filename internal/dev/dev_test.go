@@ -0,0 +1,110 @@
+package dev_test
+
+import (
+	"context"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	api "github.com/Sayan-995/dwop/cmd/api"
+	inboxpublisher "github.com/Sayan-995/dwop/cmd/inbox-publisher"
+	jobobserver "github.com/Sayan-995/dwop/cmd/job-observer"
+	outboxclaimer "github.com/Sayan-995/dwop/cmd/outbox-claimer"
+	"github.com/Sayan-995/dwop/internal/dev"
+	"github.com/Sayan-995/dwop/internal/executor"
+	"github.com/Sayan-995/dwop/internal/repository"
+	"github.com/Sayan-995/dwop/internal/service"
+	u "github.com/Sayan-995/dwop/internal/utils"
+)
+
+const twoTasks = `fun a():
+    print("a")
+
+fun b(x:a):
+    print("b")
+`
+
+func writeFile(t *testing.T, name, content string) *os.File {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.Close() })
+	return f
+}
+
+func TestDevModeRunsWorkflowToCompletion(t *testing.T) {
+	if testing.Short() {
+		t.Skip("runs python workers")
+	}
+	if _, err := exec.LookPath("python3"); err != nil {
+		t.Skip("python3 is not installed")
+	}
+	t.Setenv("DWOP_RUN_DIR", t.TempDir())
+	t.Setenv("DWOP_VENV_DIR", t.TempDir())
+	t.Setenv("DWOP_STORAGE_SECRET", "secret")
+
+	// Workers download code and upload outputs through signed URLs served
+	// by the API, so the server address must be known before Setup.
+	srv := httptest.NewUnstartedServer(nil)
+	if err := dev.Setup("http://" + srv.Listener.Addr().String()); err != nil {
+		t.Fatal(err)
+	}
+	srv.Config.Handler = api.NewServer("").Handler
+	srv.Start()
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go inboxpublisher.Run(ctx)
+	go outboxclaimer.Run(ctx)
+	go func() {
+		if err := jobobserver.Run(ctx, executor.Default); err != nil && ctx.Err() == nil {
+			t.Errorf("observer: %v", err)
+		}
+	}()
+
+	workflow, err := service.UploadWorkflowfile(writeFile(t, "two.wf", twoTasks), writeFile(t, "requirements.txt", ""), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(2 * time.Minute)
+	for {
+		wf, err := repository.GetWorkflowByID(workflow.WorkflowId)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if wf.Status != u.RunRunning {
+			if wf.Status != u.RunSucceeded {
+				t.Fatalf("workflow finished %s, want SUCCEEDED", wf.Status)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("workflow did not finish in time")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	tasks, err := repository.GetTasksByWorkflow(workflow.WorkflowId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tasks) != 2 {
+		t.Fatalf("got %d tasks, want 2", len(tasks))
+	}
+	for _, task := range tasks {
+		if task.Status != u.TaskSucceeded {
+			t.Errorf("task %s is %s, want SUCCEEDED", task.Name, task.Status)
+		}
+	}
+}
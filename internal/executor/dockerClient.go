@@ -0,0 +1,335 @@
+package executor
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+const (
+	dockerAPIVersion = "v1.41"
+)
+
+// dockerClient speaks the subset of the Docker Engine API the executor needs.
+type dockerClient struct {
+	http    *http.Client
+	baseURL string
+}
+
+type dockerError struct {
+	Status  int
+	Message string
+}
+
+func (e *dockerError) Error() string {
+	return fmt.Sprintf("docker API returned %d: %s", e.Status, e.Message)
+}
+
+func isDockerStatus(err error, status int) bool {
+	de, ok := err.(*dockerError)
+	return ok && de.Status == status
+}
+
+// newDockerClient accepts DOCKER_HOST style addresses: unix:///path/to.sock,
+// tcp://host:port or http(s)://host:port.
+func newDockerClient(host string) (*dockerClient, error) {
+	if host == "" {
+		host = "unix:///var/run/docker.sock"
+	}
+	u, err := url.Parse(host)
+	if err != nil {
+		return nil, fmt.Errorf("invalid docker host %q: %v", host, err)
+	}
+	switch u.Scheme {
+	case "unix":
+		socket := u.Path
+		transport := &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+			},
+		}
+		return &dockerClient{http: &http.Client{Transport: transport}, baseURL: "http://docker/" + dockerAPIVersion}, nil
+	case "tcp", "http":
+		return &dockerClient{http: &http.Client{}, baseURL: "http://" + u.Host + "/" + dockerAPIVersion}, nil
+	case "https":
+		return &dockerClient{http: &http.Client{}, baseURL: "https://" + u.Host + "/" + dockerAPIVersion}, nil
+	default:
+		return nil, fmt.Errorf("unsupported docker host %q, expected unix://, tcp:// or http(s)://", host)
+	}
+}
+
+func (c *dockerClient) request(ctx context.Context, method, path string, query url.Values, body io.Reader, contentType string) (*http.Response, error) {
+	u := c.baseURL + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 400 {
+		defer resp.Body.Close()
+		var msg struct {
+			Message string `json:"message"`
+		}
+		b, _ := io.ReadAll(resp.Body)
+		if json.Unmarshal(b, &msg) != nil || msg.Message == "" {
+			msg.Message = strings.TrimSpace(string(b))
+		}
+		return nil, &dockerError{Status: resp.StatusCode, Message: msg.Message}
+	}
+	return resp, nil
+}
+
+// do sends in as JSON when it is non-nil and decodes the response into out
+// when it is non-nil.
+func (c *dockerClient) do(ctx context.Context, method, path string, query url.Values, in, out any) error {
+	var body io.Reader
+	contentType := ""
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(b)
+		contentType = "application/json"
+	}
+	resp, err := c.request(ctx, method, path, query, body, contentType)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if out == nil {
+		_, err = io.Copy(io.Discard, resp.Body)
+		return err
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func labelFilters(labels ...string) url.Values {
+	f, _ := json.Marshal(map[string][]string{"label": labels})
+	return url.Values{"filters": {string(f)}}
+}
+
+type dockerContainerConfig struct {
+	Image      string            `json:"Image"`
+	Entrypoint []string          `json:"Entrypoint,omitempty"`
+	WorkingDir string            `json:"WorkingDir,omitempty"`
+	Env        []string          `json:"Env"`
+	Labels     map[string]string `json:"Labels"`
+	HostConfig dockerHostConfig  `json:"HostConfig"`
+}
+
+type dockerHostConfig struct {
+	NetworkMode string   `json:"NetworkMode,omitempty"`
+	ExtraHosts  []string `json:"ExtraHosts,omitempty"`
+	Memory      int64    `json:"Memory,omitempty"`
+	NanoCPUs    int64    `json:"NanoCpus,omitempty"`
+}
+
+type dockerContainer struct {
+	ID     string            `json:"Id"`
+	Labels map[string]string `json:"Labels"`
+}
+
+type dockerContainerState struct {
+	Status     string    `json:"Status"`
+	ExitCode   int       `json:"ExitCode"`
+	Error      string    `json:"Error"`
+	OOMKilled  bool      `json:"OOMKilled"`
+	StartedAt  time.Time `json:"StartedAt"`
+	FinishedAt time.Time `json:"FinishedAt"`
+}
+
+type dockerContainerJSON struct {
+	ID     string               `json:"Id"`
+	State  dockerContainerState `json:"State"`
+	Config struct {
+		Labels map[string]string `json:"Labels"`
+	} `json:"Config"`
+}
+
+type dockerEvent struct {
+	Type   string `json:"Type"`
+	Action string `json:"Action"`
+	Actor  struct {
+		Attributes map[string]string `json:"Attributes"`
+	} `json:"Actor"`
+}
+
+func (c *dockerClient) create(ctx context.Context, name string, config dockerContainerConfig) error {
+	return c.do(ctx, http.MethodPost, "/containers/create", url.Values{"name": {name}}, config, nil)
+}
+
+func (c *dockerClient) start(ctx context.Context, name string) error {
+	return c.do(ctx, http.MethodPost, "/containers/"+name+"/start", nil, nil, nil)
+}
+
+func (c *dockerClient) inspect(ctx context.Context, name string) (*dockerContainerJSON, error) {
+	var out dockerContainerJSON
+	if err := c.do(ctx, http.MethodGet, "/containers/"+name+"/json", nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+func (c *dockerClient) kill(ctx context.Context, name string) error {
+	return c.do(ctx, http.MethodPost, "/containers/"+name+"/kill", nil, nil, nil)
+}
+
+func (c *dockerClient) remove(ctx context.Context, name string) error {
+	return c.do(ctx, http.MethodDelete, "/containers/"+name, url.Values{"force": {"true"}, "v": {"true"}}, nil, nil)
+}
+
+func (c *dockerClient) list(ctx context.Context, labels ...string) ([]dockerContainer, error) {
+	query := labelFilters(labels...)
+	query.Set("all", "true")
+	var out []dockerContainer
+	if err := c.do(ctx, http.MethodGet, "/containers/json", query, nil, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// pull downloads image and waits for the pull to finish. Errors during the
+// pull are reported inside the progress stream, not through the status code.
+func (c *dockerClient) pull(ctx context.Context, image string) error {
+	resp, err := c.request(ctx, http.MethodPost, "/images/create", url.Values{"fromImage": {image}}, nil, "")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var msg struct {
+			Error string `json:"error"`
+		}
+		if err := dec.Decode(&msg); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if msg.Error != "" {
+			return fmt.Errorf("error pulling %s: %s", image, msg.Error)
+		}
+	}
+}
+
+// putFile writes a single file into the container's filesystem, creating its
+// directory. This works before the container is started.
+func (c *dockerClient) putFile(ctx context.Context, name, file string, content []byte) error {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	file = strings.TrimPrefix(file, "/")
+	if dir := path.Dir(file); dir != "." {
+		if err := tw.WriteHeader(&tar.Header{Typeflag: tar.TypeDir, Name: dir + "/", Mode: 0o755}); err != nil {
+			return err
+		}
+	}
+	if err := tw.WriteHeader(&tar.Header{Name: file, Mode: 0o644, Size: int64(len(content))}); err != nil {
+		return err
+	}
+	if _, err := tw.Write(content); err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	resp, err := c.request(ctx, http.MethodPut, "/containers/"+name+"/archive", url.Values{"path": {"/"}}, &buf, "application/x-tar")
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// readFile reads a single file out of the container's filesystem, which works
+// after the container has exited.
+func (c *dockerClient) readFile(ctx context.Context, name, file string) ([]byte, error) {
+	resp, err := c.request(ctx, http.MethodGet, "/containers/"+name+"/archive", url.Values{"path": {file}}, nil, "")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	tr := tar.NewReader(resp.Body)
+	if _, err := tr.Next(); err != nil {
+		return nil, err
+	}
+	return io.ReadAll(tr)
+}
+
+// logs returns the last tail lines of stdout and stderr, interleaved.
+func (c *dockerClient) logs(ctx context.Context, name string, tail int) ([]byte, error) {
+	query := url.Values{"stdout": {"true"}, "stderr": {"true"}, "tail": {fmt.Sprint(tail)}}
+	resp, err := c.request(ctx, http.MethodGet, "/containers/"+name+"/logs", query, nil, "")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return demuxLogs(resp.Body)
+}
+
+// demuxLogs strips the 8 byte frame headers Docker puts in front of every
+// chunk of output of a container without a TTY.
+func demuxLogs(r io.Reader) ([]byte, error) {
+	var out bytes.Buffer
+	header := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(r, header); err == io.EOF {
+			return out.Bytes(), nil
+		} else if err != nil {
+			return out.Bytes(), err
+		}
+		size := int64(binary.BigEndian.Uint32(header[4:]))
+		if _, err := io.CopyN(&out, r, size); err != nil {
+			return out.Bytes(), err
+		}
+	}
+}
+
+// events streams container events for the given labels until ctx is done or
+// the daemon closes the connection.
+func (c *dockerClient) events(ctx context.Context, labels ...string) (<-chan dockerEvent, error) {
+	f, _ := json.Marshal(map[string][]string{"type": {"container"}, "label": labels})
+	resp, err := c.request(ctx, http.MethodGet, "/events", url.Values{"filters": {string(f)}}, nil, "")
+	if err != nil {
+		return nil, err
+	}
+	out := make(chan dockerEvent)
+	go func() {
+		defer close(out)
+		defer resp.Body.Close()
+		dec := json.NewDecoder(resp.Body)
+		for {
+			var ev dockerEvent
+			if err := dec.Decode(&ev); err != nil {
+				if ctx.Err() == nil {
+					fmt.Printf("[DockerExecutor] Event stream ended: %v\n", err)
+				}
+				return
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case out <- ev:
+			}
+		}
+	}()
+	return out, nil
+}
@@ -0,0 +1,270 @@
+package executor
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Sayan-995/dwop/cmd/pyworker"
+	"github.com/Sayan-995/dwop/internal/utils"
+	"github.com/google/uuid"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+const (
+	dockerTerminationLog = "/tmp/dwop-termination-log"
+)
+
+// DockerExecutor runs each task as a container on a single Docker host,
+// named after the run ID and labelled like the Kubernetes Jobs.
+type DockerExecutor struct {
+	ImageName string
+	// Network is the Docker network containers join; empty means the
+	// daemon's default bridge.
+	Network string
+
+	client *dockerClient
+}
+
+func NewDockerExecutor(host, imageName, network string) (*DockerExecutor, error) {
+	client, err := newDockerClient(host)
+	if err != nil {
+		return nil, err
+	}
+	return &DockerExecutor{ImageName: imageName, Network: network, client: client}, nil
+}
+
+func containerName(runID uuid.UUID) string {
+	return "dwop-" + strings.ToLower(runID.String())
+}
+
+func (e *DockerExecutor) Submit(workflow utils.Workflow, task utils.Task, runID uuid.UUID) error {
+	env, err := workerEnv(workflow, task, runID)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	name := containerName(runID)
+
+	config := dockerContainerConfig{
+		Image: e.ImageName,
+		Env:   []string{"DWOP_TERMINATION_LOG=" + dockerTerminationLog},
+		Labels: map[string]string{
+			"app":        "dwop",
+			"runID":      runID.String(),
+			"workflowId": workflow.WorkflowId.String(),
+			"taskId":     task.TaskId.String(),
+			"taskName":   task.Name,
+		},
+		HostConfig: dockerHostConfig{
+			NetworkMode: e.Network,
+			// Lets workers reach an orchestrator on the host, which serves
+			// signed URLs when artifacts are stored locally.
+			ExtraHosts: []string{"host.docker.internal:host-gateway"},
+		},
+	}
+	for _, v := range env {
+		config.Env = append(config.Env, v.Name+"="+v.Value)
+	}
+	if task.TimeoutSeconds > 0 {
+		config.Labels["timeoutSeconds"] = strconv.FormatInt(task.TimeoutSeconds, 10)
+	}
+	if err := containerResources(task.Resources, &config.HostConfig); err != nil {
+		return err
+	}
+	custom := task.Image != "" && task.Image != e.ImageName
+	if custom {
+		// The task image does not ship worker.py; it is copied into the
+		// container before it starts.
+		config.Image = task.Image
+		config.Entrypoint = []string{"python", workerMountPath + "/worker.py"}
+		config.WorkingDir = workerMountPath
+	}
+
+	fmt.Printf("[DockerExecutor] Creating container %s with image %s\n", name, config.Image)
+	fmt.Printf("[DockerExecutor] Task: %s, Workflow: %s, RunID: %s\n", task.TaskId, workflow.WorkflowId, runID)
+	err = e.client.create(ctx, name, config)
+	if isDockerStatus(err, http.StatusNotFound) && custom {
+		fmt.Printf("[DockerExecutor] Pulling image %s\n", config.Image)
+		if err := e.client.pull(ctx, config.Image); err != nil {
+			return err
+		}
+		err = e.client.create(ctx, name, config)
+	}
+	if isDockerStatus(err, http.StatusConflict) {
+		// Redelivered message: only start the container if nobody did yet,
+		// so a finished run is never started again.
+		c, err := e.client.inspect(ctx, name)
+		if err != nil {
+			return err
+		}
+		if c.State.Status != "created" {
+			fmt.Printf("[DockerExecutor] Container already exists: %s\n", name)
+			return nil
+		}
+	} else if err != nil {
+		fmt.Printf("[DockerExecutor] ERROR creating container %s: %v\n", name, err)
+		return err
+	}
+
+	if custom {
+		if err := e.client.putFile(ctx, name, workerMountPath+"/worker.py", pyworker.Script); err != nil {
+			return fmt.Errorf("error copying worker.py into %s: %v", name, err)
+		}
+	}
+	if err := e.client.start(ctx, name); err != nil {
+		fmt.Printf("[DockerExecutor] ERROR starting container %s: %v\n", name, err)
+		return err
+	}
+	if task.TimeoutSeconds > 0 {
+		time.AfterFunc(time.Duration(task.TimeoutSeconds)*time.Second, func() {
+			_, _ = e.Status(runID)
+		})
+	}
+	fmt.Printf("[DockerExecutor] Successfully started container: %s\n", name)
+	return nil
+}
+
+func containerResources(res utils.TaskResources, hc *dockerHostConfig) error {
+	if res.CPU != "" {
+		q, err := resource.ParseQuantity(res.CPU)
+		if err != nil {
+			return fmt.Errorf("invalid cpu quantity %q: %v", res.CPU, err)
+		}
+		hc.NanoCPUs = q.MilliValue() * 1e6
+	}
+	if res.Memory != "" {
+		q, err := resource.ParseQuantity(res.Memory)
+		if err != nil {
+			return fmt.Errorf("invalid memory quantity %q: %v", res.Memory, err)
+		}
+		hc.Memory = q.Value()
+	}
+	return nil
+}
+
+// Status enforces the task timeout, since Docker has no deadline of its own:
+// a container still running past it is killed and reported as failed.
+func (e *DockerExecutor) Status(runID uuid.UUID) (RunStatus, error) {
+	ctx := context.Background()
+	name := containerName(runID)
+	c, err := e.client.inspect(ctx, name)
+	if isDockerStatus(err, http.StatusNotFound) {
+		return RunStatus{}, fmt.Errorf("container %s: %w", name, ErrRunNotFound)
+	}
+	if err != nil {
+		return RunStatus{}, err
+	}
+
+	timeout, _ := strconv.ParseInt(c.Config.Labels["timeoutSeconds"], 10, 64)
+	deadline := time.Duration(timeout) * time.Second
+	state := c.State
+	switch state.Status {
+	case "created":
+		return RunStatus{State: StatePending}, nil
+	case "exited", "dead":
+	default:
+		if deadline > 0 && time.Since(state.StartedAt) > deadline {
+			fmt.Printf("[DockerExecutor] Container %s exceeded its %s deadline, killing it\n", name, deadline)
+			if err := e.client.kill(ctx, name); err != nil && !isDockerStatus(err, http.StatusConflict) {
+				return RunStatus{}, err
+			}
+			return RunStatus{State: StateFailed, Message: "Job failed (DeadlineExceeded)"}, nil
+		}
+		return RunStatus{State: StateRunning}, nil
+	}
+
+	if state.ExitCode == 0 && state.Error == "" {
+		return RunStatus{State: StateSucceeded}, nil
+	}
+	reason := "Error"
+	if state.OOMKilled {
+		reason = "OOMKilled"
+	}
+	message := state.Error
+	if b, err := e.client.readFile(ctx, name, dockerTerminationLog); err == nil && len(b) > 0 {
+		message = string(b)
+	}
+	msg := fmt.Sprintf("Exit code: %d, Reason: %s, Message: %s", state.ExitCode, reason, message)
+	if deadline > 0 && state.FinishedAt.Sub(state.StartedAt) >= deadline {
+		msg = "Job failed (DeadlineExceeded)\n" + msg
+	}
+	return RunStatus{State: StateFailed, Message: msg}, nil
+}
+
+func (e *DockerExecutor) Logs(runID uuid.UUID) (string, error) {
+	name := containerName(runID)
+	b, err := e.client.logs(context.Background(), name, logTailLines)
+	if isDockerStatus(err, http.StatusNotFound) {
+		return "", fmt.Errorf("container %s: %w", name, ErrRunNotFound)
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+func (e *DockerExecutor) Cancel(runID uuid.UUID) error {
+	err := e.client.remove(context.Background(), containerName(runID))
+	if err != nil && !isDockerStatus(err, http.StatusNotFound) {
+		return err
+	}
+	return nil
+}
+
+func (e *DockerExecutor) StopWorkflow(workflowId string) error {
+	containers, err := e.client.list(context.Background(), "app=dwop", "workflowId="+workflowId)
+	if err != nil {
+		return err
+	}
+	for _, c := range containers {
+		err := e.client.remove(context.Background(), c.ID)
+		if err != nil && !isDockerStatus(err, http.StatusNotFound) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *DockerExecutor) Runs() ([]uuid.UUID, error) {
+	containers, err := e.client.list(context.Background(), "app=dwop")
+	if err != nil {
+		return nil, err
+	}
+	var runs []uuid.UUID
+	for _, c := range containers {
+		if runID, err := uuid.Parse(c.Labels["runID"]); err == nil {
+			runs = append(runs, runID)
+		}
+	}
+	return runs, nil
+}
+
+func (e *DockerExecutor) Watch(ctx context.Context) (<-chan uuid.UUID, error) {
+	events, err := e.client.events(ctx, "app=dwop")
+	if err != nil {
+		return nil, err
+	}
+	out := make(chan uuid.UUID)
+	go func() {
+		defer close(out)
+		for ev := range events {
+			if ev.Action != "start" && ev.Action != "die" {
+				continue
+			}
+			runID, err := uuid.Parse(ev.Actor.Attributes["runID"])
+			if err != nil {
+				continue
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case out <- runID:
+			}
+		}
+	}()
+	return out, nil
+}
@@ -0,0 +1,408 @@
+package executor
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Sayan-995/dwop/internal/storage"
+	"github.com/Sayan-995/dwop/internal/utils"
+	"github.com/google/uuid"
+)
+
+// fakeDocker serves the Docker Engine endpoints the executor uses from an
+// in-memory set of containers.
+type fakeDocker struct {
+	mu         sync.Mutex
+	containers map[string]*fakeContainer
+	// calls records "METHOD /path" of every request, without the API version.
+	calls []string
+}
+
+type fakeContainer struct {
+	id             string
+	config         dockerContainerConfig
+	state          dockerContainerState
+	logs           []byte
+	terminationLog string
+}
+
+func newFakeDocker(t *testing.T) (*fakeDocker, *DockerExecutor) {
+	t.Helper()
+	d := &fakeDocker{containers: map[string]*fakeContainer{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /containers/create", d.create)
+	mux.HandleFunc("POST /containers/{name}/start", d.start)
+	mux.HandleFunc("GET /containers/{name}/json", d.inspect)
+	mux.HandleFunc("GET /containers/{name}/logs", d.logs)
+	mux.HandleFunc("GET /containers/{name}/archive", d.archive)
+	mux.HandleFunc("DELETE /containers/{name}", d.remove)
+	mux.HandleFunc("GET /containers/json", d.list)
+	srv := httptest.NewServer(http.StripPrefix("/"+dockerAPIVersion, d.record(mux)))
+	t.Cleanup(srv.Close)
+
+	e, err := NewDockerExecutor(srv.URL, "dwop-worker:latest", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d, e
+}
+
+func (d *fakeDocker) record(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		d.mu.Lock()
+		d.calls = append(d.calls, r.Method+" "+r.URL.Path)
+		d.mu.Unlock()
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (d *fakeDocker) container(w http.ResponseWriter, r *http.Request) *fakeContainer {
+	c, ok := d.containers[r.PathValue("name")]
+	if !ok {
+		for _, other := range d.containers {
+			if other.id == r.PathValue("name") {
+				return other
+			}
+		}
+		w.WriteHeader(http.StatusNotFound)
+		_ = json.NewEncoder(w).Encode(map[string]string{"message": "No such container: " + r.PathValue("name")})
+	}
+	return c
+}
+
+func (d *fakeDocker) create(w http.ResponseWriter, r *http.Request) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	name := r.URL.Query().Get("name")
+	if _, ok := d.containers[name]; ok {
+		w.WriteHeader(http.StatusConflict)
+		_ = json.NewEncoder(w).Encode(map[string]string{"message": "Conflict. The container name is already in use"})
+		return
+	}
+	var config dockerContainerConfig
+	if err := json.NewDecoder(r.Body).Decode(&config); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	id := uuid.NewString()
+	d.containers[name] = &fakeContainer{id: id, config: config, state: dockerContainerState{Status: "created"}}
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(map[string]string{"Id": id})
+}
+
+func (d *fakeDocker) start(w http.ResponseWriter, r *http.Request) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if c := d.container(w, r); c != nil {
+		c.state.Status = "running"
+		c.state.StartedAt = time.Now()
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (d *fakeDocker) inspect(w http.ResponseWriter, r *http.Request) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if c := d.container(w, r); c != nil {
+		out := dockerContainerJSON{ID: c.id, State: c.state}
+		out.Config.Labels = c.config.Labels
+		_ = json.NewEncoder(w).Encode(out)
+	}
+}
+
+func (d *fakeDocker) logs(w http.ResponseWriter, r *http.Request) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if c := d.container(w, r); c != nil {
+		_, _ = w.Write(c.logs)
+	}
+}
+
+func (d *fakeDocker) archive(w http.ResponseWriter, r *http.Request) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	c := d.container(w, r)
+	if c == nil {
+		return
+	}
+	if c.terminationLog == "" || r.URL.Query().Get("path") != dockerTerminationLog {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	tw := tar.NewWriter(w)
+	_ = tw.WriteHeader(&tar.Header{Name: "dwop-termination-log", Mode: 0o644, Size: int64(len(c.terminationLog))})
+	_, _ = tw.Write([]byte(c.terminationLog))
+	_ = tw.Close()
+}
+
+func (d *fakeDocker) remove(w http.ResponseWriter, r *http.Request) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if r.URL.Query().Get("force") != "true" {
+		http.Error(w, "container is running, use force", http.StatusConflict)
+		return
+	}
+	c := d.container(w, r)
+	if c == nil {
+		return
+	}
+	for name, other := range d.containers {
+		if other == c {
+			delete(d.containers, name)
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (d *fakeDocker) list(w http.ResponseWriter, r *http.Request) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var filters struct {
+		Label []string `json:"label"`
+	}
+	if err := json.Unmarshal([]byte(r.URL.Query().Get("filters")), &filters); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	out := []dockerContainer{}
+	for _, c := range d.containers {
+		if hasLabels(c.config.Labels, filters.Label) {
+			out = append(out, dockerContainer{ID: c.id, Labels: c.config.Labels})
+		}
+	}
+	_ = json.NewEncoder(w).Encode(out)
+}
+
+func hasLabels(labels map[string]string, filters []string) bool {
+	for _, f := range filters {
+		k, v, _ := strings.Cut(f, "=")
+		if labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// exit puts the container of runID into the exited state.
+func (d *fakeDocker) exit(runID uuid.UUID, code int, terminationLog string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	c := d.containers[containerName(runID)]
+	c.state.Status = "exited"
+	c.state.ExitCode = code
+	c.state.FinishedAt = time.Now()
+	c.terminationLog = terminationLog
+}
+
+func (d *fakeDocker) called(call string) int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	n := 0
+	for _, c := range d.calls {
+		if c == call {
+			n++
+		}
+	}
+	return n
+}
+
+func useMemoryStore(t *testing.T) {
+	t.Helper()
+	store, err := storage.NewMemoryStore("http://localhost", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	storage.Store = store
+}
+
+func submit(t *testing.T, e *DockerExecutor, workflowId uuid.UUID, name string) uuid.UUID {
+	t.Helper()
+	task := utils.Task{TaskId: uuid.New(), WorkflowId: workflowId, Name: name, CodeLink: "code/" + name}
+	runID := uuid.New()
+	if err := e.Submit(utils.Workflow{WorkflowId: workflowId, EnvLink: "env"}, task, runID); err != nil {
+		t.Fatalf("submit %s: %v", name, err)
+	}
+	return runID
+}
+
+func TestDockerSubmitCreatesAndStartsContainer(t *testing.T) {
+	useMemoryStore(t)
+	d, e := newFakeDocker(t)
+	workflowId := uuid.New()
+	runID := submit(t, e, workflowId, "extract")
+
+	name := containerName(runID)
+	if d.called("POST /containers/create") != 1 || d.called("POST /containers/"+name+"/start") != 1 {
+		t.Fatalf("want one create and one start of %s, got %v", name, d.calls)
+	}
+	c := d.containers[name]
+	if c.config.Image != "dwop-worker:latest" || c.state.Status != "running" {
+		t.Fatalf("container runs %s in state %s", c.config.Image, c.state.Status)
+	}
+	for k, v := range map[string]string{"app": "dwop", "runID": runID.String(), "workflowId": workflowId.String(), "taskName": "extract"} {
+		if c.config.Labels[k] != v {
+			t.Errorf("label %s is %q, want %q", k, c.config.Labels[k], v)
+		}
+	}
+	env := strings.Join(c.config.Env, "\n")
+	for _, want := range []string{"RUN_ID=" + runID.String(), "WORKFLOW_ID=" + workflowId.String(), "DWOP_TERMINATION_LOG=" + dockerTerminationLog} {
+		if !strings.Contains(env, want) {
+			t.Errorf("env lacks %s", want)
+		}
+	}
+
+	// a redelivered submit of a started run does not start it again
+	task := utils.Task{TaskId: uuid.New(), WorkflowId: workflowId, Name: "extract", CodeLink: "code/extract"}
+	if err := e.Submit(utils.Workflow{WorkflowId: workflowId, EnvLink: "env"}, task, runID); err != nil {
+		t.Fatal(err)
+	}
+	if n := d.called("POST /containers/" + name + "/start"); n != 1 {
+		t.Fatalf("container started %d times, want 1", n)
+	}
+}
+
+func TestDockerStatus(t *testing.T) {
+	tests := []struct {
+		name           string
+		exitCode       *int
+		terminationLog string
+		want           RunState
+		message        string
+	}{
+		{name: "running", want: StateRunning},
+		{name: "exit 0", exitCode: intPtr(0), want: StateSucceeded},
+		{name: "exit 1", exitCode: intPtr(1), want: StateFailed, message: "Exit code: 1, Reason: Error"},
+		{name: "exit 2 with termination log", exitCode: intPtr(2), terminationLog: "ValueError: bad input", want: StateFailed, message: "Exit code: 2, Reason: Error, Message: ValueError: bad input"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useMemoryStore(t)
+			d, e := newFakeDocker(t)
+			runID := submit(t, e, uuid.New(), "transform")
+			if tt.exitCode != nil {
+				d.exit(runID, *tt.exitCode, tt.terminationLog)
+			}
+
+			status, err := e.Status(runID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if status.State != tt.want || !strings.Contains(status.Message, tt.message) {
+				t.Fatalf("got %s %q, want %s containing %q", status.State, status.Message, tt.want, tt.message)
+			}
+		})
+	}
+}
+
+func TestDockerStatusOfMissingContainer(t *testing.T) {
+	_, e := newFakeDocker(t)
+	if _, err := e.Status(uuid.New()); !errors.Is(err, ErrRunNotFound) {
+		t.Fatalf("got %v, want ErrRunNotFound", err)
+	}
+}
+
+func frame(stream byte, s string) []byte {
+	header := make([]byte, 8)
+	header[0] = stream
+	binary.BigEndian.PutUint32(header[4:], uint32(len(s)))
+	return append(header, s...)
+}
+
+func TestDockerLogsDemultiplexesStreams(t *testing.T) {
+	useMemoryStore(t)
+	d, e := newFakeDocker(t)
+	runID := submit(t, e, uuid.New(), "load")
+	d.containers[containerName(runID)].logs = bytes.Join([][]byte{
+		frame(1, "loading rows\n"),
+		frame(2, "warning: slow query\n"),
+		frame(1, "done\n"),
+	}, nil)
+
+	logs, err := e.Logs(runID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "loading rows\nwarning: slow query\ndone"; logs != want {
+		t.Fatalf("got %q, want %q", logs, want)
+	}
+	if _, err := e.Logs(uuid.New()); !errors.Is(err, ErrRunNotFound) {
+		t.Fatalf("logs of a missing container: got %v, want ErrRunNotFound", err)
+	}
+}
+
+func TestDockerCancelRemovesContainer(t *testing.T) {
+	useMemoryStore(t)
+	d, e := newFakeDocker(t)
+	runID := submit(t, e, uuid.New(), "extract")
+
+	if err := e.Cancel(runID); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := d.containers[containerName(runID)]; ok {
+		t.Fatal("container still exists after cancel")
+	}
+	// canceling again is not an error
+	if err := e.Cancel(runID); err != nil {
+		t.Fatalf("second cancel: %v", err)
+	}
+}
+
+func TestDockerRunsAndStopWorkflowFilterByLabels(t *testing.T) {
+	useMemoryStore(t)
+	d, e := newFakeDocker(t)
+	first, second := uuid.New(), uuid.New()
+	a := submit(t, e, first, "a")
+	b := submit(t, e, first, "b")
+	c := submit(t, e, second, "c")
+	// a container that is not ours
+	d.containers["postgres"] = &fakeContainer{id: "postgres", config: dockerContainerConfig{Labels: map[string]string{"app": "db"}}}
+
+	runs, err := e.Runs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sameRuns(runs, a, b, c) {
+		t.Fatalf("runs are %v, want %v", runs, []uuid.UUID{a, b, c})
+	}
+
+	if err := e.StopWorkflow(first.String()); err != nil {
+		t.Fatal(err)
+	}
+	runs, err = e.Runs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sameRuns(runs, c) {
+		t.Fatalf("runs after stopping %s are %v, want [%s]", first, runs, c)
+	}
+	if _, ok := d.containers["postgres"]; !ok {
+		t.Fatal("stopping a workflow removed a container without our labels")
+	}
+}
+
+func sameRuns(got []uuid.UUID, want ...uuid.UUID) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	seen := map[uuid.UUID]bool{}
+	for _, id := range got {
+		seen[id] = true
+	}
+	for _, id := range want {
+		if !seen[id] {
+			return false
+		}
+	}
+	return true
+}
+
+func intPtr(n int) *int { return &n }
@@ -3,145 +3,165 @@ package executor
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
 	"strings"
+	"time"
 
-	"github.com/Sayan-995/dwop/internal/repository"
+	"github.com/Sayan-995/dwop/internal/mapper"
+	"github.com/Sayan-995/dwop/internal/storage"
 	"github.com/Sayan-995/dwop/internal/utils"
 	"github.com/google/uuid"
-	"github.com/joho/godotenv"
-	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
-	apierrors "k8s.io/apimachinery/pkg/api/errors"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
 )
 
 const (
-	expiry = 30 * 60 * 60
+	expiry = 30 * time.Hour
 )
 
-func normalizeURL(raw string) string {
-	godotenv.Load()
-	raw = strings.TrimSpace(raw)
-	if raw == "" {
-		return raw
-	}
-	if strings.HasPrefix(raw, "http://") || strings.HasPrefix(raw, "https://") {
-		return raw
-	}
-	projectBase := strings.TrimRight(strings.TrimSpace(os.Getenv("SUPABASE_PROJECT_URL")), "/")
-	if projectBase == "" {
-		return raw
-	}
-	storageBase := projectBase + "/storage/v1"
+var (
+	ErrRunNotFound = errors.New("run not found")
+)
+
+type RunState string
+
+const (
+	StatePending   RunState = "PENDING"
+	StateRunning   RunState = "RUNNING"
+	StateSucceeded RunState = "SUCCEEDED"
+	StateFailed    RunState = "FAILED"
+)
+
+type RunStatus struct {
+	State RunState
+	// Message explains a failure: exit code, reason and whatever the worker
+	// wrote to its termination log. Logs are fetched separately.
+	Message string
+}
+
+func (s RunStatus) Done() bool {
+	return s.State == StateSucceeded || s.State == StateFailed
+}
+
+// Executor runs tasks somewhere. It only reports what happened to a run; the
+// observer polls it and records outcomes. Runs are identified by the run ID
+// given to Submit and stay visible until they are cancelled.
+type Executor interface {
+	Submit(workflow utils.Workflow, task utils.Task, runID uuid.UUID) error
+	// Status returns ErrRunNotFound for runs the executor no longer holds.
+	Status(runID uuid.UUID) (RunStatus, error)
+	// Logs returns the tail of the run's output.
+	Logs(runID uuid.UUID) (string, error)
+	// Cancel stops a run that is still going and releases whatever it holds.
+	// The observer also cancels finished runs once their outcome is recorded.
+	Cancel(runID uuid.UUID) error
+	// StopWorkflow cancels every run of the workflow.
+	StopWorkflow(workflowId string) error
+
+	// Runs lists the runs the executor holds, finished or not.
+	Runs() ([]uuid.UUID, error)
+	// Watch sends the ID of every run whose status may have changed. The
+	// channel is closed when ctx is done or the executor can no longer
+	// watch; the caller then falls back to Runs.
+	Watch(ctx context.Context) (<-chan uuid.UUID, error)
+}
 
-	trimmed := strings.TrimPrefix(raw, "/")
-	if strings.HasPrefix(trimmed, "object/") {
-		return storageBase + "/" + trimmed
+var (
+	Default Executor
+)
+
+// NewFromEnv returns the executor selected by DWOP_EXECUTOR. Kubernetes and
+// Docker run DWOP_IMAGE; the local executor runs worker.py directly.
+func NewFromEnv() (Executor, error) {
+	backend := os.Getenv("DWOP_EXECUTOR")
+	if backend == "local" {
+		return NewLocalExecutorFromEnv()
 	}
-	if strings.HasPrefix(trimmed, "storage/v1/") {
-		return projectBase + "/" + trimmed
+	imageName := os.Getenv("DWOP_IMAGE")
+	if imageName == "" {
+		return nil, fmt.Errorf("DWOP_IMAGE must be set")
 	}
-
-	if strings.HasPrefix(raw, "/") {
-		return projectBase + raw
+	switch backend {
+	case "", "kubernetes":
+		home, _ := os.UserHomeDir()
+		kubeconfig := filepath.Join(home, ".kube", "config")
+		if v := os.Getenv("KUBECONFIG"); v != "" {
+			kubeconfig = v
+		}
+		cfg, err := clientcmd.BuildConfigFromFlags("", kubeconfig)
+		if err != nil {
+			return nil, fmt.Errorf("error setting up the config: %v", err)
+		}
+		k8s, err := kubernetes.NewForConfig(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("error setting up the K8s client: %v", err)
+		}
+		namespace := os.Getenv("DWOP_NAMESPACE")
+		if namespace == "" {
+			namespace = "default"
+		}
+		namespace = strings.Trim(namespace, "\"")
+		return &KubernetesExecutor{K8s: k8s, Namespace: namespace, ImageName: imageName}, nil
+	case "docker":
+		return NewDockerExecutor(os.Getenv("DOCKER_HOST"), imageName, os.Getenv("DWOP_DOCKER_NETWORK"))
+	default:
+		return nil, fmt.Errorf("unknown DWOP_EXECUTOR %q, expected kubernetes, docker or local", backend)
 	}
-	return projectBase + "/" + raw
 }
 
-func CreateJob(k8s kubernetes.Interface, namespace, imageName string, workflow utils.Workflow,
-	task utils.Task, runID uuid.UUID) (*batchv1.Job, error) {
-	codeUrl, err := repository.StorageClient.CreateSignedUrl("Task_Code", task.CodeLink, expiry)
+// workerEnv is the environment worker.py expects: signed URLs for its code,
+// requirements and predecessor outputs, and a signed URL to PUT its output to.
+func workerEnv(workflow utils.Workflow, task utils.Task, runID uuid.UUID) ([]corev1.EnvVar, error) {
+	codeSignedURL, err := storage.Store.SignedGetURL(storage.CodeBucket, task.CodeLink, expiry)
 	if err != nil {
 		return nil, fmt.Errorf("error while creating signed url: %v", err)
 	}
-	codeSignedURL := normalizeURL(codeUrl.SignedURL)
-	requirementsUrl, err := repository.StorageClient.CreateSignedUrl("Workflow_Env", workflow.EnvLink, expiry)
+	reqSignedURL, err := storage.Store.SignedGetURL(storage.EnvBucket, workflow.EnvLink, expiry)
 	if err != nil {
 		return nil, fmt.Errorf("error while creating signed url: %v", err)
 	}
-	reqSignedURL := normalizeURL(requirementsUrl.SignedURL)
 	predUrls := map[string]any{}
 	for _, pred := range task.Predecessors {
-		outputUrl, err := repository.StorageClient.CreateSignedUrl("Task_Output", fmt.Sprintf("%s/%s/output.txt", task.WorkflowId, pred), expiry)
+		predPath := mapper.OutputPath(task.WorkflowId, pred)
+		if task.ParentTaskId != nil && pred == task.MapOver {
+			predPath = mapper.InputPath(task.WorkflowId, task.Name)
+		}
+		outputUrl, err := storage.Store.SignedGetURL(storage.OutputBucket, predPath, expiry)
 		if err != nil {
 			return nil, fmt.Errorf("error while creating signed url: %v", err)
 		}
-		predUrls[pred] = normalizeURL(outputUrl.SignedURL)
+		predUrls[pred] = outputUrl
 	}
 
-	outputUrl, err := repository.StorageClient.CreateSignedUploadUrl("Task_Output", fmt.Sprintf("%s/%s/output.txt", task.WorkflowId, task.Name))
+	outputSignedUploadURL, err := storage.Store.SignedPutURL(storage.OutputBucket, mapper.OutputPath(task.WorkflowId, task.Name), expiry)
 	if err != nil {
 		return nil, fmt.Errorf("error while creating signed upload url: %v", err)
 	}
-	if strings.TrimSpace(outputUrl.Url) == "" {
-		return nil, fmt.Errorf("signed upload url is empty")
-	}
-	outputSignedUploadURL := normalizeURL(outputUrl.Url)
 	predUrlsJson, _ := json.Marshal(predUrls)
 	funcArgMapJson, _ := json.Marshal(task.FuncArgMap)
-
-	jobName := strings.ToLower(runID.String())
-	backoff := int32(0)
-
-	job := &batchv1.Job{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      jobName,
-			Namespace: namespace,
-			Labels: map[string]string{
-				"app":        "dwop",
-				"runID":      runID.String(),
-				"workflowId": workflow.WorkflowId.String(),
-				"taskId":     task.TaskId.String(),
-				"taskName":   task.Name,
-			},
-		},
-		Spec: batchv1.JobSpec{
-			BackoffLimit: &backoff,
-			Template: corev1.PodTemplateSpec{
-				ObjectMeta: metav1.ObjectMeta{
-					Labels: map[string]string{
-						"app":   "dwop",
-						"runID": runID.String(),
-					},
-				},
-				Spec: corev1.PodSpec{
-					RestartPolicy: corev1.RestartPolicyNever,
-					Containers: []corev1.Container{
-						{
-							Name:            "worker",
-							Image:           imageName,
-							ImagePullPolicy: corev1.PullNever,
-							Env: []corev1.EnvVar{
-								{Name: "RUN_ID", Value: runID.String()},
-								{Name: "WORKFLOW_ID", Value: workflow.WorkflowId.String()},
-								{Name: "TASK_ID", Value: task.TaskId.String()},
-								{Name: "TASK_NAME", Value: task.Name},
-								{Name: "CODE_URL", Value: codeSignedURL},
-								{Name: "REQ_URL", Value: reqSignedURL},
-								{Name: "PRED_URLS_JSON", Value: string(predUrlsJson)},
-								{Name: "FUNC_ARG_MAP_JSON", Value: string(funcArgMapJson)},
-								{Name: "OUTPUT_SIGNED_URL", Value: outputSignedUploadURL},
-							},
-						},
-					},
-				},
-			},
-		},
+	params := workflow.Params
+	if params == nil {
+		params = map[string]any{}
 	}
-	fmt.Printf("[CreateJob] Creating job %s in namespace %s with image %s\n", jobName, namespace, imageName)
-	fmt.Printf("[CreateJob] Task: %s, Workflow: %s, RunID: %s\n", task.TaskId, workflow.WorkflowId, runID)
-	created, err := k8s.BatchV1().Jobs(namespace).Create(context.Background(), job, metav1.CreateOptions{})
+	paramsJson, err := json.Marshal(params)
 	if err != nil {
-		if apierrors.IsAlreadyExists(err) {
-			fmt.Printf("[CreateJob] Job already exists: %s\n", jobName)
-			return job, nil
-		}
-		fmt.Printf("[CreateJob] ERROR creating job %s: %v\n", jobName, err)
-		return nil, err
+		return nil, fmt.Errorf("error while encoding workflow params: %v", err)
 	}
-	fmt.Printf("[CreateJob] Successfully created job: %s\n", jobName)
-	return created, nil
+
+	return []corev1.EnvVar{
+		{Name: "RUN_ID", Value: runID.String()},
+		{Name: "WORKFLOW_ID", Value: workflow.WorkflowId.String()},
+		{Name: "TASK_ID", Value: task.TaskId.String()},
+		{Name: "TASK_NAME", Value: task.Name},
+		{Name: "CODE_URL", Value: codeSignedURL},
+		{Name: "REQ_URL", Value: reqSignedURL},
+		{Name: "PRED_URLS_JSON", Value: string(predUrlsJson)},
+		{Name: "FUNC_ARG_MAP_JSON", Value: string(funcArgMapJson)},
+		{Name: "OUTPUT_SIGNED_URL", Value: outputSignedUploadURL},
+		{Name: "PARAMS_JSON", Value: string(paramsJson)},
+	}, nil
 }
@@ -0,0 +1,32 @@
+package executor
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNewFromEnvSelectsLocalWithoutImage(t *testing.T) {
+	dir := t.TempDir()
+	python, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("DWOP_EXECUTOR", "local")
+	t.Setenv("DWOP_IMAGE", "")
+	t.Setenv("DWOP_RUN_DIR", filepath.Join(dir, "runs"))
+	t.Setenv("DWOP_VENV_DIR", filepath.Join(dir, "venvs"))
+	t.Setenv("DWOP_PYTHON", python)
+
+	exec, err := NewFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	local, ok := exec.(*LocalExecutor)
+	if !ok {
+		t.Fatalf("got %T, want *LocalExecutor", exec)
+	}
+	if local.runDir != filepath.Join(dir, "runs") || local.venvDir != filepath.Join(dir, "venvs") || local.python != python {
+		t.Fatalf("got run dir %s, venv dir %s and python %s", local.runDir, local.venvDir, local.python)
+	}
+}
@@ -0,0 +1,318 @@
+package executor
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/Sayan-995/dwop/internal/utils"
+	"github.com/google/uuid"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	workerMountPath = "/app"
+	logTailLines    = 200
+)
+
+// KubernetesExecutor runs each task as a Job named after the run ID.
+type KubernetesExecutor struct {
+	K8s       kubernetes.Interface
+	Namespace string
+	ImageName string
+}
+
+func jobName(runID uuid.UUID) string {
+	return strings.ToLower(runID.String())
+}
+
+func (e *KubernetesExecutor) Submit(workflow utils.Workflow, task utils.Task, runID uuid.UUID) error {
+	_, err := CreateJob(e.K8s, e.Namespace, e.ImageName, workflow, task, runID)
+	return err
+}
+
+func (e *KubernetesExecutor) Status(runID uuid.UUID) (RunStatus, error) {
+	job, err := e.K8s.BatchV1().Jobs(e.Namespace).Get(context.Background(), jobName(runID), metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return RunStatus{}, fmt.Errorf("job %s: %w", jobName(runID), ErrRunNotFound)
+	}
+	if err != nil {
+		return RunStatus{}, err
+	}
+	return e.jobStatus(job), nil
+}
+
+func (e *KubernetesExecutor) jobStatus(job *batchv1.Job) RunStatus {
+	for _, c := range job.Status.Conditions {
+		if c.Status != corev1.ConditionTrue {
+			continue
+		}
+		if c.Type == batchv1.JobComplete {
+			return RunStatus{State: StateSucceeded}
+		}
+		if c.Type == batchv1.JobFailed {
+			failReason := c.Reason
+			if c.Message != "" {
+				failReason = fmt.Sprintf("%s: %s", c.Reason, c.Message)
+			}
+			msg := e.podError(job.Name)
+			if failReason != "" {
+				msg = fmt.Sprintf("Job failed (%s)\n%s", failReason, msg)
+			}
+			return RunStatus{State: StateFailed, Message: msg}
+		}
+	}
+	if job.Status.Active > 0 {
+		return RunStatus{State: StateRunning}
+	}
+	return RunStatus{State: StatePending}
+}
+
+func (e *KubernetesExecutor) jobPod(name string) (*corev1.Pod, error) {
+	pods, err := e.K8s.CoreV1().Pods(e.Namespace).List(context.Background(), metav1.ListOptions{
+		LabelSelector: "job-name=" + name,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(pods.Items) == 0 {
+		return nil, nil
+	}
+	return &pods.Items[0], nil
+}
+
+// podError describes how the worker container of a failed Job ended.
+func (e *KubernetesExecutor) podError(name string) string {
+	pod, err := e.jobPod(name)
+	if err != nil {
+		fmt.Printf("[KubernetesExecutor] Could not list pods: %v\n", err)
+		return fmt.Sprintf("job failed (error listing pods): %v", err)
+	}
+	if pod == nil {
+		return "job failed (no pods found)"
+	}
+	if len(pod.Status.ContainerStatuses) == 0 {
+		return fmt.Sprintf("No container statuses found, pod phase: %s", pod.Status.Phase)
+	}
+	cs := pod.Status.ContainerStatuses[0]
+	if cs.State.Terminated != nil {
+		return fmt.Sprintf("Exit code: %d, Reason: %s, Message: %s",
+			cs.State.Terminated.ExitCode,
+			cs.State.Terminated.Reason,
+			cs.State.Terminated.Message)
+	}
+	if cs.State.Waiting != nil {
+		return fmt.Sprintf("Waiting: %s - %s", cs.State.Waiting.Reason, cs.State.Waiting.Message)
+	}
+	return "Running, but job failed - check pod logs"
+}
+
+func (e *KubernetesExecutor) Logs(runID uuid.UUID) (string, error) {
+	pod, err := e.jobPod(jobName(runID))
+	if err != nil {
+		return "", err
+	}
+	if pod == nil {
+		return "", fmt.Errorf("pod of job %s: %w", jobName(runID), ErrRunNotFound)
+	}
+	tail := int64(logTailLines)
+	logBytes, err := e.K8s.CoreV1().Pods(e.Namespace).GetLogs(pod.Name, &corev1.PodLogOptions{Container: "worker", TailLines: &tail}).
+		DoRaw(context.Background())
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(logBytes)), nil
+}
+
+func (e *KubernetesExecutor) deleteOptions() metav1.DeleteOptions {
+	policy := metav1.DeletePropagationBackground
+	grace := int64(0)
+	return metav1.DeleteOptions{
+		PropagationPolicy:  &policy,
+		GracePeriodSeconds: &grace,
+	}
+}
+
+func (e *KubernetesExecutor) Cancel(runID uuid.UUID) error {
+	err := e.K8s.BatchV1().Jobs(e.Namespace).Delete(context.Background(), jobName(runID), e.deleteOptions())
+	if err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+func (e *KubernetesExecutor) StopWorkflow(workflowId string) error {
+	return e.K8s.BatchV1().Jobs(e.Namespace).DeleteCollection(context.Background(), e.deleteOptions(), metav1.ListOptions{
+		LabelSelector: "app=dwop,workflowId=" + workflowId,
+	})
+}
+
+func (e *KubernetesExecutor) Runs() ([]uuid.UUID, error) {
+	jobs, err := e.K8s.BatchV1().Jobs(e.Namespace).List(context.Background(), metav1.ListOptions{
+		LabelSelector: "app=dwop",
+	})
+	if err != nil {
+		return nil, err
+	}
+	var runs []uuid.UUID
+	for _, job := range jobs.Items {
+		if runID, err := uuid.Parse(job.Labels["runID"]); err == nil {
+			runs = append(runs, runID)
+		}
+	}
+	return runs, nil
+}
+
+func (e *KubernetesExecutor) Watch(ctx context.Context) (<-chan uuid.UUID, error) {
+	w, err := e.K8s.BatchV1().Jobs(e.Namespace).Watch(ctx, metav1.ListOptions{
+		LabelSelector: "app=dwop",
+	})
+	if err != nil {
+		return nil, err
+	}
+	out := make(chan uuid.UUID)
+	go func() {
+		defer close(out)
+		defer w.Stop()
+		for ev := range w.ResultChan() {
+			if ev.Type == watch.Deleted {
+				continue
+			}
+			job, ok := ev.Object.(*batchv1.Job)
+			if !ok {
+				continue
+			}
+			runID, err := uuid.Parse(job.Labels["runID"])
+			if err != nil {
+				fmt.Printf("[KubernetesExecutor] Job %s has no runID label\n", job.Name)
+				continue
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case out <- runID:
+			}
+		}
+	}()
+	return out, nil
+}
+
+func CreateJob(k8s kubernetes.Interface, namespace, imageName string, workflow utils.Workflow,
+	task utils.Task, runID uuid.UUID) (*batchv1.Job, error) {
+	env, err := workerEnv(workflow, task, runID)
+	if err != nil {
+		return nil, err
+	}
+
+	jobName := jobName(runID)
+	backoff := int32(0)
+
+	resources, err := taskResources(task.Resources)
+	if err != nil {
+		return nil, err
+	}
+	container := corev1.Container{
+		Name:            "worker",
+		Image:           imageName,
+		ImagePullPolicy: corev1.PullNever,
+		Resources:       resources,
+		Env:             env,
+	}
+	podSpec := corev1.PodSpec{
+		RestartPolicy: corev1.RestartPolicyNever,
+	}
+	if task.Image != "" && task.Image != imageName {
+		// The task image does not ship worker.py, so copy it out of the dwop
+		// image into a shared volume and run it with the task's interpreter.
+		container.Image = task.Image
+		container.ImagePullPolicy = corev1.PullIfNotPresent
+		container.Command = []string{"python", workerMountPath + "/worker.py"}
+		container.WorkingDir = workerMountPath
+		container.VolumeMounts = []corev1.VolumeMount{{Name: "dwop-worker", MountPath: workerMountPath}}
+		podSpec.Volumes = []corev1.Volume{{
+			Name:         "dwop-worker",
+			VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+		}}
+		podSpec.InitContainers = []corev1.Container{{
+			Name:            "dwop-worker",
+			Image:           imageName,
+			ImagePullPolicy: corev1.PullNever,
+			Command:         []string{"cp", workerMountPath + "/worker.py", "/dwop/worker.py"},
+			VolumeMounts:    []corev1.VolumeMount{{Name: "dwop-worker", MountPath: "/dwop"}},
+		}}
+	}
+	podSpec.Containers = []corev1.Container{container}
+
+	var deadline *int64
+	if task.TimeoutSeconds > 0 {
+		deadline = &task.TimeoutSeconds
+	}
+
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      jobName,
+			Namespace: namespace,
+			Labels: map[string]string{
+				"app":        "dwop",
+				"runID":      runID.String(),
+				"workflowId": workflow.WorkflowId.String(),
+				"taskId":     task.TaskId.String(),
+				"taskName":   task.Name,
+			},
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit:          &backoff,
+			ActiveDeadlineSeconds: deadline,
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: map[string]string{
+						"app":   "dwop",
+						"runID": runID.String(),
+					},
+				},
+				Spec: podSpec,
+			},
+		},
+	}
+	fmt.Printf("[CreateJob] Creating job %s in namespace %s with image %s\n", jobName, namespace, container.Image)
+	fmt.Printf("[CreateJob] Task: %s, Workflow: %s, RunID: %s\n", task.TaskId, workflow.WorkflowId, runID)
+	created, err := k8s.BatchV1().Jobs(namespace).Create(context.Background(), job, metav1.CreateOptions{})
+	if err != nil {
+		if apierrors.IsAlreadyExists(err) {
+			fmt.Printf("[CreateJob] Job already exists: %s\n", jobName)
+			return job, nil
+		}
+		fmt.Printf("[CreateJob] ERROR creating job %s: %v\n", jobName, err)
+		return nil, err
+	}
+	fmt.Printf("[CreateJob] Successfully created job: %s\n", jobName)
+	return created, nil
+}
+
+func taskResources(res utils.TaskResources) (corev1.ResourceRequirements, error) {
+	list := corev1.ResourceList{}
+	if res.CPU != "" {
+		q, err := resource.ParseQuantity(res.CPU)
+		if err != nil {
+			return corev1.ResourceRequirements{}, fmt.Errorf("invalid cpu quantity %q: %v", res.CPU, err)
+		}
+		list[corev1.ResourceCPU] = q
+	}
+	if res.Memory != "" {
+		q, err := resource.ParseQuantity(res.Memory)
+		if err != nil {
+			return corev1.ResourceRequirements{}, fmt.Errorf("invalid memory quantity %q: %v", res.Memory, err)
+		}
+		list[corev1.ResourceMemory] = q
+	}
+	if len(list) == 0 {
+		return corev1.ResourceRequirements{}, nil
+	}
+	return corev1.ResourceRequirements{Requests: list, Limits: list.DeepCopy()}, nil
+}
@@ -0,0 +1,345 @@
+package executor
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sayan-995/dwop/cmd/pyworker"
+	"github.com/Sayan-995/dwop/internal/storage"
+	"github.com/Sayan-995/dwop/internal/utils"
+	"github.com/google/uuid"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	localLogTail = 4000
+)
+
+type localRun struct {
+	workflowId string
+	dir        string
+	cancel     context.CancelFunc
+	status     RunStatus
+}
+
+// LocalExecutor runs worker.py as a subprocess of the orchestrator, one
+// working directory per run. Each distinct requirements file gets its own
+// virtualenv, built on first use and shared by later runs.
+type LocalExecutor struct {
+	runDir  string
+	venvDir string
+	python  string
+
+	mu      sync.Mutex
+	runs    map[uuid.UUID]*localRun
+	venvs   map[string]*sync.Mutex
+	changes chan uuid.UUID
+}
+
+func NewLocalExecutor(runDir, venvDir, python string) (*LocalExecutor, error) {
+	e := &LocalExecutor{
+		python:  python,
+		runs:    map[uuid.UUID]*localRun{},
+		venvs:   map[string]*sync.Mutex{},
+		changes: make(chan uuid.UUID, 256),
+	}
+	for _, dir := range []*string{&runDir, &venvDir} {
+		abs, err := filepath.Abs(*dir)
+		if err != nil {
+			return nil, err
+		}
+		if err := os.MkdirAll(abs, 0o755); err != nil {
+			return nil, fmt.Errorf("error while creating directory %s: %v", abs, err)
+		}
+		*dir = abs
+	}
+	e.runDir, e.venvDir = runDir, venvDir
+	if _, err := exec.LookPath(python); err != nil {
+		return nil, fmt.Errorf("python interpreter %q not found: %v", python, err)
+	}
+	return e, nil
+}
+
+// NewLocalExecutorFromEnv runs tasks under DWOP_RUN_DIR (default data/runs),
+// with virtualenvs under DWOP_VENV_DIR (default data/venvs) created by
+// DWOP_PYTHON (default python3).
+func NewLocalExecutorFromEnv() (*LocalExecutor, error) {
+	runDir := os.Getenv("DWOP_RUN_DIR")
+	if runDir == "" {
+		runDir = "data/runs"
+	}
+	venvDir := os.Getenv("DWOP_VENV_DIR")
+	if venvDir == "" {
+		venvDir = "data/venvs"
+	}
+	python := os.Getenv("DWOP_PYTHON")
+	if python == "" {
+		python = "python3"
+	}
+	return NewLocalExecutor(runDir, venvDir, python)
+}
+
+func (e *LocalExecutor) Submit(workflow utils.Workflow, task utils.Task, runID uuid.UUID) error {
+	env, err := workerEnv(workflow, task, runID)
+	if err != nil {
+		return err
+	}
+	dir := filepath.Join(e.runDir, runID.String())
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(dir, "worker.py"), pyworker.Script, 0o644); err != nil {
+		return err
+	}
+
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if task.TimeoutSeconds > 0 {
+		ctx, cancel = context.WithTimeout(context.Background(), time.Duration(task.TimeoutSeconds)*time.Second)
+	} else {
+		ctx, cancel = context.WithCancel(context.Background())
+	}
+	e.mu.Lock()
+	if _, ok := e.runs[runID]; ok {
+		e.mu.Unlock()
+		cancel()
+		fmt.Printf("[LocalExecutor] Run %s already submitted\n", runID)
+		return nil
+	}
+	e.runs[runID] = &localRun{
+		workflowId: workflow.WorkflowId.String(),
+		dir:        dir,
+		cancel:     cancel,
+		status:     RunStatus{State: StatePending},
+	}
+	e.mu.Unlock()
+
+	fmt.Printf("[LocalExecutor] Starting run %s of task %s in %s\n", runID, task.Name, dir)
+	go func() {
+		defer cancel()
+		status := e.run(ctx, workflow, runID, dir, env)
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			status = RunStatus{State: StateFailed, Message: "Job failed (DeadlineExceeded)"}
+		}
+		e.finish(runID, status)
+	}()
+	return nil
+}
+
+func (e *LocalExecutor) run(ctx context.Context, workflow utils.Workflow, runID uuid.UUID, dir string, env []corev1.EnvVar) RunStatus {
+	logFile, err := os.Create(filepath.Join(dir, "run.log"))
+	if err != nil {
+		return RunStatus{State: StateFailed, Message: err.Error()}
+	}
+	defer logFile.Close()
+
+	python, err := e.venv(ctx, workflow, logFile)
+	if err != nil {
+		return RunStatus{State: StateFailed, Message: fmt.Sprintf("error building virtualenv: %v", err)}
+	}
+	e.setState(runID, StateRunning)
+
+	cmd := exec.CommandContext(ctx, python, "worker.py")
+	cmd.Dir = dir
+	killProcessGroup(cmd)
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	cmd.Env = append(os.Environ(), "DWOP_TERMINATION_LOG="+filepath.Join(dir, "termination-log"), "DWOP_SKIP_INSTALL=1")
+	for _, v := range env {
+		cmd.Env = append(cmd.Env, v.Name+"="+v.Value)
+	}
+	if err := cmd.Run(); err != nil {
+		msg := fmt.Sprintf("Exit: %v", err)
+		if b, err := os.ReadFile(filepath.Join(dir, "termination-log")); err == nil && len(b) > 0 {
+			msg = fmt.Sprintf("%s, Message: %s", msg, b)
+		}
+		return RunStatus{State: StateFailed, Message: msg}
+	}
+	return RunStatus{State: StateSucceeded}
+}
+
+// venv returns the interpreter of the virtualenv for the workflow's
+// requirements, creating it if needed. Build output goes to log.
+func (e *LocalExecutor) venv(ctx context.Context, workflow utils.Workflow, log io.Writer) (string, error) {
+	requirements, err := storage.Store.Download(storage.EnvBucket, workflow.EnvLink)
+	if err != nil {
+		return "", fmt.Errorf("error downloading requirements: %v", err)
+	}
+	sum := sha256.Sum256(requirements)
+	digest := hex.EncodeToString(sum[:])[:16]
+	dir := filepath.Join(e.venvDir, digest)
+	python := filepath.Join(dir, "bin", "python")
+	if runtime.GOOS == "windows" {
+		python = filepath.Join(dir, "Scripts", "python.exe")
+	}
+
+	e.mu.Lock()
+	lock, ok := e.venvs[digest]
+	if !ok {
+		lock = &sync.Mutex{}
+		e.venvs[digest] = lock
+	}
+	e.mu.Unlock()
+	lock.Lock()
+	defer lock.Unlock()
+
+	ready := filepath.Join(dir, ".dwop-ready")
+	if _, err := os.Stat(ready); err == nil {
+		return python, nil
+	}
+	fmt.Printf("[LocalExecutor] Building virtualenv %s\n", dir)
+	if err := os.RemoveAll(dir); err != nil {
+		return "", err
+	}
+	reqFile := filepath.Join(e.venvDir, digest+".txt")
+	if err := os.WriteFile(reqFile, requirements, 0o644); err != nil {
+		return "", err
+	}
+	defer os.Remove(reqFile)
+	for _, args := range [][]string{
+		{e.python, "-m", "venv", dir},
+		{python, "-m", "pip", "install", "-r", reqFile},
+	} {
+		cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+		cmd.Stdout = log
+		cmd.Stderr = log
+		if err := cmd.Run(); err != nil {
+			return "", fmt.Errorf("%s: %v", strings.Join(args, " "), err)
+		}
+	}
+	if err := os.WriteFile(ready, nil, 0o644); err != nil {
+		return "", err
+	}
+	return python, nil
+}
+
+func (e *LocalExecutor) setState(runID uuid.UUID, state RunState) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if run, ok := e.runs[runID]; ok {
+		run.status.State = state
+	}
+	e.notify(runID)
+}
+
+func (e *LocalExecutor) finish(runID uuid.UUID, status RunStatus) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	run, ok := e.runs[runID]
+	if !ok {
+		fmt.Printf("[LocalExecutor] Run %s was cancelled\n", runID)
+		return
+	}
+	run.status = status
+	fmt.Printf("[LocalExecutor] Run %s finished with %s\n", runID, status.State)
+	e.notify(runID)
+}
+
+// notify tells the watcher about a change. When nobody keeps up the change is
+// dropped; the observer's periodic resync picks it up. Callers hold e.mu.
+func (e *LocalExecutor) notify(runID uuid.UUID) {
+	select {
+	case e.changes <- runID:
+	default:
+	}
+}
+
+func (e *LocalExecutor) Status(runID uuid.UUID) (RunStatus, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	run, ok := e.runs[runID]
+	if !ok {
+		return RunStatus{}, fmt.Errorf("local run %s: %w", runID, ErrRunNotFound)
+	}
+	return run.status, nil
+}
+
+// Logs reads the run's log file, which outlives the run when it failed.
+func (e *LocalExecutor) Logs(runID uuid.UUID) (string, error) {
+	b, err := os.ReadFile(filepath.Join(e.runDir, runID.String(), "run.log"))
+	if errors.Is(err, os.ErrNotExist) {
+		return "", fmt.Errorf("local run %s: %w", runID, ErrRunNotFound)
+	}
+	if err != nil {
+		return "", err
+	}
+	if len(b) > localLogTail {
+		b = b[len(b)-localLogTail:]
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+// Cancel kills the run if it is still going and forgets it. The working
+// directory is removed unless the run failed, so failures can be inspected.
+func (e *LocalExecutor) Cancel(runID uuid.UUID) error {
+	e.mu.Lock()
+	run, ok := e.runs[runID]
+	delete(e.runs, runID)
+	e.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	run.cancel()
+	if run.status.State != StateFailed {
+		return os.RemoveAll(run.dir)
+	}
+	return nil
+}
+
+func (e *LocalExecutor) StopWorkflow(workflowId string) error {
+	e.mu.Lock()
+	var runIDs []uuid.UUID
+	for runID, run := range e.runs {
+		if run.workflowId == workflowId {
+			runIDs = append(runIDs, runID)
+		}
+	}
+	e.mu.Unlock()
+	for _, runID := range runIDs {
+		if err := e.Cancel(runID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *LocalExecutor) Runs() ([]uuid.UUID, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	runIDs := make([]uuid.UUID, 0, len(e.runs))
+	for runID := range e.runs {
+		runIDs = append(runIDs, runID)
+	}
+	return runIDs, nil
+}
+
+func (e *LocalExecutor) Watch(ctx context.Context) (<-chan uuid.UUID, error) {
+	out := make(chan uuid.UUID)
+	go func() {
+		defer close(out)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case runID := <-e.changes:
+				select {
+				case <-ctx.Done():
+					return
+				case out <- runID:
+				}
+			}
+		}
+	}()
+	return out, nil
+}
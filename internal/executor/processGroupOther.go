@@ -0,0 +1,7 @@
+//go:build !unix
+
+package executor
+
+import "os/exec"
+
+func killProcessGroup(cmd *exec.Cmd) {}
@@ -0,0 +1,244 @@
+package mapper
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/Sayan-995/dwop/internal/repository"
+	"github.com/Sayan-995/dwop/internal/storage"
+	u "github.com/Sayan-995/dwop/internal/utils"
+	"github.com/google/uuid"
+)
+
+func OutputPath(workflowId uuid.UUID, taskName string) string {
+	return fmt.Sprintf("%s/%s/output.txt", workflowId, taskName)
+}
+
+func InputPath(workflowId uuid.UUID, taskName string) string {
+	return fmt.Sprintf("%s/%s/input.txt", workflowId, taskName)
+}
+
+func IsParent(task u.Task) bool {
+	return task.MapOver != "" && task.ParentTaskId == nil
+}
+
+type Outcome int
+
+const (
+	Expanded Outcome = iota
+	Completed
+	Failed
+)
+
+// Expand reads the mapped-over predecessor's output as a JSON list and creates
+// one child task per element. Each child receives its element in place of the
+// predecessor's full output.
+func Expand(task u.Task, runId uuid.UUID) (Outcome, error) {
+	if task.MapSize != nil {
+		fmt.Printf("[Mapper] Task %s already expanded into %d children\n", task.Name, *task.MapSize)
+		if *task.MapSize == 0 {
+			// the run that expanded it failed to complete it
+			return completeEmpty(task, runId)
+		}
+		return Expanded, nil
+	}
+	raw, err := storage.Store.Download(storage.OutputBucket, OutputPath(task.WorkflowId, task.MapOver))
+	if err != nil {
+		return Expanded, fmt.Errorf("error while downloading output of %s: %v", task.MapOver, err)
+	}
+	var items []json.RawMessage
+	if err := json.Unmarshal(bytes.TrimSpace(raw), &items); err != nil {
+		return Failed, repository.IncreaseAttempt(runId.String(), fmt.Sprintf("map over %s: output is not a JSON list: %v", task.MapOver, err))
+	}
+	fmt.Printf("[Mapper] Expanding task %s over %d elements of %s\n", task.Name, len(items), task.MapOver)
+
+	children := make([]u.Task, 0, len(items))
+	for i, item := range items {
+		index := i
+		parentId := task.TaskId
+		child := u.Task{
+			TaskId:         uuid.New(),
+			WorkflowId:     task.WorkflowId,
+			Name:           fmt.Sprintf("%s.%d", task.Name, i),
+			CodeLink:       task.CodeLink,
+			FuncArgMap:     task.FuncArgMap,
+			Predecessors:   task.Predecessors,
+			Status:         u.TaskPending,
+			MaxAttempts:    task.MaxAttempts,
+			CreatedAt:      time.Now(),
+			Image:          task.Image,
+			TimeoutSeconds: task.TimeoutSeconds,
+			Resources:      task.Resources,
+			MapOver:        task.MapOver,
+			Cache:          task.Cache,
+			Pool:           task.Pool,
+			ParentTaskId:   &parentId,
+			MapIndex:       &index,
+		}
+		if err := upload(InputPath(task.WorkflowId, child.Name), elementBytes(item)); err != nil {
+			return Expanded, fmt.Errorf("error while uploading input of %s: %v", child.Name, err)
+		}
+		children = append(children, child)
+	}
+	if err := repository.InsertMapTasks(task, children); err != nil {
+		return Expanded, err
+	}
+	if len(children) == 0 {
+		return completeEmpty(task, runId)
+	}
+	return Expanded, nil
+}
+
+// completeEmpty completes a task mapped over an empty list with an empty list
+// as its output.
+func completeEmpty(task u.Task, runId uuid.UUID) (Outcome, error) {
+	if err := upload(OutputPath(task.WorkflowId, task.Name), []byte("[]")); err != nil {
+		return Expanded, fmt.Errorf("error while uploading empty output of %s: %v", task.Name, err)
+	}
+	return Completed, repository.CompleteRunAndEnqueueSuccessors(runId.String())
+}
+
+// CompleteChild is called after a child run succeeds. Once every sibling has
+// succeeded, the children's outputs are gathered into the parent's output as
+// a JSON list and the parent run is completed, which releases its successors.
+// The parent is returned only by the call that completed it.
+func CompleteChild(task u.Task) (*u.Task, error) {
+	children, err := repository.GetChildTasks(*task.ParentTaskId)
+	if err != nil {
+		return nil, err
+	}
+	for _, child := range children {
+		if child.Status != u.TaskSucceeded && child.TaskId != task.TaskId {
+			return nil, nil
+		}
+	}
+	parent, err := repository.GetTaskByID(*task.ParentTaskId)
+	if err != nil {
+		return nil, err
+	}
+	if parent == nil {
+		return nil, fmt.Errorf("mapped task %s not found", *task.ParentTaskId)
+	}
+	if err := collectOutputs(*parent, children); err != nil {
+		return nil, err
+	}
+	won, err := repository.ClaimMapCollection(parent.TaskId)
+	if err != nil || !won {
+		return nil, err
+	}
+	if err := completeParent(*parent); err != nil {
+		return nil, err
+	}
+	parent.Status = u.TaskSucceeded
+	return parent, nil
+}
+
+// Collect completes a mapped parent left RUNNING after all of its children
+// succeeded, because the call that should have collected them failed. A
+// parent already claimed has its output and only needs its run completed.
+func Collect(parent u.Task) error {
+	if !IsParent(parent) || parent.Status != u.TaskRunning || parent.MapSize == nil {
+		return nil
+	}
+	children, err := repository.GetChildTasks(parent.TaskId)
+	if err != nil {
+		return err
+	}
+	if len(children) != *parent.MapSize {
+		return nil
+	}
+	for _, child := range children {
+		if child.Status != u.TaskSucceeded {
+			return nil
+		}
+	}
+	if !parent.MapCollected {
+		if err := collectOutputs(parent, children); err != nil {
+			return err
+		}
+		if _, err := repository.ClaimMapCollection(parent.TaskId); err != nil {
+			return err
+		}
+	}
+	return completeParent(parent)
+}
+
+// collectOutputs uploads the children's outputs, in map order, as the parent's
+// output. It runs before map_collected is claimed, so a failed upload leaves
+// the collection to a later call and a claimed parent always has its output.
+func collectOutputs(parent u.Task, children []u.Task) error {
+	fmt.Printf("[Mapper] All %d children of %s succeeded, collecting outputs\n", len(children), parent.Name)
+	sort.Slice(children, func(i, j int) bool { return *children[i].MapIndex < *children[j].MapIndex })
+	outputs := make([]json.RawMessage, 0, len(children))
+	for _, child := range children {
+		out, err := storage.Store.Download(storage.OutputBucket, OutputPath(child.WorkflowId, child.Name))
+		if err != nil {
+			return fmt.Errorf("error while downloading output of %s: %v", child.Name, err)
+		}
+		outputs = append(outputs, outputElement(out))
+	}
+	combined, _ := json.Marshal(outputs)
+	if err := upload(OutputPath(parent.WorkflowId, parent.Name), combined); err != nil {
+		return fmt.Errorf("error while uploading output of %s: %v", parent.Name, err)
+	}
+	return nil
+}
+
+// completeParent completes the parent's run. Completing a run twice is a no-op.
+func completeParent(parent u.Task) error {
+	run, err := repository.GetLatestTaskRun(parent.TaskId)
+	if err != nil {
+		return err
+	}
+	if run == nil {
+		return fmt.Errorf("no run found for mapped task %s", parent.TaskId)
+	}
+	return repository.CompleteRunAndEnqueueSuccessors(run.RunId.String())
+}
+
+// FailChild marks the parent failed once a child has exhausted its attempts,
+// since the mapped set can no longer complete. The parent is returned only by
+// the call that failed it.
+func FailChild(task u.Task) (*u.Task, error) {
+	won, err := repository.TransitionTaskStatus(*task.ParentTaskId, u.TaskRunning, u.TaskFailed)
+	if err != nil || !won {
+		return nil, err
+	}
+	fmt.Printf("[Mapper] Child %s failed permanently, failing parent %s\n", task.Name, *task.ParentTaskId)
+	parent, err := repository.GetTaskByID(*task.ParentTaskId)
+	if err != nil {
+		return nil, err
+	}
+	if parent == nil {
+		return nil, fmt.Errorf("mapped task %s not found", *task.ParentTaskId)
+	}
+	return parent, nil
+}
+
+func upload(path string, data []byte) error {
+	return storage.Store.Upload(storage.OutputBucket, path, bytes.NewReader(data))
+}
+
+// elementBytes hands string elements to the task verbatim and everything
+// else as JSON.
+func elementBytes(item json.RawMessage) []byte {
+	var s string
+	if err := json.Unmarshal(item, &s); err == nil {
+		return []byte(s)
+	}
+	return item
+}
+
+// outputElement embeds JSON outputs as-is so maps can be chained, and wraps
+// any other stdout as a JSON string.
+func outputElement(out []byte) json.RawMessage {
+	trimmed := bytes.TrimSpace(out)
+	if json.Valid(trimmed) && len(trimmed) > 0 {
+		return trimmed
+	}
+	s, _ := json.Marshal(string(out))
+	return s
+}
@@ -0,0 +1,31 @@
+// Package metrics holds the orchestrator's Prometheus metrics, served on
+// /metrics.
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	OutboxReclaimedEvents = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "dwop_outbox_reclaimed_events_total",
+		Help: "Outbox events released for reclaiming because their claimer's lease expired before publishing them.",
+	})
+	OutboxStuckEvents = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "dwop_outbox_stuck_events",
+		Help: "Outbox events claimed but not published whose lease has expired, as of the last claim loop.",
+	})
+	OutboxPublishFailures = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "dwop_outbox_publish_failures_total",
+		Help: "Failed attempts to publish an outbox event to the task queue.",
+	})
+	OutboxDeadLetteredEvents = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "dwop_outbox_dead_lettered_events_total",
+		Help: "Outbox events dead-lettered after running out of publish attempts.",
+	})
+	DeferredRuns = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "dwop_deferred_runs_total",
+		Help: "Task messages deferred by a consumer because starting the run would exceed a concurrency limit.",
+	})
+)
@@ -0,0 +1,169 @@
+package natsjetstream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Sayan-995/dwop/internal/queue"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+var (
+	StreamName   = "DWOP_TASKS"
+	Subject      = "dwop.tasks"
+	ConsumerName = "dwop-workers"
+)
+
+const (
+	publishTimeout  = 10 * time.Second
+	restartMinDelay = 1 * time.Second
+	restartMaxDelay = 30 * time.Second
+)
+
+// TaskQueue is a queue.TaskQueue on a NATS JetStream work queue stream. All
+// consumers share one durable pull consumer, so each message goes to exactly
+// one of them and survives restarts of the orchestrator.
+type TaskQueue struct {
+	conn     *nats.Conn
+	js       jetstream.JetStream
+	consumer jetstream.Consumer
+}
+
+func NewTaskQueue(url string) (*TaskQueue, error) {
+	conn, err := nats.Connect(url,
+		nats.Name("dwop"),
+		nats.MaxReconnects(-1),
+		nats.DisconnectErrHandler(func(_ *nats.Conn, err error) {
+			fmt.Printf("[NATS] Disconnected: %v, reconnecting\n", err)
+		}),
+		nats.ReconnectHandler(func(*nats.Conn) {
+			fmt.Printf("[NATS] Reconnected\n")
+		}),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to NATS: %v", err)
+	}
+	js, err := jetstream.New(conn)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("error opening JetStream: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), publishTimeout)
+	defer cancel()
+	_, err = js.CreateOrUpdateStream(ctx, jetstream.StreamConfig{
+		Name:      StreamName,
+		Subjects:  []string{Subject},
+		Retention: jetstream.WorkQueuePolicy,
+		Storage:   jetstream.FileStorage,
+	})
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("error declaring stream %s: %v", StreamName, err)
+	}
+	consumer, err := js.CreateOrUpdateConsumer(ctx, StreamName, jetstream.ConsumerConfig{
+		Durable:   ConsumerName,
+		AckPolicy: jetstream.AckExplicitPolicy,
+	})
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("error declaring consumer %s: %v", ConsumerName, err)
+	}
+	return &TaskQueue{conn: conn, js: js, consumer: consumer}, nil
+}
+
+func (q *TaskQueue) Close() error {
+	q.conn.Close()
+	return nil
+}
+
+// Publish returns once the stream stored the message. JetStream has no
+// message priorities, so priority is ignored and order is first in, first out.
+func (q *TaskQueue) Publish(body []byte, priority int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), publishTimeout)
+	defer cancel()
+	if _, err := q.js.Publish(ctx, Subject, body); err != nil {
+		return fmt.Errorf("error publishing message: %v", err)
+	}
+	return nil
+}
+
+// Consume pulls one message at a time and keeps going across iterator
+// failures until ctx is done. Messages left unacked are redelivered by the
+// server once their ack wait runs out.
+func (q *TaskQueue) Consume(ctx context.Context) (<-chan queue.Delivery, error) {
+	out := make(chan queue.Delivery)
+	go func() {
+		defer close(out)
+		delay := restartMinDelay
+		for ctx.Err() == nil {
+			consumed, err := q.consume(ctx, out)
+			if ctx.Err() != nil {
+				return
+			}
+			if consumed {
+				delay = restartMinDelay
+			}
+			fmt.Printf("[NATS] Consumer stopped: %v, restarting in %s\n", err, delay)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(delay):
+			}
+			delay = min(delay*2, restartMaxDelay)
+		}
+	}()
+	return out, nil
+}
+
+// consume forwards messages from one iterator until it fails, and reports
+// whether it got as far as consuming.
+func (q *TaskQueue) consume(ctx context.Context, out chan<- queue.Delivery) (bool, error) {
+	msgs, err := q.consumer.Messages(jetstream.PullMaxMessages(1))
+	if err != nil {
+		return false, fmt.Errorf("error while starting consumer: %v", err)
+	}
+	defer msgs.Stop()
+	for {
+		msg, err := msgs.Next(jetstream.NextContext(ctx))
+		if err != nil {
+			if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+				return true, ctx.Err()
+			}
+			return true, err
+		}
+		select {
+		case <-ctx.Done():
+			_ = msg.Nak()
+			return true, ctx.Err()
+		case out <- delivery{msg}:
+		}
+	}
+}
+
+type delivery struct {
+	msg jetstream.Msg
+}
+
+func (d delivery) Body() []byte {
+	return d.msg.Data()
+}
+
+func (d delivery) Ack() error {
+	return d.msg.Ack()
+}
+
+// Nack without requeue terminates the message so the server never redelivers
+// it.
+func (d delivery) Nack(requeue bool) error {
+	if requeue {
+		return d.msg.Nak()
+	}
+	return d.msg.Term()
+}
+
+func (d delivery) Requeue(delay time.Duration) error {
+	return d.msg.NakWithDelay(delay)
+}
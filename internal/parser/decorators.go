@@ -0,0 +1,244 @@
+package parser
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	u "github.com/Sayan-995/dwop/internal/utils"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+var (
+	decoratorRe = regexp.MustCompile(`^@(\w+)(?:\((.*)\))?\s*$`)
+)
+
+type Decorator struct {
+	Name   string
+	Args   []DecoratorArg
+	Line   int
+	Column int
+}
+
+type DecoratorArg struct {
+	Key   string
+	Value string
+}
+
+type decoratorFunc func(spec *TaskSpec, d Decorator) error
+
+var decorators = map[string]decoratorFunc{
+	"retries":      applyRetries,
+	"timeout":      applyTimeout,
+	"image":        applyImage,
+	"resources":    applyResources,
+	"map":          applyMap,
+	"trigger_rule": applyTriggerRule,
+	"cache":        applyCache,
+	"pool":         applyPool,
+}
+
+func parseDecorator(line string, lineNo int) (*Decorator, error) {
+	match := decoratorRe.FindStringSubmatchIndex(line)
+	if match == nil {
+		return nil, nil
+	}
+	d := &Decorator{
+		Name:   line[match[2]:match[3]],
+		Line:   lineNo,
+		Column: match[0] + 1,
+	}
+	if match[4] >= 0 {
+		args, err := splitArgs(line[match[4]:match[5]])
+		if err != nil {
+			return nil, err
+		}
+		d.Args = args
+	}
+	return d, nil
+}
+
+// splitArgs splits `a, key="b,c"` into positional and keyword arguments,
+// honouring double quoted strings.
+func splitArgs(s string) ([]DecoratorArg, error) {
+	var args []DecoratorArg
+	var parts []string
+	var cur strings.Builder
+	inQuote := false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '"' && (i == 0 || s[i-1] != '\\'):
+			inQuote = !inQuote
+			cur.WriteByte(c)
+		case c == ',' && !inQuote:
+			parts = append(parts, cur.String())
+			cur.Reset()
+		default:
+			cur.WriteByte(c)
+		}
+	}
+	if inQuote {
+		return nil, fmt.Errorf("unterminated string")
+	}
+	if strings.TrimSpace(cur.String()) != "" || len(parts) > 0 {
+		parts = append(parts, cur.String())
+	}
+	for _, part := range parts {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			return nil, fmt.Errorf("empty argument")
+		}
+		var arg DecoratorArg
+		if k, v, ok := strings.Cut(part, "="); ok && !strings.HasPrefix(part, `"`) {
+			arg.Key = strings.TrimSpace(k)
+			part = strings.TrimSpace(v)
+		}
+		if strings.HasPrefix(part, `"`) {
+			v, err := strconv.Unquote(part)
+			if err != nil {
+				return nil, fmt.Errorf("invalid string %s", part)
+			}
+			part = v
+		}
+		arg.Value = part
+		args = append(args, arg)
+	}
+	return args, nil
+}
+
+func (d Decorator) diagnostic(spec *TaskSpec, format string, a ...any) Diagnostic {
+	return Diagnostic{
+		Kind:    DiagDecorator,
+		Line:    d.Line,
+		Column:  d.Column,
+		Tasks:   []string{spec.Name},
+		Message: fmt.Sprintf("@%s: %s", d.Name, fmt.Sprintf(format, a...)),
+	}
+}
+
+func (d Decorator) single() (string, error) {
+	if len(d.Args) != 1 || d.Args[0].Key != "" {
+		return "", fmt.Errorf("expects exactly one positional argument")
+	}
+	return d.Args[0].Value, nil
+}
+
+func applyDecorators(spec *TaskSpec, decs []Decorator) error {
+	seen := map[string]bool{}
+	for _, d := range decs {
+		fn, ok := decorators[d.Name]
+		if !ok {
+			return newValidationError(d.diagnostic(spec, "unknown decorator"))
+		}
+		if seen[d.Name] {
+			return newValidationError(d.diagnostic(spec, "declared more than once"))
+		}
+		seen[d.Name] = true
+		if err := fn(spec, d); err != nil {
+			return newValidationError(d.diagnostic(spec, "%v", err))
+		}
+	}
+	return nil
+}
+
+func applyRetries(spec *TaskSpec, d Decorator) error {
+	v, err := d.single()
+	if err != nil {
+		return err
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return fmt.Errorf("retries must be a non-negative integer, got %q", v)
+	}
+	spec.MaxAttempts = n + 1
+	return nil
+}
+
+func applyTimeout(spec *TaskSpec, d Decorator) error {
+	v, err := d.single()
+	if err != nil {
+		return err
+	}
+	timeout, err := time.ParseDuration(v)
+	if err != nil || timeout < time.Second {
+		return fmt.Errorf("timeout must be a duration of at least 1s, got %q", v)
+	}
+	spec.Timeout = timeout
+	return nil
+}
+
+func applyImage(spec *TaskSpec, d Decorator) error {
+	v, err := d.single()
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(v) == "" || strings.ContainsAny(v, " \t") {
+		return fmt.Errorf("invalid image %q", v)
+	}
+	spec.Image = v
+	return nil
+}
+
+func applyResources(spec *TaskSpec, d Decorator) error {
+	if len(d.Args) == 0 {
+		return fmt.Errorf("expects cpu and/or memory")
+	}
+	for _, arg := range d.Args {
+		if arg.Key != "cpu" && arg.Key != "memory" {
+			return fmt.Errorf("unknown resource %q, expected cpu or memory", arg.Key)
+		}
+		if _, err := resource.ParseQuantity(arg.Value); err != nil {
+			return fmt.Errorf("invalid quantity %q for %s", arg.Value, arg.Key)
+		}
+		if arg.Key == "cpu" {
+			spec.Resources.CPU = arg.Value
+		} else {
+			spec.Resources.Memory = arg.Value
+		}
+	}
+	return nil
+}
+
+func applyMap(spec *TaskSpec, d Decorator) error {
+	if len(d.Args) != 1 || d.Args[0].Key != "over" || d.Args[0].Value == "" {
+		return fmt.Errorf("expects over=<predecessor>")
+	}
+	spec.MapOver = d.Args[0].Value
+	return nil
+}
+
+func applyTriggerRule(spec *TaskSpec, d Decorator) error {
+	v, err := d.single()
+	if err != nil {
+		return err
+	}
+	switch rule := u.TriggerRule(v); rule {
+	case u.TriggerAllSuccess, u.TriggerAllDone, u.TriggerOneFailed:
+		spec.TriggerRule = rule
+		return nil
+	}
+	return fmt.Errorf("unknown trigger rule %q, expected %s, %s or %s", v, u.TriggerAllSuccess, u.TriggerAllDone, u.TriggerOneFailed)
+}
+
+func applyCache(spec *TaskSpec, d Decorator) error {
+	if len(d.Args) != 0 {
+		return fmt.Errorf("takes no arguments")
+	}
+	spec.Cache = true
+	return nil
+}
+
+func applyPool(spec *TaskSpec, d Decorator) error {
+	v, err := d.single()
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(v) == "" || strings.ContainsAny(v, " \t,=") {
+		return fmt.Errorf("invalid pool name %q", v)
+	}
+	spec.Pool = v
+	return nil
+}
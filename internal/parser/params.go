@@ -0,0 +1,76 @@
+package parser
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+)
+
+var (
+	paramRe = regexp.MustCompile(`^param\s+(\w+)\s*(?:=\s*(.*?))?\s*$`)
+)
+
+type Param struct {
+	Name     string `json:"name"`
+	Default  any    `json:"default,omitempty"`
+	Required bool   `json:"required"`
+	Line     int    `json:"line"`
+}
+
+func parseParam(line string, lineNo int) (*Param, error) {
+	match := paramRe.FindStringSubmatch(line)
+	if match == nil {
+		return nil, nil
+	}
+	param := &Param{Name: match[1], Line: lineNo, Required: match[2] == ""}
+	if match[2] != "" {
+		if err := json.Unmarshal([]byte(match[2]), &param.Default); err != nil {
+			return nil, newValidationError(Diagnostic{
+				Kind:    DiagParam,
+				Line:    lineNo,
+				Column:  len(line) - len(match[2]) + 1,
+				Message: fmt.Sprintf("default of param %q must be a JSON literal, got %s", param.Name, match[2]),
+			})
+		}
+	}
+	return param, nil
+}
+
+// ResolveParams merges submission-time overrides over the declared defaults.
+func (wf *Workflow) ResolveParams(overrides map[string]any) (map[string]any, error) {
+	var diags []Diagnostic
+	declared := make(map[string]bool, len(wf.Params))
+	resolved := make(map[string]any, len(wf.Params))
+	for _, p := range wf.Params {
+		declared[p.Name] = true
+		if v, ok := overrides[p.Name]; ok {
+			resolved[p.Name] = v
+			continue
+		}
+		if p.Required {
+			diags = append(diags, Diagnostic{
+				Kind:    DiagParam,
+				Line:    p.Line,
+				Column:  1,
+				Message: fmt.Sprintf("param %q has no default and was not supplied", p.Name),
+			})
+			continue
+		}
+		resolved[p.Name] = p.Default
+	}
+	var unknown []string
+	for name := range overrides {
+		if !declared[name] {
+			unknown = append(unknown, name)
+		}
+	}
+	sort.Strings(unknown)
+	for _, name := range unknown {
+		diags = append(diags, Diagnostic{Kind: DiagParam, Message: fmt.Sprintf("unknown param %q", name)})
+	}
+	if len(diags) > 0 {
+		return nil, newValidationError(diags...)
+	}
+	return resolved, nil
+}
@@ -1,15 +1,13 @@
 package parser
 
 import (
-	// "bufio"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"regexp"
 	"strings"
 	"time"
 
-	// "os"
-
-	r "github.com/Sayan-995/dwop/internal/repository"
 	u "github.com/Sayan-995/dwop/internal/utils"
 	"github.com/google/uuid"
 )
@@ -18,63 +16,216 @@ var (
 	headerRe = regexp.MustCompile(`^fun\s+(\w+)\((.*?)\):`)
 )
 
-func ParseWorkflow(workflowId uuid.UUID, content []string) ([]u.Task, error) {
-	var tasks []u.Task
+type Workflow struct {
+	Params []Param `json:"params"`
+	// Priority and MaxRunning come from `priority <n>` and
+	// `max_running <n>` lines before the first task.
+	Priority   int        `json:"priority"`
+	MaxRunning int        `json:"max_running,omitempty"`
+	Tasks      []TaskSpec `json:"tasks"`
+}
+
+type TaskSpec struct {
+	Name   string `json:"name"`
+	Args   []Arg  `json:"args"`
+	Code   string `json:"-"`
+	Line   int    `json:"line"`
+	Column int    `json:"column"`
+
+	MaxAttempts int             `json:"max_attempts,omitempty"`
+	Timeout     time.Duration   `json:"timeout,omitempty"`
+	Image       string          `json:"image,omitempty"`
+	Resources   u.TaskResources `json:"resources"`
+	MapOver     string          `json:"map_over,omitempty"`
+	TriggerRule u.TriggerRule   `json:"trigger_rule,omitempty"`
+	Cache       bool            `json:"cache,omitempty"`
+	Pool        string          `json:"pool,omitempty"`
+}
+
+type Arg struct {
+	Name   string `json:"name"`
+	Task   string `json:"task"`
+	Column int    `json:"column"`
+}
+
+func (t TaskSpec) Predecessors() []string {
+	var preds []string
+	for _, arg := range t.Args {
+		preds = append(preds, arg.Task)
+	}
+	return preds
+}
+
+// HashCode fingerprints a task body so unchanged tasks can be recognised
+// across workflow updates.
+func HashCode(code string) string {
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+// Parse turns DSL source into a validated workflow without touching storage
+// or the database.
+func Parse(content []string) (*Workflow, error) {
+	wf := &Workflow{}
+	var pending []Decorator
+	settingLines := map[string]int{}
 
 	for i := 0; i < len(content); {
 		line := content[i]
-		if match := headerRe.FindStringSubmatch(line); match != nil {
-			name := match[1]
-			params := match[2]
-
-			task := u.Task{
-				TaskId:      uuid.New(),
-				WorkflowId:  workflowId,
-				Name:        name,
-				FuncArgMap:  make(map[string]string),
-				Status:      u.TaskPending,
-				Attempt:     0,
-				MaxAttempts: 5,
-				CreatedAt:   time.Now(),
+		dec, err := parseDecorator(line, i+1)
+		if err != nil {
+			return nil, newValidationError(Diagnostic{
+				Kind:    DiagDecorator,
+				Line:    i + 1,
+				Column:  1,
+				Message: fmt.Sprintf("invalid decorator: %v", err),
+			})
+		}
+		if dec != nil {
+			pending = append(pending, *dec)
+			i++
+			continue
+		}
+		setting, err := parseSetting(line, i+1)
+		if err != nil {
+			return nil, err
+		}
+		if setting != nil && len(pending) == 0 {
+			if err := wf.applySetting(*setting, settingLines[setting.Name]); err != nil {
+				return nil, err
 			}
-
-			if strings.TrimSpace(params) != "" {
-				for _, arg := range strings.Split(params, ",") {
-					parts := strings.Split(strings.TrimSpace(arg), ":")
-					if len(parts) != 2 {
-						return nil, fmt.Errorf("invalid parameter syntax")
-					}
-					parts[0] = strings.TrimSpace(parts[0])
-					parts[1] = strings.TrimSpace(parts[1])
-					task.Predecessors = append(task.Predecessors, parts[1])
-					task.FuncArgMap[parts[1]] = parts[0]
-				}
+			settingLines[setting.Name] = setting.Line
+			i++
+			continue
+		}
+		param, err := parseParam(line, i+1)
+		if err != nil {
+			return nil, err
+		}
+		if param != nil && len(pending) == 0 {
+			if err := wf.addParam(*param); err != nil {
+				return nil, err
+			}
+			i++
+			continue
+		}
+		match := headerRe.FindStringSubmatchIndex(line)
+		if match == nil {
+			if len(pending) > 0 && strings.TrimSpace(line) != "" {
+				return nil, danglingDecorator(pending[0])
 			}
-			currIndent := countIndent(line)
 			i++
-			var code string
-			leadingIndent := countIndent(content[i])
-			for ; i < len(content); i++ {
-				line := content[i]
-				if countIndent(line) > currIndent {
-					if countIndent(line) < leadingIndent {
-						return nil, fmt.Errorf("indentation error of the uplaoded file")
-					}
-					code += line[leadingIndent:] + "\n"
-				} else {
-					break
+			continue
+		}
+		spec := TaskSpec{
+			Name:   line[match[2]:match[3]],
+			Line:   i + 1,
+			Column: match[2] + 1,
+		}
+		if err := applyDecorators(&spec, pending); err != nil {
+			return nil, err
+		}
+		pending = nil
+		params := line[match[4]:match[5]]
+
+		if strings.TrimSpace(params) != "" {
+			offset := match[4]
+			for _, arg := range strings.Split(params, ",") {
+				parts := strings.Split(strings.TrimSpace(arg), ":")
+				if len(parts) != 2 {
+					return nil, newValidationError(Diagnostic{
+						Kind:    DiagSyntax,
+						Line:    i + 1,
+						Column:  offset + countIndent(arg) + 1,
+						Tasks:   []string{spec.Name},
+						Message: fmt.Sprintf("invalid parameter syntax %q, expected <arg>:<task>", strings.TrimSpace(arg)),
+					})
 				}
+				parts[0] = strings.TrimSpace(parts[0])
+				parts[1] = strings.TrimSpace(parts[1])
+				spec.Args = append(spec.Args, Arg{
+					Name:   parts[0],
+					Task:   parts[1],
+					Column: offset + strings.LastIndex(arg, parts[1]) + 1,
+				})
+				offset += len(arg) + 1
 			}
-			_, err := r.StorageClient.UploadFile("Task_Code", fmt.Sprintf("%v/code", task.TaskId), strings.NewReader(code))
-			if err != nil {
-				return nil, fmt.Errorf("error while uploading code to supabase: %v", err)
+		}
+		currIndent := countIndent(line)
+		i++
+		leadingIndent := 0
+		if i < len(content) {
+			leadingIndent = countIndent(content[i])
+		}
+		for ; i < len(content); i++ {
+			line := content[i]
+			if countIndent(line) <= currIndent {
+				break
 			}
-			task.CodeLink = fmt.Sprintf("%v/code", task.TaskId)
-			task.PendingPreds = len(task.Predecessors)
-			tasks = append(tasks, task)
-		} else {
-			i++
+			if countIndent(line) < leadingIndent {
+				return nil, newValidationError(Diagnostic{
+					Kind:    DiagSyntax,
+					Line:    i + 1,
+					Column:  countIndent(line) + 1,
+					Tasks:   []string{spec.Name},
+					Message: fmt.Sprintf("indentation error, expected at least %d spaces", leadingIndent),
+				})
+			}
+			spec.Code += line[leadingIndent:] + "\n"
 		}
+		wf.Tasks = append(wf.Tasks, spec)
+	}
+	if len(pending) > 0 {
+		return nil, danglingDecorator(pending[0])
+	}
+	if err := validateGraph(wf.Tasks); err != nil {
+		return nil, err
+	}
+	return wf, nil
+}
+
+// Build expands a parsed workflow into the task rows persisted for one run.
+func (wf *Workflow) Build(workflowId uuid.UUID) []u.Task {
+	tasks := make([]u.Task, 0, len(wf.Tasks))
+	for _, spec := range wf.Tasks {
+		task := u.Task{
+			TaskId:       uuid.New(),
+			WorkflowId:   workflowId,
+			Name:         spec.Name,
+			CodeHash:     HashCode(spec.Code),
+			FuncArgMap:   make(map[string]string),
+			Predecessors: spec.Predecessors(),
+			Status:       u.TaskPending,
+			Attempt:      0,
+			MaxAttempts:  u.DefaultMaxAttempts,
+			CreatedAt:    time.Now(),
+
+			Image:          spec.Image,
+			TimeoutSeconds: int64(spec.Timeout / time.Second),
+			Resources:      spec.Resources,
+			MapOver:        spec.MapOver,
+			TriggerRule:    u.TriggerAllSuccess,
+			Cache:          spec.Cache,
+			Pool:           spec.Pool,
+		}
+		if spec.MaxAttempts > 0 {
+			task.MaxAttempts = spec.MaxAttempts
+		}
+		if spec.TriggerRule != "" {
+			task.TriggerRule = spec.TriggerRule
+		}
+		for _, arg := range spec.Args {
+			task.FuncArgMap[arg.Task] = arg.Name
+		}
+		task.CodeLink = fmt.Sprintf("%v/code", task.TaskId)
+		task.PendingPreds = len(task.Predecessors)
+		if task.TriggerRule != u.TriggerAllSuccess && task.PendingPreds > 0 {
+			// complete_run_and_enqueue_successors releases a task once its
+			// pending_preds reaches zero; the extra hold leaves tasks with other
+			// trigger rules to the scheduler.
+			task.PendingPreds++
+		}
+		tasks = append(tasks, task)
 	}
 	for _, task := range tasks {
 		for _, p := range task.Predecessors {
@@ -85,7 +236,31 @@ func ParseWorkflow(workflowId uuid.UUID, content []string) ([]u.Task, error) {
 			}
 		}
 	}
-	return tasks, nil
+	return tasks
+}
+
+func (wf *Workflow) addParam(param Param) error {
+	for _, p := range wf.Params {
+		if p.Name == param.Name {
+			return newValidationError(Diagnostic{
+				Kind:    DiagParam,
+				Line:    param.Line,
+				Column:  1,
+				Message: fmt.Sprintf("duplicate param %q, first declared on line %d", param.Name, p.Line),
+			})
+		}
+	}
+	wf.Params = append(wf.Params, param)
+	return nil
+}
+
+func danglingDecorator(d Decorator) error {
+	return newValidationError(Diagnostic{
+		Kind:    DiagDecorator,
+		Line:    d.Line,
+		Column:  d.Column,
+		Message: fmt.Sprintf("@%s must be followed by a fun declaration", d.Name),
+	})
 }
 
 func countIndent(s string) int {
@@ -0,0 +1,75 @@
+package parser
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+
+	u "github.com/Sayan-995/dwop/internal/utils"
+)
+
+var (
+	settingRe = regexp.MustCompile(`^(priority|max_running)\s+(\S+)\s*$`)
+)
+
+// Setting is a workflow-wide `<name> <n>` line in the header, before the
+// first task.
+type Setting struct {
+	Name  string
+	Value int
+	Line  int
+}
+
+func parseSetting(line string, lineNo int) (*Setting, error) {
+	match := settingRe.FindStringSubmatch(line)
+	if match == nil {
+		return nil, nil
+	}
+	setting := &Setting{Name: match[1], Line: lineNo}
+	n, err := strconv.Atoi(match[2])
+	switch {
+	case setting.Name == "priority" && (err != nil || n < 0 || n > u.MaxPriority):
+		return nil, setting.invalid(line, match[2], fmt.Sprintf("an integer from 0 to %d", u.MaxPriority))
+	case setting.Name == "max_running" && (err != nil || n < 1):
+		return nil, setting.invalid(line, match[2], "a positive integer")
+	}
+	setting.Value = n
+	return setting, nil
+}
+
+func (s Setting) invalid(line, value, expected string) error {
+	return newValidationError(Diagnostic{
+		Kind:    DiagSetting,
+		Line:    s.Line,
+		Column:  len(line) - len(value) + 1,
+		Message: fmt.Sprintf("%s must be %s, got %s", s.Name, expected, value),
+	})
+}
+
+// applySetting stores s on the workflow. previous is the line s was already
+// declared on, or 0.
+func (wf *Workflow) applySetting(s Setting, previous int) error {
+	if previous > 0 {
+		return newValidationError(Diagnostic{
+			Kind:    DiagSetting,
+			Line:    s.Line,
+			Column:  1,
+			Message: fmt.Sprintf("duplicate %s, first declared on line %d", s.Name, previous),
+		})
+	}
+	if len(wf.Tasks) > 0 {
+		return newValidationError(Diagnostic{
+			Kind:    DiagSetting,
+			Line:    s.Line,
+			Column:  1,
+			Message: fmt.Sprintf("%s must be declared before the first task", s.Name),
+		})
+	}
+	switch s.Name {
+	case "priority":
+		wf.Priority = s.Value
+	case "max_running":
+		wf.MaxRunning = s.Value
+	}
+	return nil
+}
@@ -0,0 +1,188 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+
+	u "github.com/Sayan-995/dwop/internal/utils"
+)
+
+// DiagnosticKind lets API clients tell problems apart without parsing
+// messages.
+type DiagnosticKind string
+
+const (
+	DiagSyntax              DiagnosticKind = "syntax"
+	DiagDecorator           DiagnosticKind = "decorator"
+	DiagSetting             DiagnosticKind = "setting"
+	DiagParam               DiagnosticKind = "param"
+	DiagDuplicateTask       DiagnosticKind = "duplicate_task"
+	DiagSelfDependency      DiagnosticKind = "self_dependency"
+	DiagUnknownTask         DiagnosticKind = "unknown_task"
+	DiagDuplicateDependency DiagnosticKind = "duplicate_dependency"
+	DiagMapOver             DiagnosticKind = "map_over"
+	DiagCycle               DiagnosticKind = "cycle"
+)
+
+type Diagnostic struct {
+	Kind    DiagnosticKind `json:"kind"`
+	Line    int            `json:"line,omitempty"`
+	Column  int            `json:"column,omitempty"`
+	Tasks   []string       `json:"tasks"`
+	Message string         `json:"message"`
+}
+
+type ValidationError struct {
+	Diagnostics []Diagnostic `json:"diagnostics"`
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, 0, len(e.Diagnostics))
+	for _, d := range e.Diagnostics {
+		if d.Line == 0 {
+			msgs = append(msgs, d.Message)
+			continue
+		}
+		msgs = append(msgs, fmt.Sprintf("%d:%d: %s", d.Line, d.Column, d.Message))
+	}
+	return "invalid workflow: " + strings.Join(msgs, "; ")
+}
+
+func newValidationError(diags ...Diagnostic) *ValidationError {
+	return &ValidationError{Diagnostics: diags}
+}
+
+func validateGraph(tasks []TaskSpec) error {
+	var diags []Diagnostic
+	index := make(map[string]int, len(tasks))
+
+	for i, task := range tasks {
+		if first, ok := index[task.Name]; ok {
+			diags = append(diags, Diagnostic{
+				Kind:    DiagDuplicateTask,
+				Line:    task.Line,
+				Column:  task.Column,
+				Tasks:   []string{task.Name},
+				Message: fmt.Sprintf("duplicate task %q, first defined on line %d", task.Name, tasks[first].Line),
+			})
+			continue
+		}
+		index[task.Name] = i
+	}
+
+	for _, task := range tasks {
+		seen := make(map[string]bool, len(task.Args))
+		for _, arg := range task.Args {
+			pred := arg.Task
+			d := Diagnostic{Line: task.Line, Column: arg.Column, Tasks: []string{task.Name, pred}}
+			switch _, ok := index[pred]; {
+			case pred == task.Name:
+				d.Kind = DiagSelfDependency
+				d.Tasks = []string{task.Name}
+				d.Message = fmt.Sprintf("task %q depends on itself", task.Name)
+			case !ok:
+				d.Kind = DiagUnknownTask
+				d.Message = fmt.Sprintf("task %q depends on unknown task %q", task.Name, pred)
+			case seen[pred]:
+				d.Kind = DiagDuplicateDependency
+				d.Message = fmt.Sprintf("task %q depends on %q more than once", task.Name, pred)
+			default:
+				seen[pred] = true
+				continue
+			}
+			diags = append(diags, d)
+		}
+		if task.MapOver != "" && task.TriggerRule != "" && task.TriggerRule != u.TriggerAllSuccess {
+			diags = append(diags, Diagnostic{
+				Kind:    DiagMapOver,
+				Line:    task.Line,
+				Column:  task.Column,
+				Tasks:   []string{task.Name},
+				Message: fmt.Sprintf("mapped task %q must use the %s trigger rule", task.Name, u.TriggerAllSuccess),
+			})
+		}
+		if task.MapOver != "" && !seen[task.MapOver] {
+			diags = append(diags, Diagnostic{
+				Kind:    DiagMapOver,
+				Line:    task.Line,
+				Column:  task.Column,
+				Tasks:   []string{task.Name, task.MapOver},
+				Message: fmt.Sprintf("task %q maps over %q, which is not one of its predecessors", task.Name, task.MapOver),
+			})
+		}
+	}
+	if len(diags) > 0 {
+		return newValidationError(diags...)
+	}
+
+	for _, cycle := range findCycles(tasks, index) {
+		first := tasks[index[cycle[0]]]
+		diags = append(diags, Diagnostic{
+			Kind:    DiagCycle,
+			Line:    first.Line,
+			Column:  first.Column,
+			Tasks:   cycle,
+			Message: fmt.Sprintf("dependency cycle: %s -> %s", strings.Join(cycle, " -> "), cycle[0]),
+		})
+	}
+	if len(diags) > 0 {
+		return newValidationError(diags...)
+	}
+	return nil
+}
+
+// findCycles walks predecessor edges depth first and returns every cycle it
+// closes, each listed in dependency order starting from its earliest task.
+func findCycles(tasks []TaskSpec, index map[string]int) [][]string {
+	const (
+		unvisited = iota
+		visiting
+		done
+	)
+	state := make([]int, len(tasks))
+	var stack []int
+	var cycles [][]string
+
+	var visit func(i int)
+	visit = func(i int) {
+		state[i] = visiting
+		stack = append(stack, i)
+		for _, arg := range tasks[i].Args {
+			j := index[arg.Task]
+			switch state[j] {
+			case unvisited:
+				visit(j)
+			case visiting:
+				start := len(stack) - 1
+				for stack[start] != j {
+					start--
+				}
+				cycles = append(cycles, cycleNames(tasks, stack[start:]))
+			}
+		}
+		stack = stack[:len(stack)-1]
+		state[i] = done
+	}
+	for i := range tasks {
+		if state[i] == unvisited {
+			visit(i)
+		}
+	}
+	return cycles
+}
+
+func cycleNames(tasks []TaskSpec, members []int) []string {
+	// stack order follows predecessor edges, so reverse it to read as
+	// "a -> b" meaning b runs after a, and rotate to the earliest task.
+	first := 0
+	for k, m := range members {
+		if m < members[first] {
+			first = k
+		}
+	}
+	names := make([]string, 0, len(members))
+	for k := 0; k < len(members); k++ {
+		names = append(names, tasks[members[(first-k+len(members))%len(members)]].Name)
+	}
+	return names
+}
@@ -0,0 +1,104 @@
+package parser
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func parseDiagnostics(t *testing.T, src string) []Diagnostic {
+	t.Helper()
+	_, err := Parse(strings.Split(src, "\n"))
+	var verr *ValidationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("want a ValidationError, got %v", err)
+	}
+	return verr.Diagnostics
+}
+
+func TestParseGraphDiagnostics(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want []Diagnostic
+	}{
+		{
+			name: "cycle",
+			src: `fun a(x:c):
+    return x
+fun b(x:a):
+    return x
+fun c(x:b):
+    return x`,
+			want: []Diagnostic{{Kind: DiagCycle, Line: 1, Column: 5, Tasks: []string{"a", "b", "c"}}},
+		},
+		{
+			name: "self dependency",
+			src: `fun a(x:a):
+    return x`,
+			want: []Diagnostic{{Kind: DiagSelfDependency, Line: 1, Column: 9, Tasks: []string{"a"}}},
+		},
+		{
+			name: "dangling reference",
+			src: `fun a():
+    return 1
+fun b(x:a, y:zzz):
+    return x`,
+			want: []Diagnostic{{Kind: DiagUnknownTask, Line: 3, Column: 14, Tasks: []string{"b", "zzz"}}},
+		},
+		{
+			name: "duplicate task name",
+			src: `fun a():
+    return 1
+fun a():
+    return 2`,
+			want: []Diagnostic{{Kind: DiagDuplicateTask, Line: 3, Column: 5, Tasks: []string{"a"}}},
+		},
+		{
+			name: "all errors reported together",
+			src: `fun a():
+    return 1
+fun a():
+    return 2
+fun b(x:b):
+    return x
+fun c(x:a, y:zzz):
+    return x`,
+			want: []Diagnostic{
+				{Kind: DiagDuplicateTask, Line: 3, Column: 5, Tasks: []string{"a"}},
+				{Kind: DiagSelfDependency, Line: 5, Column: 9, Tasks: []string{"b"}},
+				{Kind: DiagUnknownTask, Line: 7, Column: 14, Tasks: []string{"c", "zzz"}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parseDiagnostics(t, tt.src)
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d diagnostics, want %d: %+v", len(got), len(tt.want), got)
+			}
+			for i, d := range got {
+				if d.Message == "" {
+					t.Errorf("diagnostic %d has no message", i)
+				}
+				d.Message = ""
+				if !reflect.DeepEqual(d, tt.want[i]) {
+					t.Errorf("diagnostic %d is %+v, want %+v", i, d, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestParseCycleMessageFollowsDependencies(t *testing.T) {
+	diags := parseDiagnostics(t, `fun a(x:c):
+    return x
+fun b(x:a):
+    return x
+fun c(x:b):
+    return x`)
+	if want := "dependency cycle: a -> b -> c -> a"; diags[0].Message != want {
+		t.Fatalf("got %q, want %q", diags[0].Message, want)
+	}
+}
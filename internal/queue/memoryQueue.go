@@ -0,0 +1,124 @@
+package queue
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// MemoryQueue hands out the highest priority first, and messages of equal
+// priority in publish order.
+type MemoryQueue struct {
+	mu       sync.Mutex
+	messages []memoryMessage
+	ready    chan struct{}
+}
+
+type memoryMessage struct {
+	body     []byte
+	priority int
+}
+
+func NewMemoryQueue() *MemoryQueue {
+	return &MemoryQueue{ready: make(chan struct{}, 1)}
+}
+
+func (q *MemoryQueue) Publish(body []byte, priority int) error {
+	q.mu.Lock()
+	q.messages = append(q.messages, memoryMessage{body: append([]byte(nil), body...), priority: priority})
+	q.mu.Unlock()
+	q.signal()
+	return nil
+}
+
+func (q *MemoryQueue) signal() {
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+func (q *MemoryQueue) pop() (memoryMessage, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.messages) == 0 {
+		return memoryMessage{}, false
+	}
+	next := 0
+	for i, m := range q.messages {
+		if m.priority > q.messages[next].priority {
+			next = i
+		}
+	}
+	msg := q.messages[next]
+	q.messages = append(q.messages[:next], q.messages[next+1:]...)
+	if len(q.messages) > 0 {
+		q.signal()
+	}
+	return msg, true
+}
+
+func (q *MemoryQueue) Consume(ctx context.Context) (<-chan Delivery, error) {
+	out := make(chan Delivery)
+	go func() {
+		defer close(out)
+		for {
+			msg, ok := q.pop()
+			if !ok {
+				select {
+				case <-ctx.Done():
+					return
+				case <-q.ready:
+					continue
+				}
+			}
+			d := &memoryDelivery{queue: q, memoryMessage: msg, done: make(chan struct{})}
+			select {
+			case <-ctx.Done():
+				_ = d.Nack(true)
+				return
+			case out <- d:
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-d.done:
+			}
+		}
+	}()
+	return out, nil
+}
+
+type memoryDelivery struct {
+	memoryMessage
+	queue *MemoryQueue
+	once  sync.Once
+	done  chan struct{}
+}
+
+func (d *memoryDelivery) Body() []byte {
+	return d.body
+}
+
+func (d *memoryDelivery) Ack() error {
+	d.once.Do(func() { close(d.done) })
+	return nil
+}
+
+func (d *memoryDelivery) Nack(requeue bool) error {
+	d.once.Do(func() {
+		close(d.done)
+		if requeue {
+			_ = d.queue.Publish(d.body, d.priority)
+		}
+	})
+	return nil
+}
+
+func (d *memoryDelivery) Requeue(delay time.Duration) error {
+	d.once.Do(func() {
+		close(d.done)
+		time.AfterFunc(delay, func() { _ = d.queue.Publish(d.body, d.priority) })
+	})
+	return nil
+}
@@ -1,4 +1,4 @@
-package rabitmq
+package queue
 
 import (
 	"encoding/json"
@@ -9,13 +9,8 @@ import (
 	u "github.com/Sayan-995/dwop/internal/utils"
 )
 
-func SendTaskEvents(id int, outboxEvents string, ch chan u.OutboxEvent) {
+func SendTaskEvents(id int, events []u.OutboxEvent, ch chan u.OutboxEvent) {
 	defer close(ch)
-	var events []u.OutboxEvent
-	if err := json.Unmarshal([]byte(outboxEvents), &events); err != nil {
-		fmt.Printf("[SendTaskEvents] ERROR unmarshaling outbox events: %v\n", err)
-		return
-	}
 	fmt.Printf("[SendTaskEvents] Claimer %d: Processing %d outbox events\n", id, len(events))
 	for _, event := range events {
 		res, _ := json.Marshal(event)
@@ -43,7 +38,7 @@ func SendTaskEvents(id int, outboxEvents string, ch chan u.OutboxEvent) {
 			ch <- event
 			continue
 		}
-		err = PublishTask(res)
+		err = Tasks.Publish(res, event.Priority)
 		if err != nil {
 			fmt.Printf("[SendTaskEvents] ERROR publishing event %s: %v\n", event.EventID, err)
 			msg := err.Error()
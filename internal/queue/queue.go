@@ -0,0 +1,35 @@
+package queue
+
+import (
+	"context"
+	"time"
+)
+
+// Delivery is one message handed to a consumer. Each delivery must be acked,
+// nacked or requeued exactly once; a requeued message is delivered again
+// later, possibly to another consumer.
+type Delivery interface {
+	Body() []byte
+	Ack() error
+	// Nack drops the message, or puts it straight back if requeue is set.
+	Nack(requeue bool) error
+	// Requeue puts the message back to be delivered again after delay.
+	Requeue(delay time.Duration) error
+}
+
+// TaskQueue carries TASK_READY events from the outbox claimer to the
+// consumers that start task runs. Delivery is at least once.
+type TaskQueue interface {
+	// Publish enqueues body with a priority from 0 to utils.MaxPriority;
+	// brokers that support it hand out higher priorities first.
+	Publish(body []byte, priority int) error
+	// Consume delivers messages one at a time until ctx is done: the next
+	// message is only handed out after the previous one was settled.
+	Consume(ctx context.Context) (<-chan Delivery, error)
+}
+
+var (
+	Tasks TaskQueue
+	// ConsumerCount is how many consumers take tasks off Tasks in parallel.
+	ConsumerCount = 10
+)
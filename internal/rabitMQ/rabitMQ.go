@@ -1,86 +1,354 @@
 package rabitmq
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"log"
-	"os"
+	"strconv"
+	"sync"
+	"time"
 
+	"github.com/Sayan-995/dwop/internal/queue"
 	u "github.com/Sayan-995/dwop/internal/utils"
-	"github.com/joho/godotenv"
 	amqp "github.com/rabbitmq/amqp091-go"
 )
 
 var (
-	RabbitMQClient        *u.RabbitMQ
 	PublisherChannelCount = 10
-	ConsumerChannelCount  = 10
 	QueueName             = "workflow_queue"
 )
 
-func init() {
-	godotenv.Load()
-	err := NewRabitMQConnection()
-	if err != nil {
-		log.Fatalf("error initializing the rabitMQ connection: %v", err)
-	}
+const (
+	confirmTimeout    = 10 * time.Second
+	reconnectMinDelay = 1 * time.Second
+	reconnectMaxDelay = 30 * time.Second
+)
+
+var (
+	errNotConnected = errors.New("not connected to RabbitMQ")
+)
+
+// TaskQueue is a queue.TaskQueue on RabbitMQ. It holds the connection and
+// redials it whenever it closes. Publisher channels are pooled in confirm
+// mode; a slot whose channel broke holds nil and is reopened on its next use,
+// so the pool never drains.
+type TaskQueue struct {
+	url string
+
+	mu   sync.RWMutex
+	conn *amqp.Connection
+
+	publishers chan *amqp.Channel
 }
 
-func NewRabitMQConnection() error {
-	conn, err := amqp.Dial(os.Getenv("RABBITMQ_CONNECTION_URL"))
-	publisherPool := make(chan *amqp.Channel, PublisherChannelCount)
+func NewTaskQueue(url string) (*TaskQueue, error) {
+	c := &TaskQueue{
+		url:        url,
+		publishers: make(chan *amqp.Channel, PublisherChannelCount),
+	}
+	conn, err := amqp.Dial(c.url)
 	if err != nil {
-		return fmt.Errorf("error getting the connection for rabitMQ: %v", err)
+		return nil, fmt.Errorf("error getting the connection for rabitMQ: %v", err)
 	}
+	c.conn = conn
 	for i := 0; i < PublisherChannelCount; i++ {
-		ch, err := conn.Channel()
+		ch, err := c.openPublisherChan()
 		if err != nil {
-			return fmt.Errorf("error while creating channel for publisherPool: %v", err)
+			conn.Close()
+			return nil, err
 		}
-		_, err = ch.QueueDeclare(
-			QueueName,
-			true,
-			false,
-			false,
-			false,
-			nil,
-		)
-		if err != nil {
-			return fmt.Errorf("error while assigning channel to queue: %v", err)
+		c.publishers <- ch
+	}
+	go c.watch(conn)
+	return c, nil
+}
+
+func (c *TaskQueue) connection() (*amqp.Connection, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.conn == nil || c.conn.IsClosed() {
+		return nil, errNotConnected
+	}
+	return c.conn, nil
+}
+
+// watch redials with exponential backoff every time the connection closes.
+// Channels of the old connection are closed along with it and get reopened
+// by the publishers and consumers using them.
+func (c *TaskQueue) watch(conn *amqp.Connection) {
+	for {
+		// A nil error means the connection was closed through Close.
+		err := <-conn.NotifyClose(make(chan *amqp.Error, 1))
+		if err == nil {
+			return
 		}
-		publisherPool <- ch
+		fmt.Printf("[RabbitMQ] Connection closed: %v, reconnecting\n", err)
+
+		delay := reconnectMinDelay
+		for {
+			next, dialErr := amqp.Dial(c.url)
+			if dialErr == nil {
+				conn = next
+				break
+			}
+			fmt.Printf("[RabbitMQ] Reconnect failed, retrying in %s: %v\n", delay, dialErr)
+			time.Sleep(delay)
+			delay = min(delay*2, reconnectMaxDelay)
+		}
+		c.mu.Lock()
+		c.conn = conn
+		c.mu.Unlock()
+		fmt.Printf("[RabbitMQ] Reconnected\n")
 	}
-	RabbitMQClient = &u.RabbitMQ{
-		Conn:          conn,
-		PublisherPool: publisherPool,
+}
+
+func (c *TaskQueue) Close() error {
+	c.mu.Lock()
+	conn := c.conn
+	c.conn = nil
+	c.mu.Unlock()
+	if conn == nil {
+		return nil
 	}
-	return nil
+	return conn.Close()
+}
+
+func (c *TaskQueue) openPublisherChan() (*amqp.Channel, error) {
+	conn, err := c.connection()
+	if err != nil {
+		return nil, err
+	}
+	ch, err := conn.Channel()
+	if err != nil {
+		return nil, fmt.Errorf("error while creating channel for publisherPool: %v", err)
+	}
+	if err := ch.Confirm(false); err != nil {
+		ch.Close()
+		return nil, fmt.Errorf("error while putting channel in confirm mode: %v", err)
+	}
+	if _, err := declareQueue(ch); err != nil {
+		ch.Close()
+		return nil, err
+	}
+	return ch, nil
 }
 
-func GetPublisherChan() *amqp.Channel {
-	return <-RabbitMQClient.PublisherPool
+// declareQueue declares QueueName as a priority queue. RabbitMQ cannot add
+// x-max-priority to an existing queue, so a workflow_queue declared by an
+// older version has to be drained and deleted first.
+func declareQueue(ch *amqp.Channel) (amqp.Queue, error) {
+	q, err := ch.QueueDeclare(
+		QueueName,
+		true,
+		false,
+		false,
+		false,
+		amqp.Table{"x-max-priority": u.MaxPriority},
+	)
+	if err != nil {
+		return q, fmt.Errorf("error while assigning channel to queue: %v", err)
+	}
+	return q, nil
 }
 
-func putPublisherChan(ch *amqp.Channel) {
-	RabbitMQClient.PublisherPool <- ch
+// getPublisherChan takes a slot from the pool, reopening its channel if it is
+// missing or closed. The slot must be returned with putPublisherChan, with a
+// nil channel if it broke.
+func (c *TaskQueue) getPublisherChan() (*amqp.Channel, error) {
+	ch := <-c.publishers
+	if ch != nil && !ch.IsClosed() {
+		return ch, nil
+	}
+	ch, err := c.openPublisherChan()
+	if err != nil {
+		c.putPublisherChan(nil)
+		return nil, err
+	}
+	return ch, nil
 }
 
-func PublishTask(body []byte) error {
-	ch := GetPublisherChan()
-	err := ch.Publish(
+func (c *TaskQueue) putPublisherChan(ch *amqp.Channel) {
+	c.publishers <- ch
+}
+
+// Publish returns only once the broker confirmed the message, so an event is
+// never marked published for a message the broker lost.
+func (c *TaskQueue) Publish(body []byte, priority int) error {
+	return c.publish(QueueName, body, priority, "")
+}
+
+// publish sends body to the named queue with publisher confirms. A non-empty
+// expiration is the message TTL in milliseconds.
+func (c *TaskQueue) publish(queueName string, body []byte, priority int, expiration string) error {
+	ch, err := c.getPublisherChan()
+	if err != nil {
+		return fmt.Errorf("error publishing message: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), confirmTimeout)
+	defer cancel()
+	confirm, err := ch.PublishWithDeferredConfirmWithContext(
+		ctx,
 		"",
-		QueueName,
+		queueName,
 		false,
 		false,
 		amqp.Publishing{
 			ContentType:  "application/json",
 			DeliveryMode: amqp.Persistent,
+			Priority:     uint8(min(max(priority, 0), u.MaxPriority)),
+			Expiration:   expiration,
 			Body:         body,
 		})
+	if err == nil {
+		var acked bool
+		acked, err = confirm.WaitContext(ctx)
+		if err == nil && !acked {
+			err = errors.New("broker nacked the message")
+		}
+	}
 	if err != nil {
-		fmt.Printf("[PublishTask] Publish error: %v, closing channel and not returning to pool\n", err)
+		// Late confirms would be attributed to the next message, so the
+		// channel is not reused.
+		fmt.Printf("[PublishTask] Publish error: %v, replacing channel\n", err)
 		ch.Close()
+		c.putPublisherChan(nil)
 		return fmt.Errorf("error publishing message: %v", err)
 	}
-	putPublisherChan(ch)
+	c.putPublisherChan(ch)
 	return nil
 }
+
+// delayQueue declares the queue that holds messages requeued with delay. Its
+// messages expire after delay and are dead-lettered back onto QueueName. One
+// queue per delay keeps a long delay from holding up shorter ones, and unused
+// delay queues are removed by the broker.
+func (c *TaskQueue) delayQueue(delay time.Duration) (string, error) {
+	ms := max(delay.Milliseconds(), 1)
+	name := fmt.Sprintf("%s.delay.%d", QueueName, ms)
+	ch, err := c.getPublisherChan()
+	if err != nil {
+		return "", err
+	}
+	_, err = ch.QueueDeclare(name, true, false, false, false, amqp.Table{
+		"x-message-ttl":             ms,
+		"x-dead-letter-exchange":    "",
+		"x-dead-letter-routing-key": QueueName,
+		"x-expires":                 ms + time.Hour.Milliseconds(),
+	})
+	if err != nil {
+		// A failed declare closes the channel.
+		c.putPublisherChan(nil)
+		return "", fmt.Errorf("error declaring delay queue %s: %v", name, err)
+	}
+	c.putPublisherChan(ch)
+	return name, nil
+}
+
+// Consume keeps delivering across channel and connection failures until ctx
+// is done. Messages that were unacked when a channel broke are redelivered by
+// the broker; acking them on the dead channel fails harmlessly.
+func (c *TaskQueue) Consume(ctx context.Context) (<-chan queue.Delivery, error) {
+	out := make(chan queue.Delivery)
+	go func() {
+		defer close(out)
+		delay := reconnectMinDelay
+		for ctx.Err() == nil {
+			consumed, err := c.consume(ctx, out)
+			if ctx.Err() != nil {
+				return
+			}
+			if consumed {
+				delay = reconnectMinDelay
+			}
+			fmt.Printf("[RabbitMQ] Consumer stopped: %v, restarting in %s\n", err, delay)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(delay):
+			}
+			delay = min(delay*2, reconnectMaxDelay)
+		}
+	}()
+	return out, nil
+}
+
+// consume forwards deliveries from one channel until it closes, and reports
+// whether it got as far as consuming.
+func (c *TaskQueue) consume(ctx context.Context, out chan<- queue.Delivery) (bool, error) {
+	conn, err := c.connection()
+	if err != nil {
+		return false, err
+	}
+	ch, err := conn.Channel()
+	if err != nil {
+		return false, fmt.Errorf("error getting the consumer channel: %v", err)
+	}
+	defer ch.Close()
+	if err := ch.Qos(1, 0, false); err != nil {
+		return false, fmt.Errorf("error while setting consumer's qos: %v", err)
+	}
+	if _, err := declareQueue(ch); err != nil {
+		return false, err
+	}
+	msgs, err := ch.Consume(
+		QueueName,
+		"",
+		false,
+		false,
+		false,
+		false,
+		nil,
+	)
+	if err != nil {
+		return false, fmt.Errorf("error while starting consumer: %v", err)
+	}
+	closed := ch.NotifyClose(make(chan *amqp.Error, 1))
+	for {
+		select {
+		case <-ctx.Done():
+			return true, ctx.Err()
+		case err := <-closed:
+			return true, fmt.Errorf("channel closed: %v", err)
+		case d, ok := <-msgs:
+			if !ok {
+				return true, errors.New("delivery channel closed")
+			}
+			select {
+			case <-ctx.Done():
+				return true, ctx.Err()
+			case out <- delivery{Delivery: d, queue: c}:
+			}
+		}
+	}
+}
+
+type delivery struct {
+	amqp.Delivery
+	queue *TaskQueue
+}
+
+func (d delivery) Body() []byte {
+	return d.Delivery.Body
+}
+
+func (d delivery) Ack() error {
+	return d.Delivery.Ack(false)
+}
+
+func (d delivery) Nack(requeue bool) error {
+	return d.Delivery.Nack(false, requeue)
+}
+
+// Requeue publishes a copy to the delay queue before acking the original, so
+// the message is never lost; at worst it is delivered twice.
+func (d delivery) Requeue(delay time.Duration) error {
+	name, err := d.queue.delayQueue(delay)
+	if err == nil {
+		err = d.queue.publish(name, d.Delivery.Body, int(d.Delivery.Priority), strconv.FormatInt(max(delay.Milliseconds(), 1), 10))
+	}
+	if err != nil {
+		fmt.Printf("[RabbitMQ] Delayed requeue failed, requeueing now: %v\n", err)
+		return d.Delivery.Nack(false, true)
+	}
+	return d.Delivery.Ack(false)
+}
@@ -0,0 +1,83 @@
+package repository
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	u "github.com/Sayan-995/dwop/internal/utils"
+	"github.com/google/uuid"
+	"github.com/supabase-community/postgrest-go"
+)
+
+func (r *SupabaseRepository) CreateDefinitionVersion(definition u.WorkflowDefinition) (*u.WorkflowDefinition, error) {
+	data := r.db.Rpc("create_workflow_definition", "", map[string]any{"definition": definition})
+	if strings.HasPrefix(strings.TrimSpace(data), `{"code"`) {
+		return nil, fmt.Errorf("[CreateDefinitionVersion] RPC error: %s", data)
+	}
+	var rows []u.WorkflowDefinition
+	if err := json.Unmarshal([]byte(data), &rows); err != nil {
+		return nil, fmt.Errorf("[CreateDefinitionVersion] invalid response: %v", err)
+	}
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("[CreateDefinitionVersion] no definition returned for %s", definition.Name)
+	}
+	return &rows[0], nil
+}
+
+func (r *SupabaseRepository) GetDefinitionVersion(name string, version int) (*u.WorkflowDefinition, error) {
+	query := r.db.From("workflow_definitions").Select("*", "", false).Eq("name", name)
+	if version > 0 {
+		query = query.Eq("version", fmt.Sprint(version))
+	} else {
+		query = query.Order("version", &postgrest.OrderOpts{Ascending: false}).Limit(1, "")
+	}
+	data, _, err := query.Execute()
+	if err != nil {
+		return nil, err
+	}
+	var rows []u.WorkflowDefinition
+	if err := json.Unmarshal(data, &rows); err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, nil
+	}
+	return &rows[0], nil
+}
+
+func (r *SupabaseRepository) ListDefinitions(opts ListOptions) ([]u.WorkflowDefinition, int64, error) {
+	data, count, err := opts.apply(r.db.From("latest_workflow_definitions").Select("*", "exact", false)).Execute()
+	if err != nil {
+		return nil, 0, fmt.Errorf("[ListDefinitions] %v", err)
+	}
+	var rows []u.WorkflowDefinition
+	if err := json.Unmarshal(data, &rows); err != nil {
+		return nil, 0, err
+	}
+	return rows, count, nil
+}
+
+func (r *SupabaseRepository) ListDefinitionVersions(name string, opts ListOptions) ([]u.WorkflowDefinition, int64, error) {
+	data, count, err := opts.apply(r.db.From("workflow_definitions").Select("*", "exact", false).Eq("name", name)).Execute()
+	if err != nil {
+		return nil, 0, fmt.Errorf("[ListDefinitionVersions] %v", err)
+	}
+	var rows []u.WorkflowDefinition
+	if err := json.Unmarshal(data, &rows); err != nil {
+		return nil, 0, err
+	}
+	return rows, count, nil
+}
+
+func (r *SupabaseRepository) ListDefinitionRuns(definitionId uuid.UUID, opts ListOptions) ([]u.Workflow, int64, error) {
+	data, count, err := opts.apply(r.db.From("workflows").Select("*", "exact", false).Eq("definition_id", definitionId.String())).Execute()
+	if err != nil {
+		return nil, 0, fmt.Errorf("[ListDefinitionRuns] %v", err)
+	}
+	var rows []u.Workflow
+	if err := json.Unmarshal(data, &rows); err != nil {
+		return nil, 0, err
+	}
+	return rows, count, nil
+}
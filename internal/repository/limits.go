@@ -0,0 +1,128 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	u "github.com/Sayan-995/dwop/internal/utils"
+	"github.com/google/uuid"
+)
+
+// ErrRunLimit is returned by UpsertTaskRun when starting the run would exceed
+// a concurrency limit.
+var ErrRunLimit = errors.New("concurrency limit reached")
+
+// RunLimits caps how many task runs may be running at once. Zero means no
+// limit.
+type RunLimits struct {
+	Global int
+	// PerWorkflow applies to workflows that do not set max_running.
+	PerWorkflow int
+	// Pools maps pool names to their slots. Tasks in a pool that is not
+	// listed are not limited by it.
+	Pools map[string]int
+}
+
+var (
+	Limits RunLimits
+)
+
+// LimitsFromEnv reads DWOP_MAX_RUNNING, DWOP_MAX_RUNNING_PER_WORKFLOW and
+// DWOP_POOLS, a comma separated list of name=slots pairs.
+func LimitsFromEnv() (RunLimits, error) {
+	var l RunLimits
+	var err error
+	if l.Global, err = envLimit("DWOP_MAX_RUNNING"); err != nil {
+		return l, err
+	}
+	if l.PerWorkflow, err = envLimit("DWOP_MAX_RUNNING_PER_WORKFLOW"); err != nil {
+		return l, err
+	}
+	pools := strings.TrimSpace(os.Getenv("DWOP_POOLS"))
+	if pools == "" {
+		return l, nil
+	}
+	l.Pools = make(map[string]int)
+	for _, pair := range strings.Split(pools, ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		slots, err := strconv.Atoi(value)
+		if !ok || name == "" || err != nil || slots < 1 {
+			return l, fmt.Errorf("invalid DWOP_POOLS entry %q, expected <name>=<slots>", pair)
+		}
+		l.Pools[name] = slots
+	}
+	return l, nil
+}
+
+func envLimit(key string) (int, error) {
+	raw := os.Getenv(key)
+	if raw == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid %s %q, expected a non-negative integer", key, raw)
+	}
+	return n, nil
+}
+
+func (l RunLimits) Enabled() bool {
+	return l.Global > 0 || l.PerWorkflow > 0 || len(l.Pools) > 0
+}
+
+// Check returns an error wrapping ErrRunLimit if one more run of task would
+// exceed a limit, given what is running now.
+func (l RunLimits) Check(counts RunningCounts, workflow u.Workflow, task u.Task) error {
+	if !Counted(task) {
+		return nil
+	}
+	if l.Global > 0 && counts.Global >= l.Global {
+		return fmt.Errorf("%w: %d of %d runs are running", ErrRunLimit, counts.Global, l.Global)
+	}
+	perWorkflow := l.PerWorkflow
+	if workflow.MaxRunning > 0 {
+		perWorkflow = workflow.MaxRunning
+	}
+	if n := counts.Workflows[workflow.WorkflowId]; perWorkflow > 0 && n >= perWorkflow {
+		return fmt.Errorf("%w: workflow %s has %d of %d runs running", ErrRunLimit, workflow.WorkflowId, n, perWorkflow)
+	}
+	if slots, ok := l.Pools[task.Pool]; ok && task.Pool != "" && counts.Pools[task.Pool] >= slots {
+		return fmt.Errorf("%w: pool %q has %d of %d slots in use", ErrRunLimit, task.Pool, counts.Pools[task.Pool], slots)
+	}
+	return nil
+}
+
+// Counted reports whether a run of task takes a slot. A mapped parent only
+// stands for its children, which take slots of their own.
+func Counted(task u.Task) bool {
+	return task.MapOver == "" || task.ParentTaskId != nil
+}
+
+// RunningCounts is how many runs that take a slot are running, in total, per
+// workflow and per pool. Runs of workflows that are no longer running are not
+// counted.
+type RunningCounts struct {
+	Global    int
+	Workflows map[uuid.UUID]int
+	Pools     map[string]int
+}
+
+func (c *RunningCounts) add(workflowId uuid.UUID, pool string, n int) {
+	if c.Workflows == nil {
+		c.Workflows = make(map[uuid.UUID]int)
+		c.Pools = make(map[string]int)
+	}
+	c.Global += n
+	c.Workflows[workflowId] += n
+	if pool != "" {
+		c.Pools[pool] += n
+	}
+}
+
+// Add counts one more running run of task.
+func (c *RunningCounts) Add(task u.Task) {
+	c.add(task.WorkflowId, task.Pool, 1)
+}
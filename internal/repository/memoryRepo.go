@@ -0,0 +1,887 @@
+package repository
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	u "github.com/Sayan-995/dwop/internal/utils"
+	"github.com/google/uuid"
+)
+
+// MemoryRepository keeps all state in process memory behind one lock, which
+// makes every method atomic. It backs `dwop dev` and tests; nothing survives
+// a restart.
+type MemoryRepository struct {
+	mu        sync.Mutex
+	workflows map[uuid.UUID]u.Workflow
+	tasks     map[uuid.UUID]u.Task
+	runs      map[uuid.UUID]u.TaskRun
+	events    []u.OutboxEvent
+	schedules map[uuid.UUID]u.Schedule
+	// definitions holds the versions of each definition, oldest first.
+	definitions map[string][]u.WorkflowDefinition
+}
+
+func NewMemoryRepository() *MemoryRepository {
+	return &MemoryRepository{
+		workflows:   map[uuid.UUID]u.Workflow{},
+		tasks:       map[uuid.UUID]u.Task{},
+		runs:        map[uuid.UUID]u.TaskRun{},
+		schedules:   map[uuid.UUID]u.Schedule{},
+		definitions: map[string][]u.WorkflowDefinition{},
+	}
+}
+
+// page applies the filters, newest-first ordering and paging of opts.
+func page[T any](rows []T, opts ListOptions, status func(T) string, createdAt func(T) time.Time) ([]T, int64) {
+	var matched []T
+	for _, row := range rows {
+		if opts.Status != "" && status(row) != opts.Status {
+			continue
+		}
+		created := createdAt(row)
+		if opts.CreatedAfter != nil && created.Before(*opts.CreatedAfter) {
+			continue
+		}
+		if opts.CreatedBefore != nil && !created.Before(*opts.CreatedBefore) {
+			continue
+		}
+		matched = append(matched, row)
+	}
+	sort.SliceStable(matched, func(i, j int) bool { return createdAt(matched[i]).After(createdAt(matched[j])) })
+	total := int64(len(matched))
+	start := min(opts.Offset, len(matched))
+	end := min(start+opts.Limit, len(matched))
+	return matched[start:end], total
+}
+
+func (r *MemoryRepository) InsertWorkflow(workflow u.Workflow, tasks []u.Task) error {
+	if workflow.Status == "" {
+		workflow.Status = u.RunRunning
+	}
+	events := readyEvents(workflow.WorkflowId, tasks)
+	queued := make(map[uuid.UUID]bool, len(events))
+	for _, event := range events {
+		queued[event.TaskID] = true
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.workflows[workflow.WorkflowId] = workflow
+	for _, task := range tasks {
+		if queued[task.TaskId] {
+			task.Status = u.TaskQueued
+		}
+		r.tasks[task.TaskId] = task
+	}
+	r.addEvents(events...)
+	return nil
+}
+
+func (r *MemoryRepository) GetWorkflowByID(id uuid.UUID) (*u.Workflow, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	workflow, ok := r.workflows[id]
+	if !ok {
+		return nil, nil
+	}
+	return &workflow, nil
+}
+
+func (r *MemoryRepository) GetWorkflowsByStatus(status u.RunStatus) ([]u.Workflow, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var workflows []u.Workflow
+	for _, workflow := range r.workflows {
+		if workflow.Status == status {
+			workflows = append(workflows, workflow)
+		}
+	}
+	return workflows, nil
+}
+
+func (r *MemoryRepository) ListWorkflows(opts ListOptions) ([]u.Workflow, int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	workflows := make([]u.Workflow, 0, len(r.workflows))
+	for _, workflow := range r.workflows {
+		workflows = append(workflows, workflow)
+	}
+	rows, total := page(workflows, opts,
+		func(w u.Workflow) string { return string(w.Status) },
+		func(w u.Workflow) time.Time { return w.CreatedAt })
+	return rows, total, nil
+}
+
+func (r *MemoryRepository) CancelWorkflowById(id string) error {
+	workflowId, err := uuid.Parse(id)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if workflow, ok := r.workflows[workflowId]; ok {
+		now := time.Now()
+		workflow.Status = u.RunCanceled
+		workflow.FinishedAt = &now
+		r.workflows[workflowId] = workflow
+	}
+	return nil
+}
+
+func (r *MemoryRepository) FinishWorkflow(id uuid.UUID, status u.RunStatus, finishedAt time.Time) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	workflow, ok := r.workflows[id]
+	if !ok || workflow.Status != u.RunRunning {
+		return false, nil
+	}
+	workflow.Status = status
+	workflow.FinishedAt = &finishedAt
+	r.workflows[id] = workflow
+	return true, nil
+}
+
+func (r *MemoryRepository) GetTaskByID(id uuid.UUID) (*u.Task, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	task, ok := r.tasks[id]
+	if !ok {
+		return nil, nil
+	}
+	return &task, nil
+}
+
+func (r *MemoryRepository) GetTaskByName(workflowId uuid.UUID, name string) (*u.Task, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, task := range r.tasks {
+		if task.WorkflowId == workflowId && task.Name == name {
+			return &task, nil
+		}
+	}
+	return nil, nil
+}
+
+func (r *MemoryRepository) GetTasksByWorkflow(workflowId uuid.UUID) ([]u.Task, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var tasks []u.Task
+	for _, task := range r.tasks {
+		if task.WorkflowId == workflowId {
+			tasks = append(tasks, task)
+		}
+	}
+	return tasks, nil
+}
+
+func (r *MemoryRepository) GetChildTasks(parentId uuid.UUID) ([]u.Task, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var tasks []u.Task
+	for _, task := range r.tasks {
+		if task.ParentTaskId != nil && *task.ParentTaskId == parentId {
+			tasks = append(tasks, task)
+		}
+	}
+	sort.Slice(tasks, func(i, j int) bool { return *tasks[i].MapIndex < *tasks[j].MapIndex })
+	return tasks, nil
+}
+
+func (r *MemoryRepository) InsertMapTasks(parent u.Task, children []u.Task) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, child := range children {
+		child.Status = u.TaskQueued
+		r.tasks[child.TaskId] = child
+		r.addEvents(u.OutboxEvent{
+			EventID:         uuid.New(),
+			WorkflowId:      child.WorkflowId,
+			TaskID:          child.TaskId,
+			Type:            u.OutboxTaskReady,
+			CreatedAt:       time.Now(),
+			PublishAttempts: PublishAttempts,
+		})
+	}
+	if task, ok := r.tasks[parent.TaskId]; ok {
+		size := len(children)
+		task.MapSize = &size
+		task.Status = u.TaskRunning
+		r.tasks[parent.TaskId] = task
+	}
+	return nil
+}
+
+func (r *MemoryRepository) ClaimMapCollection(parentId uuid.UUID) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	task, ok := r.tasks[parentId]
+	if !ok || task.MapCollected {
+		return false, nil
+	}
+	task.MapCollected = true
+	r.tasks[parentId] = task
+	return true, nil
+}
+
+func (r *MemoryRepository) TransitionTaskStatus(taskId uuid.UUID, from, to u.TaskStatus) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	task, ok := r.tasks[taskId]
+	if !ok || task.Status != from {
+		return false, nil
+	}
+	task.Status = to
+	r.tasks[taskId] = task
+	if to.Terminal() {
+		r.finishIfDone(task.WorkflowId)
+	}
+	return true, nil
+}
+
+func (r *MemoryRepository) QueueTask(event u.OutboxEvent) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	task, ok := r.tasks[event.TaskID]
+	if !ok || task.Status != u.TaskPending {
+		return false, nil
+	}
+	task.Status = u.TaskQueued
+	r.tasks[task.TaskId] = task
+	r.addEvents(event)
+	return true, nil
+}
+
+// finishIfDone is finishWorkflowIfDone for the memory repository. Callers
+// hold r.mu.
+func (r *MemoryRepository) finishIfDone(workflowId uuid.UUID) {
+	workflow, ok := r.workflows[workflowId]
+	if !ok || workflow.Status != u.RunRunning {
+		return
+	}
+	var tasks []u.Task
+	for _, task := range r.tasks {
+		if task.WorkflowId == workflowId {
+			tasks = append(tasks, task)
+		}
+	}
+	status, done := WorkflowOutcome(tasks)
+	if !done {
+		return
+	}
+	now := time.Now()
+	workflow.Status = status
+	workflow.FinishedAt = &now
+	r.workflows[workflowId] = workflow
+}
+
+func (r *MemoryRepository) ApplyRerun(rerun Rerun) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	drop := make(map[uuid.UUID]bool, len(rerun.DropMaps))
+	for _, id := range rerun.DropMaps {
+		drop[id] = true
+	}
+	for id, task := range r.tasks {
+		if task.ParentTaskId == nil || !drop[*task.ParentTaskId] {
+			continue
+		}
+		delete(r.tasks, id)
+		for runId, run := range r.runs {
+			if run.TaskId == id {
+				delete(r.runs, runId)
+			}
+		}
+	}
+	for _, task := range rerun.Tasks {
+		if stored, ok := r.tasks[task.TaskId]; ok {
+			stored.Status = task.Status
+			stored.Attempt = task.Attempt
+			stored.PendingPreds = task.PendingPreds
+			stored.MapSize = task.MapSize
+			stored.MapCollected = task.MapCollected
+			r.tasks[task.TaskId] = stored
+		}
+	}
+	if workflow, ok := r.workflows[rerun.WorkflowId]; ok {
+		workflow.Status = u.RunRunning
+		workflow.FinishedAt = nil
+		r.workflows[rerun.WorkflowId] = workflow
+	}
+	r.addEvents(rerun.Events...)
+	return nil
+}
+
+func (r *MemoryRepository) SetTaskCacheKey(taskId uuid.UUID, key string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if task, ok := r.tasks[taskId]; ok {
+		task.CacheKey = key
+		r.tasks[taskId] = task
+	}
+	return nil
+}
+
+func (r *MemoryRepository) UpsertTaskRun(taskRun u.TaskRun) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	task, ok := r.tasks[taskRun.TaskId]
+	if !ok || task.Status == u.TaskRunning || task.Status.Terminal() {
+		return 0, nil
+	}
+	if Limits.Enabled() {
+		if err := Limits.Check(r.countRunning(), r.workflows[task.WorkflowId], task); err != nil {
+			return 0, err
+		}
+	}
+	if taskRun.Status == "" {
+		taskRun.Status = u.TaskRunning
+	}
+	taskRun.Attempt = task.Attempt + 1
+	r.runs[taskRun.RunId] = taskRun
+	task.Status = u.TaskRunning
+	r.tasks[task.TaskId] = task
+	return 1, nil
+}
+
+func (r *MemoryRepository) AbandonTaskRun(runId uuid.UUID, reason string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	run, ok := r.runs[runId]
+	if !ok || run.Status != u.TaskRunning {
+		return nil
+	}
+	now := time.Now()
+	run.Status = u.TaskFailed
+	run.LastError = &reason
+	run.UpdatedAt = &now
+	r.runs[runId] = run
+	if task, ok := r.tasks[run.TaskId]; ok && task.Status == u.TaskRunning {
+		task.Status = u.TaskQueued
+		r.tasks[task.TaskId] = task
+	}
+	return nil
+}
+
+// countRunning counts like running_run_counts. Callers hold r.mu.
+func (r *MemoryRepository) countRunning() RunningCounts {
+	var counts RunningCounts
+	for _, run := range r.runs {
+		task, ok := r.tasks[run.TaskId]
+		if !ok || run.Status != u.TaskRunning || !Counted(task) {
+			continue
+		}
+		if r.workflows[run.WorkflowId].Status != u.RunRunning {
+			continue
+		}
+		counts.Add(task)
+	}
+	return counts
+}
+
+func (r *MemoryRepository) CountRunningRuns() (RunningCounts, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.countRunning(), nil
+}
+
+func (r *MemoryRepository) GetLatestTaskRun(taskId uuid.UUID) (*u.TaskRun, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var latest *u.TaskRun
+	for _, run := range r.runs {
+		if run.TaskId == taskId && (latest == nil || run.CreatedAt.After(latest.CreatedAt)) {
+			run := run
+			latest = &run
+		}
+	}
+	return latest, nil
+}
+
+func (r *MemoryRepository) GetTaskRun(runId uuid.UUID) (*u.TaskRun, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	run, ok := r.runs[runId]
+	if !ok {
+		return nil, nil
+	}
+	return &run, nil
+}
+
+func (r *MemoryRepository) ListTaskRuns(taskId uuid.UUID, opts ListOptions) ([]u.TaskRun, int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var runs []u.TaskRun
+	for _, run := range r.runs {
+		if run.TaskId == taskId {
+			runs = append(runs, run)
+		}
+	}
+	rows, total := page(runs, opts,
+		func(run u.TaskRun) string { return string(run.Status) },
+		func(run u.TaskRun) time.Time { return run.CreatedAt })
+	return rows, total, nil
+}
+
+// runningRun returns the run if it still has no outcome. Callers hold r.mu.
+func (r *MemoryRepository) runningRun(runId string) (u.TaskRun, bool) {
+	id, err := uuid.Parse(runId)
+	if err != nil {
+		return u.TaskRun{}, false
+	}
+	run, ok := r.runs[id]
+	return run, ok && run.Status == u.TaskRunning
+}
+
+func (r *MemoryRepository) CompleteRunAndEnqueueSuccessors(runId string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	run, ok := r.runningRun(runId)
+	if !ok {
+		return nil
+	}
+	now := time.Now()
+	run.Status = u.TaskSucceeded
+	run.UpdatedAt = &now
+	r.runs[run.RunId] = run
+
+	task := r.tasks[run.TaskId]
+	task.Status = u.TaskSucceeded
+	r.tasks[task.TaskId] = task
+
+	successors := make(map[string]bool, len(task.Successors))
+	for _, name := range task.Successors {
+		successors[name] = true
+	}
+	for id, succ := range r.tasks {
+		if succ.WorkflowId != run.WorkflowId || !successors[succ.Name] || succ.Status != u.TaskPending {
+			continue
+		}
+		succ.PendingPreds--
+		if succ.PendingPreds == 0 {
+			succ.Status = u.TaskQueued
+			r.addEvents(u.OutboxEvent{
+				EventID:         uuid.New(),
+				WorkflowId:      run.WorkflowId,
+				TaskID:          id,
+				Type:            u.OutboxTaskReady,
+				CreatedAt:       now,
+				PublishAttempts: PublishAttempts,
+			})
+		}
+		r.tasks[id] = succ
+	}
+	r.finishIfDone(run.WorkflowId)
+	return nil
+}
+
+func (r *MemoryRepository) IncreaseAttempt(runId string, errmsg string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	run, ok := r.runningRun(runId)
+	if !ok {
+		return nil
+	}
+	now := time.Now()
+	run.Status = u.TaskFailed
+	run.LastError = &errmsg
+	run.UpdatedAt = &now
+	r.runs[run.RunId] = run
+
+	task := r.tasks[run.TaskId]
+	task.Attempt++
+	if task.Attempt >= task.MaxAttempts {
+		task.Status = u.TaskFailed
+	} else {
+		task.Status = u.TaskQueued
+		r.addEvents(u.OutboxEvent{
+			EventID:         uuid.New(),
+			WorkflowId:      run.WorkflowId,
+			TaskID:          run.TaskId,
+			Type:            u.OutboxTaskRetryReady,
+			CreatedAt:       now,
+			PublishAttempts: PublishAttempts,
+		})
+	}
+	r.tasks[task.TaskId] = task
+	r.finishIfDone(run.WorkflowId)
+	return nil
+}
+
+// addEvents stamps each event with its workflow's priority, like the
+// outbox_events_priority trigger. Callers hold r.mu.
+func (r *MemoryRepository) addEvents(events ...u.OutboxEvent) {
+	for _, event := range events {
+		event.Priority = r.workflows[event.WorkflowId].Priority
+		r.events = append(r.events, event)
+	}
+}
+
+func (r *MemoryRepository) ClaimOutboxEvents(claimerId int) ([]u.OutboxEvent, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var claimed []u.OutboxEvent
+	now := time.Now()
+	order := make([]int, len(r.events))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return r.events[order[a]].Priority > r.events[order[b]].Priority
+	})
+	for _, i := range order {
+		if len(claimed) == ClaimBatchSize {
+			break
+		}
+		event := &r.events[i]
+		if event.PublishedAt != nil || event.ClaimedAt != nil || event.DeadLetteredAt != nil {
+			continue
+		}
+		if event.NextAttemptAt != nil && event.NextAttemptAt.After(now) {
+			continue
+		}
+		claimedBy := claimerId
+		leaseUntil := now.Add(OutboxLease)
+		event.ClaimedAt = &now
+		event.ClaimedBy = &claimedBy
+		event.LeaseUntil = &leaseUntil
+		claimed = append(claimed, *event)
+	}
+	return claimed, nil
+}
+
+func (r *MemoryRepository) ReleaseExpiredOutboxLeases() (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var released int64
+	now := time.Now()
+	for i := range r.events {
+		event := &r.events[i]
+		if event.PublishedAt != nil || event.ClaimedAt == nil || event.LeaseUntil == nil || !event.LeaseUntil.Before(now) {
+			continue
+		}
+		event.ClaimedAt = nil
+		event.ClaimedBy = nil
+		event.LeaseUntil = nil
+		released++
+	}
+	return released, nil
+}
+
+func (r *MemoryRepository) ListStuckOutboxEvents(claimedBefore time.Time, opts ListOptions) ([]u.OutboxEvent, int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var events []u.OutboxEvent
+	for _, event := range r.events {
+		if event.PublishedAt == nil && event.ClaimedAt != nil && event.ClaimedAt.Before(claimedBefore) {
+			events = append(events, event)
+		}
+	}
+	rows, total := page(events, opts,
+		func(u.OutboxEvent) string { return "" },
+		func(event u.OutboxEvent) time.Time { return event.CreatedAt })
+	return rows, total, nil
+}
+
+func (r *MemoryRepository) GetOutboxEvent(id uuid.UUID) (*u.OutboxEvent, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, event := range r.events {
+		if event.EventID == id {
+			return &event, nil
+		}
+	}
+	return nil, nil
+}
+
+func (r *MemoryRepository) ListDeadLetterOutboxEvents(opts ListOptions) ([]u.OutboxEvent, int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var events []u.OutboxEvent
+	for _, event := range r.events {
+		if event.DeadLetteredAt != nil {
+			events = append(events, event)
+		}
+	}
+	rows, total := page(events, opts,
+		func(u.OutboxEvent) string { return "" },
+		func(event u.OutboxEvent) time.Time { return event.CreatedAt })
+	return rows, total, nil
+}
+
+func (r *MemoryRepository) ReplayOutboxEvent(id uuid.UUID) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := range r.events {
+		event := &r.events[i]
+		if event.EventID != id || event.DeadLetteredAt == nil {
+			continue
+		}
+		event.DeadLetteredAt = nil
+		event.NextAttemptAt = nil
+		event.PublishAttempts = PublishAttempts
+		event.ClaimedAt = nil
+		event.ClaimedBy = nil
+		event.LeaseUntil = nil
+		return true, nil
+	}
+	return false, nil
+}
+
+func (r *MemoryRepository) AddOutboxEvent(event u.OutboxEvent) error {
+	return r.AddOutboxEvents([]u.OutboxEvent{event})
+}
+
+func (r *MemoryRepository) AddOutboxEvents(events []u.OutboxEvent) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.addEvents(events...)
+	return nil
+}
+
+func (r *MemoryRepository) UpdateOutboxEvent(event u.OutboxEvent, claimerId int) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := range r.events {
+		if r.events[i].EventID == event.EventID {
+			stored := r.events[i]
+			if stored.ClaimedBy == nil || *stored.ClaimedBy != claimerId || stored.PublishedAt != nil {
+				return false, nil
+			}
+			r.events[i].PublishedAt = event.PublishedAt
+			r.events[i].ClaimedAt = event.ClaimedAt
+			r.events[i].ClaimedBy = event.ClaimedBy
+			r.events[i].LeaseUntil = event.LeaseUntil
+			r.events[i].Type = event.Type
+			r.events[i].NextAttemptAt = event.NextAttemptAt
+			r.events[i].DeadLetteredAt = event.DeadLetteredAt
+			r.events[i].PublishAttempts = event.PublishAttempts
+			r.events[i].LastPublishError = event.LastPublishError
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (r *MemoryRepository) DeferOutboxEvent(id uuid.UUID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := range r.events {
+		if r.events[i].EventID == id && r.events[i].DeferredAt == nil {
+			now := time.Now()
+			r.events[i].DeferredAt = &now
+		}
+	}
+	return nil
+}
+
+func (r *MemoryRepository) DeferredOutboxEvents(limit int) ([]u.OutboxEvent, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var events []u.OutboxEvent
+	for _, event := range r.events {
+		if event.DeferredAt != nil {
+			events = append(events, event)
+		}
+	}
+	sort.SliceStable(events, func(i, j int) bool {
+		if events[i].Priority != events[j].Priority {
+			return events[i].Priority > events[j].Priority
+		}
+		return events[i].DeferredAt.Before(*events[j].DeferredAt)
+	})
+	if len(events) > limit {
+		events = events[:limit]
+	}
+	return events, nil
+}
+
+func (r *MemoryRepository) ReleaseDeferredOutboxEvents(ids []uuid.UUID) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	release := make(map[uuid.UUID]bool, len(ids))
+	for _, id := range ids {
+		release[id] = true
+	}
+	var released int64
+	for i := range r.events {
+		event := &r.events[i]
+		if !release[event.EventID] || event.DeferredAt == nil {
+			continue
+		}
+		event.DeferredAt = nil
+		event.PublishedAt = nil
+		event.ClaimedAt = nil
+		event.ClaimedBy = nil
+		event.LeaseUntil = nil
+		event.NextAttemptAt = nil
+		event.PublishAttempts = PublishAttempts
+		released++
+	}
+	return released, nil
+}
+
+func (r *MemoryRepository) InsertSchedule(schedule u.Schedule) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.schedules[schedule.ScheduleId] = schedule
+	return nil
+}
+
+func (r *MemoryRepository) GetSchedule(id uuid.UUID) (*u.Schedule, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	schedule, ok := r.schedules[id]
+	if !ok {
+		return nil, nil
+	}
+	return &schedule, nil
+}
+
+func (r *MemoryRepository) ListSchedules(opts ListOptions) ([]u.Schedule, int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	schedules := make([]u.Schedule, 0, len(r.schedules))
+	for _, schedule := range r.schedules {
+		schedules = append(schedules, schedule)
+	}
+	rows, total := page(schedules, opts,
+		func(s u.Schedule) string { return string(s.Status) },
+		func(s u.Schedule) time.Time { return s.CreatedAt })
+	return rows, total, nil
+}
+
+func (r *MemoryRepository) DueSchedules(now time.Time) ([]u.Schedule, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var due []u.Schedule
+	for _, schedule := range r.schedules {
+		if schedule.Status == u.ScheduleActive && !schedule.NextRunAt.After(now) {
+			due = append(due, schedule)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool { return due[i].NextRunAt.Before(due[j].NextRunAt) })
+	return due, nil
+}
+
+func (r *MemoryRepository) MoveScheduleNextRun(id uuid.UUID, from, to time.Time) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	schedule, ok := r.schedules[id]
+	if !ok || schedule.Status != u.ScheduleActive || !schedule.NextRunAt.Equal(from) {
+		return false, nil
+	}
+	schedule.NextRunAt = to
+	schedule.UpdatedAt = time.Now()
+	r.schedules[id] = schedule
+	return true, nil
+}
+
+func (r *MemoryRepository) SetScheduleStatus(id uuid.UUID, status u.ScheduleStatus, nextRunAt time.Time) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	schedule, ok := r.schedules[id]
+	if !ok {
+		return false, nil
+	}
+	schedule.Status = status
+	schedule.NextRunAt = nextRunAt
+	schedule.UpdatedAt = time.Now()
+	r.schedules[id] = schedule
+	return true, nil
+}
+
+func (r *MemoryRepository) ListScheduleRuns(scheduleId uuid.UUID, opts ListOptions) ([]u.Workflow, int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var workflows []u.Workflow
+	for _, workflow := range r.workflows {
+		if workflow.ScheduleId != nil && *workflow.ScheduleId == scheduleId {
+			workflows = append(workflows, workflow)
+		}
+	}
+	rows, total := page(workflows, opts,
+		func(w u.Workflow) string { return string(w.Status) },
+		func(w u.Workflow) time.Time { return w.CreatedAt })
+	return rows, total, nil
+}
+
+func (r *MemoryRepository) ScheduledRunTimes(scheduleId uuid.UUID, from, to time.Time) ([]time.Time, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var times []time.Time
+	for _, workflow := range r.workflows {
+		if workflow.ScheduleId == nil || *workflow.ScheduleId != scheduleId || workflow.ScheduledFor == nil {
+			continue
+		}
+		if t := *workflow.ScheduledFor; !t.Before(from) && !t.After(to) {
+			times = append(times, t)
+		}
+	}
+	return times, nil
+}
+
+func (r *MemoryRepository) CreateDefinitionVersion(definition u.WorkflowDefinition) (*u.WorkflowDefinition, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	versions := r.definitions[definition.Name]
+	if n := len(versions); n > 0 && versions[n-1].Hash == definition.Hash {
+		latest := versions[n-1]
+		return &latest, nil
+	}
+	definition.Version = len(versions) + 1
+	r.definitions[definition.Name] = append(versions, definition)
+	return &definition, nil
+}
+
+func (r *MemoryRepository) GetDefinitionVersion(name string, version int) (*u.WorkflowDefinition, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	versions := r.definitions[name]
+	if version == 0 {
+		version = len(versions)
+	}
+	if version < 1 || version > len(versions) {
+		return nil, nil
+	}
+	definition := versions[version-1]
+	return &definition, nil
+}
+
+func (r *MemoryRepository) ListDefinitions(opts ListOptions) ([]u.WorkflowDefinition, int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	latest := make([]u.WorkflowDefinition, 0, len(r.definitions))
+	for _, versions := range r.definitions {
+		latest = append(latest, versions[len(versions)-1])
+	}
+	rows, total := page(latest, opts,
+		func(d u.WorkflowDefinition) string { return "" },
+		func(d u.WorkflowDefinition) time.Time { return d.CreatedAt })
+	return rows, total, nil
+}
+
+func (r *MemoryRepository) ListDefinitionVersions(name string, opts ListOptions) ([]u.WorkflowDefinition, int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	rows, total := page(r.definitions[name], opts,
+		func(d u.WorkflowDefinition) string { return "" },
+		func(d u.WorkflowDefinition) time.Time { return d.CreatedAt })
+	return rows, total, nil
+}
+
+func (r *MemoryRepository) ListDefinitionRuns(definitionId uuid.UUID, opts ListOptions) ([]u.Workflow, int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var workflows []u.Workflow
+	for _, workflow := range r.workflows {
+		if workflow.DefinitionId != nil && *workflow.DefinitionId == definitionId {
+			workflows = append(workflows, workflow)
+		}
+	}
+	rows, total := page(workflows, opts,
+		func(w u.Workflow) string { return string(w.Status) },
+		func(w u.Workflow) time.Time { return w.CreatedAt })
+	return rows, total, nil
+}
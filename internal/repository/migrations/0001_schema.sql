@@ -0,0 +1,74 @@
+CREATE TABLE IF NOT EXISTS workflows (
+    workflow_id uuid PRIMARY KEY,
+    env_link    text        NOT NULL DEFAULT '',
+    params      jsonb,
+    created_at  timestamptz NOT NULL DEFAULT now(),
+    finished_at timestamptz,
+    status      text        NOT NULL DEFAULT 'RUNNING'
+);
+
+CREATE INDEX IF NOT EXISTS workflows_status_created_at ON workflows (status, created_at DESC);
+CREATE INDEX IF NOT EXISTS workflows_created_at ON workflows (created_at DESC);
+
+CREATE TABLE IF NOT EXISTS tasks (
+    task_id         uuid PRIMARY KEY,
+    workflow_id     uuid        NOT NULL REFERENCES workflows (workflow_id) ON DELETE CASCADE,
+    name            text        NOT NULL,
+    code_link       text        NOT NULL DEFAULT '',
+    code_hash       text        NOT NULL DEFAULT '',
+    pending_preds   integer     NOT NULL DEFAULT 0,
+    func_arg_map    jsonb,
+    predecessors    text[],
+    successors      text[],
+    status          text        NOT NULL DEFAULT 'PENDING',
+    attempt         integer     NOT NULL DEFAULT 0,
+    max_attempts    integer     NOT NULL DEFAULT 5,
+    created_at      timestamptz NOT NULL DEFAULT now(),
+    image           text        NOT NULL DEFAULT '',
+    timeout_seconds bigint      NOT NULL DEFAULT 0,
+    resources       jsonb,
+    trigger_rule    text        NOT NULL DEFAULT 'all_success',
+    cache           boolean     NOT NULL DEFAULT false,
+    cache_key       text        NOT NULL DEFAULT '',
+    map_over        text        NOT NULL DEFAULT '',
+    parent_task_id  uuid REFERENCES tasks (task_id) ON DELETE CASCADE,
+    map_index       integer,
+    map_size        integer,
+    map_collected   boolean     NOT NULL DEFAULT false,
+    UNIQUE (workflow_id, name)
+);
+
+CREATE INDEX IF NOT EXISTS tasks_parent_task_id ON tasks (parent_task_id, map_index);
+
+CREATE TABLE IF NOT EXISTS task_runs (
+    task_id     uuid        NOT NULL REFERENCES tasks (task_id) ON DELETE CASCADE,
+    run_id      uuid PRIMARY KEY,
+    workflow_id uuid        NOT NULL REFERENCES workflows (workflow_id) ON DELETE CASCADE,
+    status      text        NOT NULL DEFAULT 'RUNNING',
+    last_error  text,
+    lease_owner integer,
+    lease_until timestamptz,
+    created_at  timestamptz NOT NULL DEFAULT now(),
+    updated_at  timestamptz
+);
+
+CREATE INDEX IF NOT EXISTS task_runs_task_id_created_at ON task_runs (task_id, created_at DESC);
+
+-- task_id is not a foreign key: events of mapped children outlive the
+-- children when a rerun drops them, and are discarded by the consumer.
+CREATE TABLE IF NOT EXISTS outbox_events (
+    event_id           uuid PRIMARY KEY,
+    task_id            uuid        NOT NULL,
+    workflow_id        uuid        NOT NULL,
+    event_type         text        NOT NULL,
+    payload            jsonb,
+    created_at         timestamptz NOT NULL DEFAULT now(),
+    published_at       timestamptz,
+    claimed_at         timestamptz,
+    claimed_by         integer,
+    publish_attempts   integer     NOT NULL DEFAULT 0,
+    last_publish_error text
+);
+
+CREATE INDEX IF NOT EXISTS outbox_events_unclaimed ON outbox_events (created_at)
+    WHERE published_at IS NULL AND claimed_at IS NULL;
@@ -0,0 +1,102 @@
+-- Stored procedures called over PostgREST by SupabaseRepository. The pgx
+-- repository runs the same statements from Go inside a transaction.
+
+CREATE OR REPLACE FUNCTION create_workflow_with_tasks_and_outbox(workflow jsonb, tasks jsonb, outbox_events jsonb)
+RETURNS void
+LANGUAGE plpgsql
+AS $$
+BEGIN
+    INSERT INTO workflows (workflow_id, env_link, params, created_at, finished_at, status)
+    SELECT w.workflow_id, coalesce(w.env_link, ''), w.params, coalesce(w.created_at, now()), w.finished_at, coalesce(w.status, 'RUNNING')
+    FROM jsonb_populate_record(NULL::workflows, workflow) w;
+
+    INSERT INTO tasks (task_id, workflow_id, name, code_link, code_hash, pending_preds, func_arg_map, predecessors,
+                       successors, status, attempt, max_attempts, created_at, image, timeout_seconds, resources,
+                       trigger_rule, cache, cache_key, map_over, parent_task_id, map_index, map_size, map_collected)
+    SELECT t.task_id, t.workflow_id, t.name, coalesce(t.code_link, ''), coalesce(t.code_hash, ''), coalesce(t.pending_preds, 0),
+           t.func_arg_map, t.predecessors, t.successors, coalesce(t.status, 'PENDING'), coalesce(t.attempt, 0),
+           coalesce(t.max_attempts, 5), coalesce(t.created_at, now()), coalesce(t.image, ''), coalesce(t.timeout_seconds, 0),
+           t.resources, coalesce(t.trigger_rule, 'all_success'), coalesce(t.cache, false), coalesce(t.cache_key, ''),
+           coalesce(t.map_over, ''), t.parent_task_id, t.map_index, t.map_size, coalesce(t.map_collected, false)
+    FROM jsonb_populate_recordset(NULL::tasks, tasks) t;
+
+    INSERT INTO outbox_events (event_id, task_id, workflow_id, event_type, payload, created_at, publish_attempts)
+    SELECT e.event_id, e.task_id, e.workflow_id, e.event_type, e.payload, coalesce(e.created_at, now()), coalesce(e.publish_attempts, 0)
+    FROM jsonb_populate_recordset(NULL::outbox_events, coalesce(outbox_events, '[]'::jsonb)) e;
+
+    UPDATE tasks SET status = 'QUEUED'
+    WHERE task_id IN (SELECT (e ->> 'task_id')::uuid FROM jsonb_array_elements(coalesce(outbox_events, '[]'::jsonb)) e);
+END;
+$$;
+
+CREATE OR REPLACE FUNCTION claim_outbox_events(claimer_id integer, batch_size integer)
+RETURNS SETOF outbox_events
+LANGUAGE sql
+AS $$
+    UPDATE outbox_events
+    SET claimed_at = now(), claimed_by = claimer_id
+    WHERE event_id IN (
+        SELECT event_id FROM outbox_events
+        WHERE published_at IS NULL AND claimed_at IS NULL
+        ORDER BY created_at
+        LIMIT batch_size
+        FOR UPDATE SKIP LOCKED
+    )
+    RETURNING *;
+$$;
+
+CREATE OR REPLACE FUNCTION complete_run_and_enqueue_successors(p_run_id uuid)
+RETURNS void
+LANGUAGE plpgsql
+AS $$
+DECLARE
+    r task_runs;
+    t tasks;
+BEGIN
+    SELECT * INTO r FROM task_runs WHERE run_id = p_run_id FOR UPDATE;
+    IF NOT FOUND OR r.status <> 'RUNNING' THEN
+        RETURN;
+    END IF;
+    UPDATE task_runs SET status = 'SUCCEEDED', updated_at = now() WHERE run_id = p_run_id;
+    UPDATE tasks SET status = 'SUCCEEDED' WHERE task_id = r.task_id RETURNING * INTO t;
+
+    WITH released AS (
+        UPDATE tasks
+        SET pending_preds = pending_preds - 1,
+            status = CASE WHEN pending_preds = 1 THEN 'QUEUED' ELSE status END
+        WHERE workflow_id = t.workflow_id
+          AND name = ANY (coalesce(t.successors, '{}'))
+          AND status = 'PENDING'
+        RETURNING task_id, status
+    )
+    INSERT INTO outbox_events (event_id, task_id, workflow_id, event_type, created_at, publish_attempts)
+    SELECT gen_random_uuid(), task_id, t.workflow_id, 'TASK_READY', now(), 5
+    FROM released WHERE status = 'QUEUED';
+END;
+$$;
+
+CREATE OR REPLACE FUNCTION increase_attempt(p_run_id uuid, p_error text)
+RETURNS void
+LANGUAGE plpgsql
+AS $$
+DECLARE
+    r task_runs;
+    t tasks;
+BEGIN
+    SELECT * INTO r FROM task_runs WHERE run_id = p_run_id FOR UPDATE;
+    IF NOT FOUND OR r.status <> 'RUNNING' THEN
+        RETURN;
+    END IF;
+    UPDATE task_runs SET status = 'FAILED', last_error = p_error, updated_at = now() WHERE run_id = p_run_id;
+    UPDATE tasks
+    SET attempt = attempt + 1,
+        status = CASE WHEN attempt + 1 >= max_attempts THEN 'FAILED' ELSE 'QUEUED' END
+    WHERE task_id = r.task_id
+    RETURNING * INTO t;
+
+    IF t.status = 'QUEUED' THEN
+        INSERT INTO outbox_events (event_id, task_id, workflow_id, event_type, created_at, publish_attempts)
+        VALUES (gen_random_uuid(), t.task_id, t.workflow_id, 'TASK_RETRY_READY', now(), 5);
+    END IF;
+END;
+$$;
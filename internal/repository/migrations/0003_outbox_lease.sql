@@ -0,0 +1,40 @@
+-- Claims carry a lease. A claimer that dies before publishing leaves its
+-- events claimed; once the lease runs out any claimer releases them again.
+ALTER TABLE outbox_events ADD COLUMN IF NOT EXISTS lease_until timestamptz;
+
+UPDATE outbox_events SET lease_until = claimed_at + interval '2 minutes'
+WHERE claimed_at IS NOT NULL AND published_at IS NULL AND lease_until IS NULL;
+
+CREATE INDEX IF NOT EXISTS outbox_events_claimed ON outbox_events (lease_until)
+    WHERE published_at IS NULL AND claimed_at IS NOT NULL;
+
+DROP FUNCTION IF EXISTS claim_outbox_events(integer, integer);
+
+CREATE OR REPLACE FUNCTION claim_outbox_events(claimer_id integer, batch_size integer, lease_seconds integer)
+RETURNS SETOF outbox_events
+LANGUAGE sql
+AS $$
+    UPDATE outbox_events
+    SET claimed_at = now(), claimed_by = claimer_id, lease_until = now() + make_interval(secs => lease_seconds)
+    WHERE event_id IN (
+        SELECT event_id FROM outbox_events
+        WHERE published_at IS NULL AND claimed_at IS NULL
+        ORDER BY created_at
+        LIMIT batch_size
+        FOR UPDATE SKIP LOCKED
+    )
+    RETURNING *;
+$$;
+
+CREATE OR REPLACE FUNCTION release_expired_outbox_leases()
+RETURNS integer
+LANGUAGE sql
+AS $$
+    WITH released AS (
+        UPDATE outbox_events
+        SET claimed_at = NULL, claimed_by = NULL, lease_until = NULL
+        WHERE published_at IS NULL AND claimed_at IS NOT NULL AND lease_until < now()
+        RETURNING 1
+    )
+    SELECT count(*)::integer FROM released;
+$$;
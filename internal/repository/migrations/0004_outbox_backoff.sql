@@ -0,0 +1,28 @@
+-- Failed publishes are retried on the same event after a backoff. Events that
+-- run out of attempts are dead-lettered until an operator replays them.
+ALTER TABLE outbox_events ADD COLUMN IF NOT EXISTS next_attempt_at timestamptz;
+ALTER TABLE outbox_events ADD COLUMN IF NOT EXISTS dead_lettered_at timestamptz;
+
+DROP INDEX IF EXISTS outbox_events_unclaimed;
+CREATE INDEX IF NOT EXISTS outbox_events_unclaimed ON outbox_events (created_at)
+    WHERE published_at IS NULL AND claimed_at IS NULL AND dead_lettered_at IS NULL;
+
+CREATE INDEX IF NOT EXISTS outbox_events_dead_lettered ON outbox_events (dead_lettered_at)
+    WHERE dead_lettered_at IS NOT NULL;
+
+CREATE OR REPLACE FUNCTION claim_outbox_events(claimer_id integer, batch_size integer, lease_seconds integer)
+RETURNS SETOF outbox_events
+LANGUAGE sql
+AS $$
+    UPDATE outbox_events
+    SET claimed_at = now(), claimed_by = claimer_id, lease_until = now() + make_interval(secs => lease_seconds)
+    WHERE event_id IN (
+        SELECT event_id FROM outbox_events
+        WHERE published_at IS NULL AND claimed_at IS NULL AND dead_lettered_at IS NULL
+          AND (next_attempt_at IS NULL OR next_attempt_at <= now())
+        ORDER BY created_at
+        LIMIT batch_size
+        FOR UPDATE SKIP LOCKED
+    )
+    RETURNING *;
+$$;
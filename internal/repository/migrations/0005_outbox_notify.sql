@@ -0,0 +1,16 @@
+-- Wakes listening claimers as soon as outbox events are committed instead of
+-- on their next poll.
+CREATE OR REPLACE FUNCTION notify_outbox_events()
+RETURNS trigger
+LANGUAGE plpgsql
+AS $$
+BEGIN
+    PERFORM pg_notify('dwop_outbox', '');
+    RETURN NULL;
+END;
+$$;
+
+DROP TRIGGER IF EXISTS outbox_events_notify ON outbox_events;
+CREATE TRIGGER outbox_events_notify
+    AFTER INSERT ON outbox_events
+    FOR EACH STATEMENT EXECUTE FUNCTION notify_outbox_events();
@@ -0,0 +1,72 @@
+-- Workflows carry a priority from 0 (default) to 9. Every outbox event copies
+-- the priority of its workflow, and claims take higher priorities first.
+ALTER TABLE workflows ADD COLUMN IF NOT EXISTS priority smallint NOT NULL DEFAULT 0;
+ALTER TABLE outbox_events ADD COLUMN IF NOT EXISTS priority smallint NOT NULL DEFAULT 0;
+
+-- Events are inserted from Go and from the stored procedures; the trigger
+-- keeps both from having to look the priority up.
+CREATE OR REPLACE FUNCTION outbox_events_set_priority()
+RETURNS trigger
+LANGUAGE plpgsql
+AS $$
+BEGIN
+    SELECT w.priority INTO NEW.priority FROM workflows w WHERE w.workflow_id = NEW.workflow_id;
+    NEW.priority := coalesce(NEW.priority, 0);
+    RETURN NEW;
+END;
+$$;
+
+DROP TRIGGER IF EXISTS outbox_events_priority ON outbox_events;
+CREATE TRIGGER outbox_events_priority
+    BEFORE INSERT ON outbox_events
+    FOR EACH ROW EXECUTE FUNCTION outbox_events_set_priority();
+
+DROP INDEX IF EXISTS outbox_events_unclaimed;
+CREATE INDEX IF NOT EXISTS outbox_events_unclaimed ON outbox_events (priority DESC, created_at)
+    WHERE published_at IS NULL AND claimed_at IS NULL AND dead_lettered_at IS NULL;
+
+CREATE OR REPLACE FUNCTION claim_outbox_events(claimer_id integer, batch_size integer, lease_seconds integer)
+RETURNS SETOF outbox_events
+LANGUAGE sql
+AS $$
+    UPDATE outbox_events
+    SET claimed_at = now(), claimed_by = claimer_id, lease_until = now() + make_interval(secs => lease_seconds)
+    WHERE event_id IN (
+        SELECT event_id FROM outbox_events
+        WHERE published_at IS NULL AND claimed_at IS NULL AND dead_lettered_at IS NULL
+          AND (next_attempt_at IS NULL OR next_attempt_at <= now())
+        ORDER BY priority DESC, created_at
+        LIMIT batch_size
+        FOR UPDATE SKIP LOCKED
+    )
+    RETURNING *;
+$$;
+
+CREATE OR REPLACE FUNCTION create_workflow_with_tasks_and_outbox(workflow jsonb, tasks jsonb, outbox_events jsonb)
+RETURNS void
+LANGUAGE plpgsql
+AS $$
+BEGIN
+    INSERT INTO workflows (workflow_id, env_link, params, priority, created_at, finished_at, status)
+    SELECT w.workflow_id, coalesce(w.env_link, ''), w.params, coalesce(w.priority, 0), coalesce(w.created_at, now()),
+           w.finished_at, coalesce(w.status, 'RUNNING')
+    FROM jsonb_populate_record(NULL::workflows, workflow) w;
+
+    INSERT INTO tasks (task_id, workflow_id, name, code_link, code_hash, pending_preds, func_arg_map, predecessors,
+                       successors, status, attempt, max_attempts, created_at, image, timeout_seconds, resources,
+                       trigger_rule, cache, cache_key, map_over, parent_task_id, map_index, map_size, map_collected)
+    SELECT t.task_id, t.workflow_id, t.name, coalesce(t.code_link, ''), coalesce(t.code_hash, ''), coalesce(t.pending_preds, 0),
+           t.func_arg_map, t.predecessors, t.successors, coalesce(t.status, 'PENDING'), coalesce(t.attempt, 0),
+           coalesce(t.max_attempts, 5), coalesce(t.created_at, now()), coalesce(t.image, ''), coalesce(t.timeout_seconds, 0),
+           t.resources, coalesce(t.trigger_rule, 'all_success'), coalesce(t.cache, false), coalesce(t.cache_key, ''),
+           coalesce(t.map_over, ''), t.parent_task_id, t.map_index, t.map_size, coalesce(t.map_collected, false)
+    FROM jsonb_populate_recordset(NULL::tasks, tasks) t;
+
+    INSERT INTO outbox_events (event_id, task_id, workflow_id, event_type, payload, created_at, publish_attempts)
+    SELECT e.event_id, e.task_id, e.workflow_id, e.event_type, e.payload, coalesce(e.created_at, now()), coalesce(e.publish_attempts, 0)
+    FROM jsonb_populate_recordset(NULL::outbox_events, coalesce(outbox_events, '[]'::jsonb)) e;
+
+    UPDATE tasks SET status = 'QUEUED'
+    WHERE task_id IN (SELECT (e ->> 'task_id')::uuid FROM jsonb_array_elements(coalesce(outbox_events, '[]'::jsonb)) e);
+END;
+$$;
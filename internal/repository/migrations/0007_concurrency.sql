@@ -0,0 +1,114 @@
+-- Concurrency limits: runs are admitted against a global limit, a limit per
+-- workflow and per pool. Messages that do not fit are deferred on their
+-- outbox event until a slot frees up.
+ALTER TABLE workflows ADD COLUMN IF NOT EXISTS max_running integer NOT NULL DEFAULT 0;
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS pool text NOT NULL DEFAULT '';
+ALTER TABLE outbox_events ADD COLUMN IF NOT EXISTS deferred_at timestamptz;
+
+CREATE INDEX IF NOT EXISTS task_runs_running ON task_runs (workflow_id)
+    WHERE status = 'RUNNING';
+CREATE INDEX IF NOT EXISTS outbox_events_deferred ON outbox_events (priority DESC, deferred_at)
+    WHERE deferred_at IS NOT NULL;
+
+-- Runs that take a slot. Mapped parents only stand for their children, and
+-- runs of finished or canceled workflows are left behind by cancellation.
+CREATE OR REPLACE FUNCTION running_run_counts()
+RETURNS TABLE (workflow_id uuid, pool text, running bigint)
+LANGUAGE sql
+STABLE
+AS $$
+    SELECT r.workflow_id, t.pool, count(*)
+    FROM task_runs r
+    JOIN tasks t ON t.task_id = r.task_id
+    JOIN workflows w ON w.workflow_id = r.workflow_id
+    WHERE r.status = 'RUNNING' AND w.status = 'RUNNING'
+      AND NOT (t.map_over <> '' AND t.parent_task_id IS NULL)
+    GROUP BY r.workflow_id, t.pool;
+$$;
+
+-- Starts a run unless its task already has one or it would exceed a limit.
+-- Returns 'started', 'duplicate' or 'limit: <reason>'. Admissions that check
+-- limits are serialized by an advisory lock so two consumers cannot both take
+-- the last slot.
+CREATE OR REPLACE FUNCTION start_task_run(run jsonb, max_global integer, max_per_workflow integer, pool_slots jsonb)
+RETURNS text
+LANGUAGE plpgsql
+AS $$
+DECLARE
+    r task_runs;
+    t tasks;
+    w workflows;
+    workflow_limit integer;
+    pool_limit integer;
+    running_global bigint;
+    running_workflow bigint;
+    running_pool bigint;
+BEGIN
+    r := jsonb_populate_record(NULL::task_runs, run);
+    SELECT * INTO t FROM tasks WHERE task_id = r.task_id FOR UPDATE;
+    IF NOT FOUND OR t.status IN ('RUNNING', 'SUCCEEDED', 'FAILED', 'CANCELED', 'SKIPPED') THEN
+        RETURN 'duplicate';
+    END IF;
+
+    IF NOT (t.map_over <> '' AND t.parent_task_id IS NULL) THEN
+        SELECT * INTO w FROM workflows WHERE workflow_id = t.workflow_id;
+        workflow_limit := CASE WHEN w.max_running > 0 THEN w.max_running ELSE coalesce(max_per_workflow, 0) END;
+        IF t.pool <> '' THEN
+            pool_limit := (coalesce(pool_slots, '{}'::jsonb) ->> t.pool)::integer;
+        END IF;
+        IF coalesce(max_global, 0) > 0 OR workflow_limit > 0 OR pool_limit IS NOT NULL THEN
+            PERFORM pg_advisory_xact_lock(1685548912);
+            SELECT coalesce(sum(c.running), 0),
+                   coalesce(sum(c.running) FILTER (WHERE c.workflow_id = t.workflow_id), 0),
+                   coalesce(sum(c.running) FILTER (WHERE c.pool = t.pool), 0)
+            INTO running_global, running_workflow, running_pool
+            FROM running_run_counts() c;
+            IF max_global > 0 AND running_global >= max_global THEN
+                RETURN format('limit: %s of %s runs are running', running_global, max_global);
+            END IF;
+            IF workflow_limit > 0 AND running_workflow >= workflow_limit THEN
+                RETURN format('limit: workflow %s has %s of %s runs running', t.workflow_id, running_workflow, workflow_limit);
+            END IF;
+            IF pool_limit IS NOT NULL AND running_pool >= pool_limit THEN
+                RETURN format('limit: pool "%s" has %s of %s slots in use', t.pool, running_pool, pool_limit);
+            END IF;
+        END IF;
+    END IF;
+
+    INSERT INTO task_runs (task_id, run_id, workflow_id, status, last_error, lease_owner, lease_until, created_at, updated_at)
+    VALUES (r.task_id, r.run_id, r.workflow_id, coalesce(r.status, 'RUNNING'), r.last_error, r.lease_owner, r.lease_until,
+            coalesce(r.created_at, now()), r.updated_at);
+    UPDATE tasks SET status = 'RUNNING' WHERE task_id = r.task_id;
+    RETURN 'started';
+END;
+$$;
+
+CREATE OR REPLACE FUNCTION create_workflow_with_tasks_and_outbox(workflow jsonb, tasks jsonb, outbox_events jsonb)
+RETURNS void
+LANGUAGE plpgsql
+AS $$
+BEGIN
+    INSERT INTO workflows (workflow_id, env_link, params, priority, max_running, created_at, finished_at, status)
+    SELECT w.workflow_id, coalesce(w.env_link, ''), w.params, coalesce(w.priority, 0), coalesce(w.max_running, 0),
+           coalesce(w.created_at, now()), w.finished_at, coalesce(w.status, 'RUNNING')
+    FROM jsonb_populate_record(NULL::workflows, workflow) w;
+
+    INSERT INTO tasks (task_id, workflow_id, name, code_link, code_hash, pending_preds, func_arg_map, predecessors,
+                       successors, status, attempt, max_attempts, created_at, image, timeout_seconds, resources,
+                       trigger_rule, cache, cache_key, map_over, parent_task_id, map_index, map_size, map_collected, pool)
+    SELECT t.task_id, t.workflow_id, t.name, coalesce(t.code_link, ''), coalesce(t.code_hash, ''), coalesce(t.pending_preds, 0),
+           t.func_arg_map, t.predecessors, t.successors, coalesce(t.status, 'PENDING'), coalesce(t.attempt, 0),
+           coalesce(t.max_attempts, 5), coalesce(t.created_at, now()), coalesce(t.image, ''), coalesce(t.timeout_seconds, 0),
+           t.resources, coalesce(t.trigger_rule, 'all_success'), coalesce(t.cache, false), coalesce(t.cache_key, ''),
+           coalesce(t.map_over, ''), t.parent_task_id, t.map_index, t.map_size, coalesce(t.map_collected, false),
+           coalesce(t.pool, '')
+    FROM jsonb_populate_recordset(NULL::tasks, tasks) t;
+
+    INSERT INTO outbox_events (event_id, task_id, workflow_id, event_type, payload, created_at, publish_attempts)
+    SELECT e.event_id, e.task_id, e.workflow_id, e.event_type, e.payload, coalesce(e.created_at, now()), coalesce(e.publish_attempts, 0)
+    FROM jsonb_populate_recordset(NULL::outbox_events, coalesce(outbox_events, '[]'::jsonb)) e;
+
+    UPDATE tasks SET status = 'QUEUED'
+    WHERE task_id IN (SELECT (e ->> 'task_id')::uuid FROM jsonb_array_elements(coalesce(outbox_events, '[]'::jsonb)) e);
+END;
+$$;
@@ -0,0 +1,58 @@
+-- Schedules keep a workflow definition and start a run of it at every tick of
+-- a cron expression. Runs point back to their schedule and tick.
+CREATE TABLE IF NOT EXISTS schedules (
+    schedule_id  uuid PRIMARY KEY,
+    name         text        NOT NULL DEFAULT '',
+    cron         text        NOT NULL,
+    timezone     text        NOT NULL DEFAULT 'UTC',
+    catch_up     text        NOT NULL DEFAULT 'latest',
+    definition   text        NOT NULL,
+    requirements text        NOT NULL DEFAULT '',
+    params       jsonb,
+    priority     integer,
+    status       text        NOT NULL DEFAULT 'ACTIVE',
+    next_run_at  timestamptz NOT NULL,
+    created_at   timestamptz NOT NULL DEFAULT now(),
+    updated_at   timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS schedules_due ON schedules (next_run_at) WHERE status = 'ACTIVE';
+CREATE INDEX IF NOT EXISTS schedules_created_at ON schedules (created_at DESC);
+
+ALTER TABLE workflows ADD COLUMN IF NOT EXISTS schedule_id uuid REFERENCES schedules (schedule_id) ON DELETE SET NULL;
+ALTER TABLE workflows ADD COLUMN IF NOT EXISTS scheduled_for timestamptz;
+
+-- One run per tick, however many orchestrators fire the schedule.
+CREATE UNIQUE INDEX IF NOT EXISTS workflows_schedule_tick ON workflows (schedule_id, scheduled_for)
+    WHERE schedule_id IS NOT NULL;
+
+CREATE OR REPLACE FUNCTION create_workflow_with_tasks_and_outbox(workflow jsonb, tasks jsonb, outbox_events jsonb)
+RETURNS void
+LANGUAGE plpgsql
+AS $$
+BEGIN
+    INSERT INTO workflows (workflow_id, env_link, params, priority, max_running, created_at, finished_at, status,
+                           schedule_id, scheduled_for)
+    SELECT w.workflow_id, coalesce(w.env_link, ''), w.params, coalesce(w.priority, 0), coalesce(w.max_running, 0),
+           coalesce(w.created_at, now()), w.finished_at, coalesce(w.status, 'RUNNING'), w.schedule_id, w.scheduled_for
+    FROM jsonb_populate_record(NULL::workflows, workflow) w;
+
+    INSERT INTO tasks (task_id, workflow_id, name, code_link, code_hash, pending_preds, func_arg_map, predecessors,
+                       successors, status, attempt, max_attempts, created_at, image, timeout_seconds, resources,
+                       trigger_rule, cache, cache_key, map_over, parent_task_id, map_index, map_size, map_collected, pool)
+    SELECT t.task_id, t.workflow_id, t.name, coalesce(t.code_link, ''), coalesce(t.code_hash, ''), coalesce(t.pending_preds, 0),
+           t.func_arg_map, t.predecessors, t.successors, coalesce(t.status, 'PENDING'), coalesce(t.attempt, 0),
+           coalesce(t.max_attempts, 5), coalesce(t.created_at, now()), coalesce(t.image, ''), coalesce(t.timeout_seconds, 0),
+           t.resources, coalesce(t.trigger_rule, 'all_success'), coalesce(t.cache, false), coalesce(t.cache_key, ''),
+           coalesce(t.map_over, ''), t.parent_task_id, t.map_index, t.map_size, coalesce(t.map_collected, false),
+           coalesce(t.pool, '')
+    FROM jsonb_populate_recordset(NULL::tasks, tasks) t;
+
+    INSERT INTO outbox_events (event_id, task_id, workflow_id, event_type, payload, created_at, publish_attempts)
+    SELECT e.event_id, e.task_id, e.workflow_id, e.event_type, e.payload, coalesce(e.created_at, now()), coalesce(e.publish_attempts, 0)
+    FROM jsonb_populate_recordset(NULL::outbox_events, coalesce(outbox_events, '[]'::jsonb)) e;
+
+    UPDATE tasks SET status = 'QUEUED'
+    WHERE task_id IN (SELECT (e ->> 'task_id')::uuid FROM jsonb_array_elements(coalesce(outbox_events, '[]'::jsonb)) e);
+END;
+$$;
@@ -0,0 +1,79 @@
+-- Workflow definitions are stored by name in immutable, numbered versions.
+-- Workflows started from a version point back to it and are its runs.
+CREATE TABLE IF NOT EXISTS workflow_definitions (
+    definition_id uuid PRIMARY KEY,
+    name          text        NOT NULL,
+    version       integer     NOT NULL,
+    definition    text        NOT NULL,
+    requirements  text        NOT NULL DEFAULT '',
+    hash          text        NOT NULL,
+    created_at    timestamptz NOT NULL DEFAULT now(),
+    UNIQUE (name, version)
+);
+
+CREATE OR REPLACE VIEW latest_workflow_definitions AS
+    SELECT DISTINCT ON (name) * FROM workflow_definitions ORDER BY name, version DESC;
+
+ALTER TABLE workflows ADD COLUMN IF NOT EXISTS definition_id uuid REFERENCES workflow_definitions (definition_id);
+ALTER TABLE workflows ADD COLUMN IF NOT EXISTS definition_version integer;
+
+CREATE INDEX IF NOT EXISTS workflows_definition ON workflows (definition_id, created_at DESC)
+    WHERE definition_id IS NOT NULL;
+
+-- Adds definition as the next version of its name, unless the latest version
+-- has the same hash, in which case that version is returned instead.
+-- Versions of one name are numbered under an advisory lock.
+CREATE OR REPLACE FUNCTION create_workflow_definition(definition jsonb)
+RETURNS SETOF workflow_definitions
+LANGUAGE plpgsql
+AS $$
+DECLARE
+    d workflow_definitions;
+    latest workflow_definitions;
+BEGIN
+    d := jsonb_populate_record(NULL::workflow_definitions, definition);
+    PERFORM pg_advisory_xact_lock(hashtext('workflow_definitions:' || d.name));
+    SELECT * INTO latest FROM workflow_definitions WHERE name = d.name ORDER BY version DESC LIMIT 1;
+    IF FOUND AND latest.hash = d.hash THEN
+        RETURN NEXT latest;
+        RETURN;
+    END IF;
+    INSERT INTO workflow_definitions (definition_id, name, version, definition, requirements, hash, created_at)
+    VALUES (d.definition_id, d.name, coalesce(latest.version, 0) + 1, d.definition, coalesce(d.requirements, ''),
+            d.hash, coalesce(d.created_at, now()))
+    RETURNING * INTO d;
+    RETURN NEXT d;
+END;
+$$;
+
+CREATE OR REPLACE FUNCTION create_workflow_with_tasks_and_outbox(workflow jsonb, tasks jsonb, outbox_events jsonb)
+RETURNS void
+LANGUAGE plpgsql
+AS $$
+BEGIN
+    INSERT INTO workflows (workflow_id, env_link, params, priority, max_running, created_at, finished_at, status,
+                           schedule_id, scheduled_for, definition_id, definition_version)
+    SELECT w.workflow_id, coalesce(w.env_link, ''), w.params, coalesce(w.priority, 0), coalesce(w.max_running, 0),
+           coalesce(w.created_at, now()), w.finished_at, coalesce(w.status, 'RUNNING'), w.schedule_id, w.scheduled_for,
+           w.definition_id, w.definition_version
+    FROM jsonb_populate_record(NULL::workflows, workflow) w;
+
+    INSERT INTO tasks (task_id, workflow_id, name, code_link, code_hash, pending_preds, func_arg_map, predecessors,
+                       successors, status, attempt, max_attempts, created_at, image, timeout_seconds, resources,
+                       trigger_rule, cache, cache_key, map_over, parent_task_id, map_index, map_size, map_collected, pool)
+    SELECT t.task_id, t.workflow_id, t.name, coalesce(t.code_link, ''), coalesce(t.code_hash, ''), coalesce(t.pending_preds, 0),
+           t.func_arg_map, t.predecessors, t.successors, coalesce(t.status, 'PENDING'), coalesce(t.attempt, 0),
+           coalesce(t.max_attempts, 5), coalesce(t.created_at, now()), coalesce(t.image, ''), coalesce(t.timeout_seconds, 0),
+           t.resources, coalesce(t.trigger_rule, 'all_success'), coalesce(t.cache, false), coalesce(t.cache_key, ''),
+           coalesce(t.map_over, ''), t.parent_task_id, t.map_index, t.map_size, coalesce(t.map_collected, false),
+           coalesce(t.pool, '')
+    FROM jsonb_populate_recordset(NULL::tasks, tasks) t;
+
+    INSERT INTO outbox_events (event_id, task_id, workflow_id, event_type, payload, created_at, publish_attempts)
+    SELECT e.event_id, e.task_id, e.workflow_id, e.event_type, e.payload, coalesce(e.created_at, now()), coalesce(e.publish_attempts, 0)
+    FROM jsonb_populate_recordset(NULL::outbox_events, coalesce(outbox_events, '[]'::jsonb)) e;
+
+    UPDATE tasks SET status = 'QUEUED'
+    WHERE task_id IN (SELECT (e ->> 'task_id')::uuid FROM jsonb_array_elements(coalesce(outbox_events, '[]'::jsonb)) e);
+END;
+$$;
@@ -0,0 +1,17 @@
+-- Undoes start_task_run for a run that was never handed to an executor, so a
+-- redelivered message can start the task again. The attempt is not counted.
+CREATE OR REPLACE FUNCTION abandon_task_run(p_run_id uuid, p_error text)
+RETURNS void
+LANGUAGE plpgsql
+AS $$
+DECLARE
+    r task_runs;
+BEGIN
+    SELECT * INTO r FROM task_runs WHERE run_id = p_run_id FOR UPDATE;
+    IF NOT FOUND OR r.status <> 'RUNNING' THEN
+        RETURN;
+    END IF;
+    UPDATE task_runs SET status = 'FAILED', last_error = p_error, updated_at = now() WHERE run_id = p_run_id;
+    UPDATE tasks SET status = 'QUEUED' WHERE task_id = r.task_id AND status = 'RUNNING';
+END;
+$$;
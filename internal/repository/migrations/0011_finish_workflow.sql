@@ -0,0 +1,106 @@
+-- Workflows are finished in the same transaction as the last task update
+-- that makes every task terminal. The workflow row is locked first, so of two
+-- transactions finishing the last tasks concurrently the second one sees the
+-- first one's writes.
+CREATE OR REPLACE FUNCTION finish_workflow_if_done(p_workflow_id uuid)
+RETURNS void
+LANGUAGE plpgsql
+AS $$
+BEGIN
+    PERFORM 1 FROM workflows WHERE workflow_id = p_workflow_id AND status = 'RUNNING' FOR UPDATE;
+    IF NOT FOUND THEN
+        RETURN;
+    END IF;
+    IF NOT EXISTS (SELECT 1 FROM tasks WHERE workflow_id = p_workflow_id)
+       OR EXISTS (SELECT 1 FROM tasks WHERE workflow_id = p_workflow_id
+                  AND status NOT IN ('SUCCEEDED', 'FAILED', 'CANCELED', 'SKIPPED')) THEN
+        RETURN;
+    END IF;
+    UPDATE workflows
+    SET status = CASE WHEN EXISTS (SELECT 1 FROM tasks WHERE workflow_id = p_workflow_id
+                                   AND (status = 'FAILED' OR (status <> 'SUCCEEDED' AND attempt >= max_attempts)))
+                      THEN 'FAILED' ELSE 'SUCCEEDED' END,
+        finished_at = now()
+    WHERE workflow_id = p_workflow_id;
+END;
+$$;
+
+CREATE OR REPLACE FUNCTION complete_run_and_enqueue_successors(p_run_id uuid)
+RETURNS void
+LANGUAGE plpgsql
+AS $$
+DECLARE
+    r task_runs;
+    t tasks;
+BEGIN
+    SELECT * INTO r FROM task_runs WHERE run_id = p_run_id FOR UPDATE;
+    IF NOT FOUND OR r.status <> 'RUNNING' THEN
+        RETURN;
+    END IF;
+    UPDATE task_runs SET status = 'SUCCEEDED', updated_at = now() WHERE run_id = p_run_id;
+    UPDATE tasks SET status = 'SUCCEEDED' WHERE task_id = r.task_id RETURNING * INTO t;
+
+    WITH released AS (
+        UPDATE tasks
+        SET pending_preds = pending_preds - 1,
+            status = CASE WHEN pending_preds = 1 THEN 'QUEUED' ELSE status END
+        WHERE workflow_id = t.workflow_id
+          AND name = ANY (coalesce(t.successors, '{}'))
+          AND status = 'PENDING'
+        RETURNING task_id, status
+    )
+    INSERT INTO outbox_events (event_id, task_id, workflow_id, event_type, created_at, publish_attempts)
+    SELECT gen_random_uuid(), task_id, t.workflow_id, 'TASK_READY', now(), 5
+    FROM released WHERE status = 'QUEUED';
+
+    PERFORM finish_workflow_if_done(t.workflow_id);
+END;
+$$;
+
+CREATE OR REPLACE FUNCTION increase_attempt(p_run_id uuid, p_error text)
+RETURNS void
+LANGUAGE plpgsql
+AS $$
+DECLARE
+    r task_runs;
+    t tasks;
+BEGIN
+    SELECT * INTO r FROM task_runs WHERE run_id = p_run_id FOR UPDATE;
+    IF NOT FOUND OR r.status <> 'RUNNING' THEN
+        RETURN;
+    END IF;
+    UPDATE task_runs SET status = 'FAILED', last_error = p_error, updated_at = now() WHERE run_id = p_run_id;
+    UPDATE tasks
+    SET attempt = attempt + 1,
+        status = CASE WHEN attempt + 1 >= max_attempts THEN 'FAILED' ELSE 'QUEUED' END
+    WHERE task_id = r.task_id
+    RETURNING * INTO t;
+
+    IF t.status = 'QUEUED' THEN
+        INSERT INTO outbox_events (event_id, task_id, workflow_id, event_type, created_at, publish_attempts)
+        VALUES (gen_random_uuid(), t.task_id, t.workflow_id, 'TASK_RETRY_READY', now(), 5);
+    ELSE
+        PERFORM finish_workflow_if_done(t.workflow_id);
+    END IF;
+END;
+$$;
+
+-- Moves a task from one status to another only if it is still in the first,
+-- finishing the workflow when that made every task terminal.
+CREATE OR REPLACE FUNCTION transition_task_status(p_task_id uuid, p_from text, p_to text)
+RETURNS boolean
+LANGUAGE plpgsql
+AS $$
+DECLARE
+    t tasks;
+BEGIN
+    UPDATE tasks SET status = p_to WHERE task_id = p_task_id AND status = p_from RETURNING * INTO t;
+    IF NOT FOUND THEN
+        RETURN false;
+    END IF;
+    IF p_to IN ('SUCCEEDED', 'FAILED', 'CANCELED', 'SKIPPED') THEN
+        PERFORM finish_workflow_if_done(t.workflow_id);
+    END IF;
+    RETURN true;
+END;
+$$;
@@ -0,0 +1,21 @@
+-- Queues a PENDING task together with its outbox event, so a task is never
+-- left QUEUED without an event to start it. Returns false, inserting nothing,
+-- if the task was no longer PENDING.
+CREATE OR REPLACE FUNCTION queue_task(event jsonb)
+RETURNS boolean
+LANGUAGE plpgsql
+AS $$
+DECLARE
+    e outbox_events;
+BEGIN
+    e := jsonb_populate_record(NULL::outbox_events, event);
+    UPDATE tasks SET status = 'QUEUED' WHERE task_id = e.task_id AND status = 'PENDING';
+    IF NOT FOUND THEN
+        RETURN false;
+    END IF;
+    INSERT INTO outbox_events (event_id, task_id, workflow_id, event_type, payload, created_at, publish_attempts)
+    VALUES (e.event_id, e.task_id, e.workflow_id, e.event_type, e.payload, coalesce(e.created_at, now()),
+            coalesce(e.publish_attempts, 0));
+    RETURN true;
+END;
+$$;
@@ -0,0 +1,24 @@
+-- Puts tasks of a workflow back to run in one transaction: deletes the
+-- children of re-expanded mapped tasks, writes back the reset tasks, reopens
+-- the workflow and inserts the events that queue the tasks.
+CREATE OR REPLACE FUNCTION apply_rerun(p_workflow_id uuid, drop_maps jsonb, tasks jsonb, outbox_events jsonb)
+RETURNS void
+LANGUAGE plpgsql
+AS $$
+BEGIN
+    DELETE FROM tasks
+    WHERE parent_task_id IN (SELECT d::uuid FROM jsonb_array_elements_text(coalesce(drop_maps, '[]'::jsonb)) d);
+
+    UPDATE tasks
+    SET status = r.status, attempt = r.attempt, pending_preds = r.pending_preds,
+        map_size = r.map_size, map_collected = coalesce(r.map_collected, false)
+    FROM jsonb_populate_recordset(NULL::tasks, coalesce(tasks, '[]'::jsonb)) r
+    WHERE tasks.task_id = r.task_id;
+
+    UPDATE workflows SET status = 'RUNNING', finished_at = NULL WHERE workflow_id = p_workflow_id;
+
+    INSERT INTO outbox_events (event_id, task_id, workflow_id, event_type, payload, created_at, publish_attempts)
+    SELECT e.event_id, e.task_id, e.workflow_id, e.event_type, e.payload, coalesce(e.created_at, now()), coalesce(e.publish_attempts, 0)
+    FROM jsonb_populate_recordset(NULL::outbox_events, coalesce(outbox_events, '[]'::jsonb)) e;
+END;
+$$;
@@ -0,0 +1,61 @@
+-- Each run records the attempt it was started as, counted from 1, so listing
+-- runs does not have to derive it from their order. Runs started before this
+-- migration keep attempt 0.
+ALTER TABLE task_runs ADD COLUMN IF NOT EXISTS attempt integer NOT NULL DEFAULT 0;
+
+-- Starts a run unless its task already has one or it would exceed a limit.
+-- Returns 'started', 'duplicate' or 'limit: <reason>'. Admissions that check
+-- limits are serialized by an advisory lock so two consumers cannot both take
+-- the last slot.
+CREATE OR REPLACE FUNCTION start_task_run(run jsonb, max_global integer, max_per_workflow integer, pool_slots jsonb)
+RETURNS text
+LANGUAGE plpgsql
+AS $$
+DECLARE
+    r task_runs;
+    t tasks;
+    w workflows;
+    workflow_limit integer;
+    pool_limit integer;
+    running_global bigint;
+    running_workflow bigint;
+    running_pool bigint;
+BEGIN
+    r := jsonb_populate_record(NULL::task_runs, run);
+    SELECT * INTO t FROM tasks WHERE task_id = r.task_id FOR UPDATE;
+    IF NOT FOUND OR t.status IN ('RUNNING', 'SUCCEEDED', 'FAILED', 'CANCELED', 'SKIPPED') THEN
+        RETURN 'duplicate';
+    END IF;
+
+    IF NOT (t.map_over <> '' AND t.parent_task_id IS NULL) THEN
+        SELECT * INTO w FROM workflows WHERE workflow_id = t.workflow_id;
+        workflow_limit := CASE WHEN w.max_running > 0 THEN w.max_running ELSE coalesce(max_per_workflow, 0) END;
+        IF t.pool <> '' THEN
+            pool_limit := (coalesce(pool_slots, '{}'::jsonb) ->> t.pool)::integer;
+        END IF;
+        IF coalesce(max_global, 0) > 0 OR workflow_limit > 0 OR pool_limit IS NOT NULL THEN
+            PERFORM pg_advisory_xact_lock(1685548912);
+            SELECT coalesce(sum(c.running), 0),
+                   coalesce(sum(c.running) FILTER (WHERE c.workflow_id = t.workflow_id), 0),
+                   coalesce(sum(c.running) FILTER (WHERE c.pool = t.pool), 0)
+            INTO running_global, running_workflow, running_pool
+            FROM running_run_counts() c;
+            IF max_global > 0 AND running_global >= max_global THEN
+                RETURN format('limit: %s of %s runs are running', running_global, max_global);
+            END IF;
+            IF workflow_limit > 0 AND running_workflow >= workflow_limit THEN
+                RETURN format('limit: workflow %s has %s of %s runs running', t.workflow_id, running_workflow, workflow_limit);
+            END IF;
+            IF pool_limit IS NOT NULL AND running_pool >= pool_limit THEN
+                RETURN format('limit: pool "%s" has %s of %s slots in use', t.pool, running_pool, pool_limit);
+            END IF;
+        END IF;
+    END IF;
+
+    INSERT INTO task_runs (task_id, run_id, workflow_id, status, attempt, last_error, lease_owner, lease_until, created_at, updated_at)
+    VALUES (r.task_id, r.run_id, r.workflow_id, coalesce(r.status, 'RUNNING'), t.attempt + 1, r.last_error, r.lease_owner,
+            r.lease_until, coalesce(r.created_at, now()), r.updated_at);
+    UPDATE tasks SET status = 'RUNNING' WHERE task_id = r.task_id;
+    RETURN 'started';
+END;
+$$;
@@ -1,50 +1,158 @@
 package repository
 
 import (
+	"encoding/json"
 	"fmt"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/Sayan-995/dwop/internal/utils"
+	"github.com/google/uuid"
+	"github.com/supabase-community/postgrest-go"
 )
 
 var (
 	TableName = "outbox_events"
 )
 
-func ClaimOutboxEvents(id int) (string, error) {
+func (r *SupabaseRepository) ClaimOutboxEvents(id int) ([]utils.OutboxEvent, error) {
 	args := map[string]any{
-		"batch_size": 200,
-		"claimer_id": id,
+		"batch_size":    ClaimBatchSize,
+		"claimer_id":    id,
+		"lease_seconds": int(OutboxLease.Seconds()),
 	}
-	data := DB.Rpc("claim_outbox_events", "", args)
+	data := r.db.Rpc("claim_outbox_events", "", args)
 	// Only treat as error if response starts with error structure
 	if strings.HasPrefix(strings.TrimSpace(data), `{"code"`) {
-		return "", fmt.Errorf("[ClaimOutboxEvents] RPC error: %s", data)
+		return nil, fmt.Errorf("[ClaimOutboxEvents] RPC error: %s", data)
 	}
-	return data, nil
+	var events []utils.OutboxEvent
+	if err := json.Unmarshal([]byte(data), &events); err != nil {
+		return nil, fmt.Errorf("[ClaimOutboxEvents] invalid response: %v", err)
+	}
+	return events, nil
+}
+
+func (r *SupabaseRepository) ReleaseExpiredOutboxLeases() (int64, error) {
+	data := r.db.Rpc("release_expired_outbox_leases", "", nil)
+	if strings.HasPrefix(strings.TrimSpace(data), `{"code"`) {
+		return 0, fmt.Errorf("[ReleaseExpiredOutboxLeases] RPC error: %s", data)
+	}
+	var released int64
+	if err := json.Unmarshal([]byte(data), &released); err != nil {
+		return 0, fmt.Errorf("[ReleaseExpiredOutboxLeases] invalid response: %v", err)
+	}
+	return released, nil
+}
+
+func (r *SupabaseRepository) ListStuckOutboxEvents(claimedBefore time.Time, opts ListOptions) ([]utils.OutboxEvent, int64, error) {
+	data, count, err := opts.apply(r.db.From(TableName).Select("*", "exact", false).
+		Is("published_at", "null").
+		Lt("claimed_at", claimedBefore.UTC().Format(time.RFC3339Nano))).Execute()
+	if err != nil {
+		return nil, 0, fmt.Errorf("[ListStuckOutboxEvents] %v", err)
+	}
+	var rows []utils.OutboxEvent
+	if err := json.Unmarshal(data, &rows); err != nil {
+		return nil, 0, err
+	}
+	return rows, count, nil
+}
+
+func (r *SupabaseRepository) GetOutboxEvent(id uuid.UUID) (*utils.OutboxEvent, error) {
+	data, _, err := r.db.From(TableName).Select("*", "", false).Eq("event_id", id.String()).Execute()
+	if err != nil {
+		return nil, err
+	}
+	var rows []utils.OutboxEvent
+	if err := json.Unmarshal(data, &rows); err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, nil
+	}
+	return &rows[0], nil
 }
 
-func AddOutboxEvent(event utils.OutboxEvent) error {
-	_, _, err := DB.From(TableName).Insert(event, false, "", "minimal", "").Execute()
+func (r *SupabaseRepository) ListDeadLetterOutboxEvents(opts ListOptions) ([]utils.OutboxEvent, int64, error) {
+	data, count, err := opts.apply(r.db.From(TableName).Select("*", "exact", false).
+		Not("dead_lettered_at", "is", "null")).Execute()
+	if err != nil {
+		return nil, 0, fmt.Errorf("[ListDeadLetterOutboxEvents] %v", err)
+	}
+	var rows []utils.OutboxEvent
+	if err := json.Unmarshal(data, &rows); err != nil {
+		return nil, 0, err
+	}
+	return rows, count, nil
+}
+
+func (r *SupabaseRepository) ReplayOutboxEvent(id uuid.UUID) (bool, error) {
+	data, _, err := r.db.From(TableName).
+		Update(map[string]any{
+			"dead_lettered_at": nil,
+			"next_attempt_at":  nil,
+			"publish_attempts": PublishAttempts,
+			"claimed_at":       nil,
+			"claimed_by":       nil,
+			"lease_until":      nil,
+		}, "representation", "").
+		Eq("event_id", id.String()).
+		Not("dead_lettered_at", "is", "null").
+		Execute()
+	if err != nil {
+		return false, fmt.Errorf("[ReplayOutboxEvent] failed to replay event %s: %v", id, err)
+	}
+	var rows []utils.OutboxEvent
+	if err := json.Unmarshal(data, &rows); err != nil {
+		return false, err
+	}
+	return len(rows) > 0, nil
+}
+
+func (r *SupabaseRepository) AddOutboxEvent(event utils.OutboxEvent) error {
+	_, _, err := r.db.From(TableName).Insert(event, false, "", "minimal", "").Execute()
 	if err != nil {
 		return fmt.Errorf("[AddOutboxEvent] failed to insert event %s: %v", event.EventID, err)
 	}
 	return nil
 }
 
-func UpdateOutboxEvent(event utils.OutboxEvent) error {
-	_, _, err := DB.From(TableName).
-		Update(event, "minimal", "").
+func (r *SupabaseRepository) AddOutboxEvents(events []utils.OutboxEvent) error {
+	_, _, err := r.db.From(TableName).Insert(events, false, "", "minimal", "").Execute()
+	if err != nil {
+		return fmt.Errorf("[AddOutboxEvents] failed to insert %d events: %v", len(events), err)
+	}
+	return nil
+}
+
+func (r *SupabaseRepository) UpdateOutboxEvent(event utils.OutboxEvent, claimerId int) (bool, error) {
+	// deferred_at belongs to the consumers, which may defer the event while
+	// its claimer is still recording the publish.
+	fields, err := toMap(event)
+	if err != nil {
+		return false, err
+	}
+	delete(fields, "deferred_at")
+	data, _, err := r.db.From(TableName).
+		Update(fields, "representation", "").
 		Eq("event_id", event.EventID.String()).
+		Eq("claimed_by", strconv.Itoa(claimerId)).
+		Is("published_at", "null").
 		Execute()
 	if err != nil {
-		return fmt.Errorf("[UpdateOutboxEvent] failed to update event %s: %v", event.EventID, err)
+		return false, fmt.Errorf("[UpdateOutboxEvent] failed to update event %s: %v", event.EventID, err)
 	}
-	return nil
+	var rows []utils.OutboxEvent
+	if err := json.Unmarshal(data, &rows); err != nil {
+		return false, err
+	}
+	return len(rows) > 0, nil
 }
 
-func CompleteRunAndEnqueueSuccessors(runId string) error {
-	data := DB.Rpc("complete_run_and_enqueue_successors", "", map[string]string{
+func (r *SupabaseRepository) CompleteRunAndEnqueueSuccessors(runId string) error {
+	data := r.db.Rpc("complete_run_and_enqueue_successors", "", map[string]string{
 		"p_run_id": runId,
 	})
 	if strings.HasPrefix(strings.TrimSpace(data), `{"code"`) {
@@ -53,8 +161,8 @@ func CompleteRunAndEnqueueSuccessors(runId string) error {
 	return nil
 }
 
-func IncreaseAttempt(runID string, errmsg string) error {
-	data := DB.Rpc("increase_attempt", "", map[string]string{
+func (r *SupabaseRepository) IncreaseAttempt(runID string, errmsg string) error {
+	data := r.db.Rpc("increase_attempt", "", map[string]string{
 		"p_run_id": runID,
 		"p_error":  errmsg,
 	})
@@ -64,10 +172,78 @@ func IncreaseAttempt(runID string, errmsg string) error {
 	return nil
 }
 
-// func InsertTask(tasks any)error{
-// 	_,_,err:=	 DB.
-// 		From("Tasks").
-// 		Insert(tasks,false,"","","").
-// 		Execute()
-// 	return err
-// }
+func (r *SupabaseRepository) AbandonTaskRun(runId uuid.UUID, reason string) error {
+	data := r.db.Rpc("abandon_task_run", "", map[string]string{
+		"p_run_id": runId.String(),
+		"p_error":  reason,
+	})
+	if strings.HasPrefix(strings.TrimSpace(data), `{"code"`) {
+		return fmt.Errorf("rpc abandon_task_run failed: %v", data)
+	}
+	return nil
+}
+
+func (r *SupabaseRepository) DeferOutboxEvent(id uuid.UUID) error {
+	_, _, err := r.db.From(TableName).
+		Update(map[string]any{"deferred_at": time.Now()}, "minimal", "").
+		Eq("event_id", id.String()).
+		Is("deferred_at", "null").
+		Execute()
+	if err != nil {
+		return fmt.Errorf("[DeferOutboxEvent] failed to defer event %s: %v", id, err)
+	}
+	return nil
+}
+
+func (r *SupabaseRepository) DeferredOutboxEvents(limit int) ([]utils.OutboxEvent, error) {
+	data, _, err := r.db.From(TableName).Select("*", "", false).
+		Not("deferred_at", "is", "null").
+		Order("priority", &postgrest.OrderOpts{Ascending: false}).
+		Order("deferred_at", &postgrest.OrderOpts{Ascending: true}).
+		Limit(limit, "").
+		Execute()
+	if err != nil {
+		return nil, fmt.Errorf("[DeferredOutboxEvents] %v", err)
+	}
+	var rows []utils.OutboxEvent
+	if err := json.Unmarshal(data, &rows); err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
+
+func (r *SupabaseRepository) ReleaseDeferredOutboxEvents(ids []uuid.UUID) (int64, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	values := make([]string, len(ids))
+	for i, id := range ids {
+		values[i] = id.String()
+	}
+	_, count, err := r.db.From(TableName).
+		Update(map[string]any{
+			"deferred_at":      nil,
+			"published_at":     nil,
+			"claimed_at":       nil,
+			"claimed_by":       nil,
+			"lease_until":      nil,
+			"next_attempt_at":  nil,
+			"publish_attempts": PublishAttempts,
+		}, "minimal", "exact").
+		In("event_id", values).
+		Not("deferred_at", "is", "null").
+		Execute()
+	if err != nil {
+		return 0, fmt.Errorf("[ReleaseDeferredOutboxEvents] %v", err)
+	}
+	return count, nil
+}
+
+func toMap(v any) (map[string]any, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var m map[string]any
+	return m, json.Unmarshal(b, &m)
+}
@@ -0,0 +1,66 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	outboxChannel = "dwop_outbox"
+)
+
+var (
+	outboxReady = make(chan struct{}, 1)
+)
+
+// OutboxReady fires when outbox events may have been added, so the claimer
+// does not have to wait for its next poll. Repeated signals collapse into one.
+func OutboxReady() <-chan struct{} {
+	return outboxReady
+}
+
+func notifyOutbox() {
+	select {
+	case outboxReady <- struct{}{}:
+	default:
+	}
+}
+
+// listen forwards the NOTIFY sent by the outbox_events trigger, which also
+// covers events inserted by other orchestrators, until ctx is done.
+func (r *PgxRepository) listen(ctx context.Context) {
+	for {
+		err := r.waitForNotifications(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		fmt.Printf("[PgxRepository] LISTEN %s failed, retrying: %v\n", outboxChannel, err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(2 * time.Second):
+		}
+	}
+}
+
+func (r *PgxRepository) waitForNotifications(ctx context.Context) error {
+	conn, err := pgx.ConnectConfig(ctx, r.pool.Config().ConnConfig)
+	if err != nil {
+		return err
+	}
+	defer conn.Close(context.Background())
+	if _, err := conn.Exec(ctx, "LISTEN "+outboxChannel); err != nil {
+		return err
+	}
+	// Anything inserted while nobody was listening is not announced again.
+	notifyOutbox()
+	for {
+		if _, err := conn.WaitForNotification(ctx); err != nil {
+			return err
+		}
+		notifyOutbox()
+	}
+}
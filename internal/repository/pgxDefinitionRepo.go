@@ -0,0 +1,81 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+
+	u "github.com/Sayan-995/dwop/internal/utils"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// CreateDefinitionVersion adds definition as the next version of its name,
+// or returns the latest version if it has the same hash. Versions of one name
+// are numbered under an advisory lock.
+func (r *PgxRepository) CreateDefinitionVersion(definition u.WorkflowDefinition) (*u.WorkflowDefinition, error) {
+	var stored *u.WorkflowDefinition
+	err := r.withTx(func(ctx context.Context, tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, "SELECT pg_advisory_xact_lock(hashtext('workflow_definitions:' || $1))", definition.Name); err != nil {
+			return err
+		}
+		latest, err := collectOne[u.WorkflowDefinition](ctx, tx, `SELECT * FROM workflow_definitions
+			WHERE name = $1 ORDER BY version DESC LIMIT 1`, definition.Name)
+		if err != nil {
+			return err
+		}
+		if latest != nil && latest.Hash == definition.Hash {
+			stored = latest
+			return nil
+		}
+		definition.Version = 1
+		if latest != nil {
+			definition.Version = latest.Version + 1
+		}
+		_, err = tx.Exec(ctx, `INSERT INTO workflow_definitions (definition_id, name, version, definition, requirements, hash, created_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+			definition.DefinitionId, definition.Name, definition.Version, definition.Definition, definition.Requirements,
+			definition.Hash, definition.CreatedAt)
+		stored = &definition
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("[CreateDefinitionVersion] failed to store definition %s: %v", definition.Name, err)
+	}
+	return stored, nil
+}
+
+// GetDefinitionVersion returns the latest version when version is 0.
+func (r *PgxRepository) GetDefinitionVersion(name string, version int) (*u.WorkflowDefinition, error) {
+	if version > 0 {
+		return collectOne[u.WorkflowDefinition](context.Background(), r.pool,
+			"SELECT * FROM workflow_definitions WHERE name = $1 AND version = $2", name, version)
+	}
+	return collectOne[u.WorkflowDefinition](context.Background(), r.pool,
+		"SELECT * FROM workflow_definitions WHERE name = $1 ORDER BY version DESC LIMIT 1", name)
+}
+
+func (r *PgxRepository) ListDefinitions(opts ListOptions) ([]u.WorkflowDefinition, int64, error) {
+	rows, total, err := listPage[u.WorkflowDefinition](context.Background(), r.pool, "latest_workflow_definitions", opts, nil, nil)
+	if err != nil {
+		return nil, 0, fmt.Errorf("[ListDefinitions] %v", err)
+	}
+	return rows, total, nil
+}
+
+func (r *PgxRepository) ListDefinitionVersions(name string, opts ListOptions) ([]u.WorkflowDefinition, int64, error) {
+	rows, total, err := listPage[u.WorkflowDefinition](context.Background(), r.pool, "workflow_definitions", opts,
+		[]string{"name = $1"}, []any{name})
+	if err != nil {
+		return nil, 0, fmt.Errorf("[ListDefinitionVersions] %v", err)
+	}
+	return rows, total, nil
+}
+
+func (r *PgxRepository) ListDefinitionRuns(definitionId uuid.UUID, opts ListOptions) ([]u.Workflow, int64, error) {
+	rows, total, err := listPage[u.Workflow](context.Background(), r.pool, "workflows", opts,
+		[]string{"definition_id = $1"}, []any{definitionId})
+	if err != nil {
+		return nil, 0, fmt.Errorf("[ListDefinitionRuns] %v", err)
+	}
+	return rows, total, nil
+}
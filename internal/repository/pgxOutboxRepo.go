@@ -0,0 +1,156 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	u "github.com/Sayan-995/dwop/internal/utils"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+func insertOutboxEvents(ctx context.Context, tx pgx.Tx, events []u.OutboxEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+	batch := &pgx.Batch{}
+	for _, e := range events {
+		batch.Queue(`INSERT INTO outbox_events (event_id, task_id, workflow_id, event_type, payload, created_at,
+				published_at, claimed_at, claimed_by, lease_until, publish_attempts, last_publish_error,
+				next_attempt_at, dead_lettered_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)`,
+			e.EventID, e.TaskID, e.WorkflowId, e.Type, e.Payload, e.CreatedAt,
+			e.PublishedAt, e.ClaimedAt, e.ClaimedBy, e.LeaseUntil, e.PublishAttempts, e.LastPublishError,
+			e.NextAttemptAt, e.DeadLetteredAt)
+	}
+	if err := tx.SendBatch(ctx, batch).Close(); err != nil {
+		return fmt.Errorf("failed to insert %d outbox events: %v", len(events), err)
+	}
+	return nil
+}
+
+// ClaimOutboxEvents claims up to ClaimBatchSize unclaimed events that are
+// due for OutboxLease, highest priority first. SKIP LOCKED lets several
+// claimers run concurrently without handing out an event twice.
+func (r *PgxRepository) ClaimOutboxEvents(claimerId int) ([]u.OutboxEvent, error) {
+	events, err := collect[u.OutboxEvent](context.Background(), r.pool, `UPDATE outbox_events
+		SET claimed_at = now(), claimed_by = $1, lease_until = now() + $3 * interval '1 second'
+		WHERE event_id IN (
+			SELECT event_id FROM outbox_events
+			WHERE published_at IS NULL AND claimed_at IS NULL AND dead_lettered_at IS NULL
+			  AND (next_attempt_at IS NULL OR next_attempt_at <= now())
+			ORDER BY priority DESC, created_at
+			LIMIT $2
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING *`, claimerId, ClaimBatchSize, int(OutboxLease.Seconds()))
+	if err != nil {
+		return nil, fmt.Errorf("[ClaimOutboxEvents] %v", err)
+	}
+	return events, nil
+}
+
+// ReleaseExpiredOutboxLeases unclaims events whose claimer did not publish
+// them in time, so the next claim picks them up again.
+func (r *PgxRepository) ReleaseExpiredOutboxLeases() (int64, error) {
+	tag, err := r.pool.Exec(context.Background(), `UPDATE outbox_events
+		SET claimed_at = NULL, claimed_by = NULL, lease_until = NULL
+		WHERE published_at IS NULL AND claimed_at IS NOT NULL AND lease_until < now()`)
+	if err != nil {
+		return 0, fmt.Errorf("[ReleaseExpiredOutboxLeases] %v", err)
+	}
+	return tag.RowsAffected(), nil
+}
+
+func (r *PgxRepository) ListStuckOutboxEvents(claimedBefore time.Time, opts ListOptions) ([]u.OutboxEvent, int64, error) {
+	rows, total, err := listPage[u.OutboxEvent](context.Background(), r.pool, "outbox_events", opts,
+		[]string{"published_at IS NULL", "claimed_at < $1"}, []any{claimedBefore})
+	if err != nil {
+		return nil, 0, fmt.Errorf("[ListStuckOutboxEvents] %v", err)
+	}
+	return rows, total, nil
+}
+
+func (r *PgxRepository) GetOutboxEvent(id uuid.UUID) (*u.OutboxEvent, error) {
+	return collectOne[u.OutboxEvent](context.Background(), r.pool, "SELECT * FROM outbox_events WHERE event_id = $1", id)
+}
+
+func (r *PgxRepository) ListDeadLetterOutboxEvents(opts ListOptions) ([]u.OutboxEvent, int64, error) {
+	rows, total, err := listPage[u.OutboxEvent](context.Background(), r.pool, "outbox_events", opts,
+		[]string{"dead_lettered_at IS NOT NULL"}, nil)
+	if err != nil {
+		return nil, 0, fmt.Errorf("[ListDeadLetterOutboxEvents] %v", err)
+	}
+	return rows, total, nil
+}
+
+func (r *PgxRepository) ReplayOutboxEvent(id uuid.UUID) (bool, error) {
+	tag, err := r.pool.Exec(context.Background(), `UPDATE outbox_events
+		SET dead_lettered_at = NULL, next_attempt_at = NULL, publish_attempts = $2,
+		    claimed_at = NULL, claimed_by = NULL, lease_until = NULL
+		WHERE event_id = $1 AND dead_lettered_at IS NOT NULL`, id, PublishAttempts)
+	if err != nil {
+		return false, fmt.Errorf("[ReplayOutboxEvent] failed to replay event %s: %v", id, err)
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+func (r *PgxRepository) AddOutboxEvent(event u.OutboxEvent) error {
+	return r.AddOutboxEvents([]u.OutboxEvent{event})
+}
+
+func (r *PgxRepository) AddOutboxEvents(events []u.OutboxEvent) error {
+	err := r.withTx(func(ctx context.Context, tx pgx.Tx) error {
+		return insertOutboxEvents(ctx, tx, events)
+	})
+	if err != nil {
+		return fmt.Errorf("[AddOutboxEvents] %v", err)
+	}
+	return nil
+}
+
+func (r *PgxRepository) UpdateOutboxEvent(event u.OutboxEvent, claimerId int) (bool, error) {
+	tag, err := r.pool.Exec(context.Background(), `UPDATE outbox_events
+		SET published_at = $2, claimed_at = $3, claimed_by = $4, lease_until = $5, publish_attempts = $6,
+		    last_publish_error = $7, event_type = $8, next_attempt_at = $9, dead_lettered_at = $10
+		WHERE event_id = $1 AND claimed_by = $11 AND published_at IS NULL`,
+		event.EventID, event.PublishedAt, event.ClaimedAt, event.ClaimedBy, event.LeaseUntil, event.PublishAttempts,
+		event.LastPublishError, event.Type, event.NextAttemptAt, event.DeadLetteredAt, claimerId)
+	if err != nil {
+		return false, fmt.Errorf("[UpdateOutboxEvent] failed to update event %s: %v", event.EventID, err)
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// DeferOutboxEvent parks a published event whose run did not fit the limits.
+func (r *PgxRepository) DeferOutboxEvent(id uuid.UUID) error {
+	_, err := r.pool.Exec(context.Background(), `UPDATE outbox_events SET deferred_at = now()
+		WHERE event_id = $1 AND deferred_at IS NULL`, id)
+	if err != nil {
+		return fmt.Errorf("[DeferOutboxEvent] failed to defer event %s: %v", id, err)
+	}
+	return nil
+}
+
+func (r *PgxRepository) DeferredOutboxEvents(limit int) ([]u.OutboxEvent, error) {
+	events, err := collect[u.OutboxEvent](context.Background(), r.pool, `SELECT * FROM outbox_events
+		WHERE deferred_at IS NOT NULL ORDER BY priority DESC, deferred_at LIMIT $1`, limit)
+	if err != nil {
+		return nil, fmt.Errorf("[DeferredOutboxEvents] %v", err)
+	}
+	return events, nil
+}
+
+// ReleaseDeferredOutboxEvents makes deferred events claimable again, with a
+// fresh set of publish attempts.
+func (r *PgxRepository) ReleaseDeferredOutboxEvents(ids []uuid.UUID) (int64, error) {
+	tag, err := r.pool.Exec(context.Background(), `UPDATE outbox_events
+		SET deferred_at = NULL, published_at = NULL, claimed_at = NULL, claimed_by = NULL, lease_until = NULL,
+		    next_attempt_at = NULL, publish_attempts = $2
+		WHERE event_id = ANY($1) AND deferred_at IS NOT NULL`, ids, PublishAttempts)
+	if err != nil {
+		return 0, fmt.Errorf("[ReleaseDeferredOutboxEvents] %v", err)
+	}
+	return tag.RowsAffected(), nil
+}
@@ -0,0 +1,174 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	u "github.com/Sayan-995/dwop/internal/utils"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// PgxRepository talks to PostgreSQL directly. The operations that used to be
+// stored procedures run as ordinary statements inside one transaction.
+type PgxRepository struct {
+	pool *pgxpool.Pool
+	stop context.CancelFunc
+}
+
+func NewPgxRepository(ctx context.Context, dsn string) (*PgxRepository, error) {
+	pool, err := pgxpool.New(ctx, dsn)
+	if err != nil {
+		return nil, err
+	}
+	if err := pool.Ping(ctx); err != nil {
+		pool.Close()
+		return nil, err
+	}
+	if err := Migrate(ctx, pool); err != nil {
+		pool.Close()
+		return nil, err
+	}
+	listenCtx, stop := context.WithCancel(context.Background())
+	r := &PgxRepository{pool: pool, stop: stop}
+	go r.listen(listenCtx)
+	return r, nil
+}
+
+func (r *PgxRepository) Close() {
+	r.stop()
+	r.pool.Close()
+}
+
+func (r *PgxRepository) withTx(fn func(ctx context.Context, tx pgx.Tx) error) error {
+	ctx := context.Background()
+	return pgx.BeginFunc(ctx, r.pool, func(tx pgx.Tx) error {
+		return fn(ctx, tx)
+	})
+}
+
+type querier interface {
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+}
+
+func collect[T any](ctx context.Context, q querier, sql string, args ...any) ([]T, error) {
+	rows, err := q.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, pgx.RowToStructByName[T])
+}
+
+func collectOne[T any](ctx context.Context, q querier, sql string, args ...any) (*T, error) {
+	rows, err := q.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	row, err := pgx.CollectOneRow(rows, pgx.RowToAddrOfStructByName[T])
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	return row, err
+}
+
+// where renders the filters of a list query, starting placeholders after the
+// arguments already in args.
+func (o ListOptions) where(conds []string, args []any) (string, []any) {
+	if o.Status != "" {
+		args = append(args, o.Status)
+		conds = append(conds, fmt.Sprintf("status = $%d", len(args)))
+	}
+	if o.CreatedAfter != nil {
+		args = append(args, *o.CreatedAfter)
+		conds = append(conds, fmt.Sprintf("created_at >= $%d", len(args)))
+	}
+	if o.CreatedBefore != nil {
+		args = append(args, *o.CreatedBefore)
+		conds = append(conds, fmt.Sprintf("created_at < $%d", len(args)))
+	}
+	if len(conds) == 0 {
+		return "", args
+	}
+	return " WHERE " + strings.Join(conds, " AND "), args
+}
+
+func listPage[T any](ctx context.Context, q *pgxpool.Pool, table string, opts ListOptions, conds []string, args []any) ([]T, int64, error) {
+	where, args := opts.where(conds, args)
+	var total int64
+	if err := q.QueryRow(ctx, "SELECT count(*) FROM "+table+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+	args = append(args, opts.Limit, opts.Offset)
+	rows, err := collect[T](ctx, q, fmt.Sprintf("SELECT * FROM %s%s ORDER BY created_at DESC LIMIT $%d OFFSET $%d", table, where, len(args)-1, len(args)), args...)
+	return rows, total, err
+}
+
+func (r *PgxRepository) InsertWorkflow(workflow u.Workflow, tasks []u.Task) error {
+	if workflow.Status == "" {
+		workflow.Status = u.RunRunning
+	}
+	events := readyEvents(workflow.WorkflowId, tasks)
+	queued := make(map[uuid.UUID]bool, len(events))
+	for _, event := range events {
+		queued[event.TaskID] = true
+	}
+	rows := make([]u.Task, len(tasks))
+	for i, task := range tasks {
+		if queued[task.TaskId] {
+			task.Status = u.TaskQueued
+		}
+		rows[i] = task
+	}
+
+	fmt.Printf("[InsertWorkflow] Creating workflow %s with status %s\n", workflow.WorkflowId, workflow.Status)
+	return r.withTx(func(ctx context.Context, tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, `INSERT INTO workflows (workflow_id, env_link, params, priority, max_running, created_at, finished_at, status,
+				schedule_id, scheduled_for, definition_id, definition_version)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)`,
+			workflow.WorkflowId, workflow.EnvLink, workflow.Params, workflow.Priority, workflow.MaxRunning,
+			workflow.CreatedAt, workflow.FinishedAt, workflow.Status, workflow.ScheduleId, workflow.ScheduledFor,
+			workflow.DefinitionId, workflow.DefinitionVersion)
+		if err != nil {
+			return fmt.Errorf("[InsertWorkflow] failed to insert workflow %s: %v", workflow.WorkflowId, err)
+		}
+		if err := insertTasks(ctx, tx, rows); err != nil {
+			return fmt.Errorf("[InsertWorkflow] failed to insert tasks of %s: %v", workflow.WorkflowId, err)
+		}
+		return insertOutboxEvents(ctx, tx, events)
+	})
+}
+
+func (r *PgxRepository) GetWorkflowByID(id uuid.UUID) (*u.Workflow, error) {
+	return collectOne[u.Workflow](context.Background(), r.pool, "SELECT * FROM workflows WHERE workflow_id = $1", id)
+}
+
+func (r *PgxRepository) GetWorkflowsByStatus(status u.RunStatus) ([]u.Workflow, error) {
+	return collect[u.Workflow](context.Background(), r.pool, "SELECT * FROM workflows WHERE status = $1", status)
+}
+
+func (r *PgxRepository) ListWorkflows(opts ListOptions) ([]u.Workflow, int64, error) {
+	rows, total, err := listPage[u.Workflow](context.Background(), r.pool, "workflows", opts, nil, nil)
+	if err != nil {
+		return nil, 0, fmt.Errorf("[ListWorkflows] %v", err)
+	}
+	return rows, total, nil
+}
+
+func (r *PgxRepository) CancelWorkflowById(id string) error {
+	_, err := r.pool.Exec(context.Background(), "UPDATE workflows SET status = $2, finished_at = $3 WHERE workflow_id = $1",
+		id, u.RunCanceled, time.Now())
+	return err
+}
+
+func (r *PgxRepository) FinishWorkflow(id uuid.UUID, status u.RunStatus, finishedAt time.Time) (bool, error) {
+	tag, err := r.pool.Exec(context.Background(), "UPDATE workflows SET status = $2, finished_at = $3 WHERE workflow_id = $1 AND status = $4",
+		id, status, finishedAt, u.RunRunning)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
@@ -0,0 +1,84 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	u "github.com/Sayan-995/dwop/internal/utils"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+func (r *PgxRepository) InsertSchedule(schedule u.Schedule) error {
+	_, err := r.pool.Exec(context.Background(), `INSERT INTO schedules (schedule_id, name, cron, timezone, catch_up,
+			definition, requirements, params, priority, status, next_run_at, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)`,
+		schedule.ScheduleId, schedule.Name, schedule.Cron, schedule.Timezone, schedule.CatchUp,
+		schedule.Definition, schedule.Requirements, schedule.Params, schedule.Priority, schedule.Status,
+		schedule.NextRunAt, schedule.CreatedAt, schedule.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("[InsertSchedule] failed to insert schedule %s: %v", schedule.ScheduleId, err)
+	}
+	return nil
+}
+
+func (r *PgxRepository) GetSchedule(id uuid.UUID) (*u.Schedule, error) {
+	return collectOne[u.Schedule](context.Background(), r.pool, "SELECT * FROM schedules WHERE schedule_id = $1", id)
+}
+
+func (r *PgxRepository) ListSchedules(opts ListOptions) ([]u.Schedule, int64, error) {
+	rows, total, err := listPage[u.Schedule](context.Background(), r.pool, "schedules", opts, nil, nil)
+	if err != nil {
+		return nil, 0, fmt.Errorf("[ListSchedules] %v", err)
+	}
+	return rows, total, nil
+}
+
+func (r *PgxRepository) DueSchedules(now time.Time) ([]u.Schedule, error) {
+	rows, err := collect[u.Schedule](context.Background(), r.pool, `SELECT * FROM schedules
+		WHERE status = $1 AND next_run_at <= $2 ORDER BY next_run_at`, u.ScheduleActive, now)
+	if err != nil {
+		return nil, fmt.Errorf("[DueSchedules] %v", err)
+	}
+	return rows, nil
+}
+
+// MoveScheduleNextRun moves an active schedule's next tick from from to to,
+// and reports false if the schedule is paused or another caller moved it
+// first.
+func (r *PgxRepository) MoveScheduleNextRun(id uuid.UUID, from, to time.Time) (bool, error) {
+	tag, err := r.pool.Exec(context.Background(), `UPDATE schedules SET next_run_at = $4, updated_at = now()
+		WHERE schedule_id = $1 AND status = $2 AND next_run_at = $3`, id, u.ScheduleActive, from, to)
+	if err != nil {
+		return false, fmt.Errorf("[MoveScheduleNextRun] %v", err)
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+func (r *PgxRepository) SetScheduleStatus(id uuid.UUID, status u.ScheduleStatus, nextRunAt time.Time) (bool, error) {
+	tag, err := r.pool.Exec(context.Background(), `UPDATE schedules SET status = $2, next_run_at = $3, updated_at = now()
+		WHERE schedule_id = $1`, id, status, nextRunAt)
+	if err != nil {
+		return false, fmt.Errorf("[SetScheduleStatus] %v", err)
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+func (r *PgxRepository) ListScheduleRuns(scheduleId uuid.UUID, opts ListOptions) ([]u.Workflow, int64, error) {
+	rows, total, err := listPage[u.Workflow](context.Background(), r.pool, "workflows", opts,
+		[]string{"schedule_id = $1"}, []any{scheduleId})
+	if err != nil {
+		return nil, 0, fmt.Errorf("[ListScheduleRuns] %v", err)
+	}
+	return rows, total, nil
+}
+
+func (r *PgxRepository) ScheduledRunTimes(scheduleId uuid.UUID, from, to time.Time) ([]time.Time, error) {
+	rows, err := r.pool.Query(context.Background(), `SELECT scheduled_for FROM workflows
+		WHERE schedule_id = $1 AND scheduled_for BETWEEN $2 AND $3`, scheduleId, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("[ScheduledRunTimes] %v", err)
+	}
+	return pgx.CollectRows(rows, pgx.RowTo[time.Time])
+}
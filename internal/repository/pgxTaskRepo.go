@@ -0,0 +1,408 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	u "github.com/Sayan-995/dwop/internal/utils"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+var taskColumns = []string{
+	"task_id", "workflow_id", "name", "code_link", "code_hash", "pending_preds", "func_arg_map", "predecessors",
+	"successors", "status", "attempt", "max_attempts", "created_at", "image", "timeout_seconds", "resources",
+	"trigger_rule", "cache", "cache_key", "map_over", "parent_task_id", "map_index", "map_size", "map_collected",
+	"pool",
+}
+
+func insertTasks(ctx context.Context, tx pgx.Tx, tasks []u.Task) error {
+	_, err := tx.CopyFrom(ctx, pgx.Identifier{"tasks"}, taskColumns, pgx.CopyFromSlice(len(tasks), func(i int) ([]any, error) {
+		t := tasks[i]
+		return []any{
+			t.TaskId, t.WorkflowId, t.Name, t.CodeLink, t.CodeHash, t.PendingPreds, t.FuncArgMap, t.Predecessors,
+			t.Successors, t.Status, t.Attempt, t.MaxAttempts, t.CreatedAt, t.Image, t.TimeoutSeconds, t.Resources,
+			t.TriggerRule, t.Cache, t.CacheKey, t.MapOver, t.ParentTaskId, t.MapIndex, t.MapSize, t.MapCollected,
+			t.Pool,
+		}, nil
+	}))
+	return err
+}
+
+func (r *PgxRepository) GetTaskByID(id uuid.UUID) (*u.Task, error) {
+	return collectOne[u.Task](context.Background(), r.pool, "SELECT * FROM tasks WHERE task_id = $1", id)
+}
+
+func (r *PgxRepository) GetTaskByName(workflowId uuid.UUID, name string) (*u.Task, error) {
+	return collectOne[u.Task](context.Background(), r.pool, "SELECT * FROM tasks WHERE workflow_id = $1 AND name = $2", workflowId, name)
+}
+
+func (r *PgxRepository) GetTasksByWorkflow(workflowId uuid.UUID) ([]u.Task, error) {
+	return collect[u.Task](context.Background(), r.pool, "SELECT * FROM tasks WHERE workflow_id = $1", workflowId)
+}
+
+func (r *PgxRepository) GetChildTasks(parentId uuid.UUID) ([]u.Task, error) {
+	return collect[u.Task](context.Background(), r.pool, "SELECT * FROM tasks WHERE parent_task_id = $1 ORDER BY map_index", parentId)
+}
+
+func (r *PgxRepository) InsertMapTasks(parent u.Task, children []u.Task) error {
+	return r.withTx(func(ctx context.Context, tx pgx.Tx) error {
+		if len(children) > 0 {
+			queued := make([]u.Task, len(children))
+			events := make([]u.OutboxEvent, 0, len(children))
+			for i, child := range children {
+				child.Status = u.TaskQueued
+				queued[i] = child
+				events = append(events, u.OutboxEvent{
+					EventID:         uuid.New(),
+					WorkflowId:      child.WorkflowId,
+					TaskID:          child.TaskId,
+					Type:            u.OutboxTaskReady,
+					CreatedAt:       time.Now(),
+					PublishAttempts: PublishAttempts,
+				})
+			}
+			if err := insertTasks(ctx, tx, queued); err != nil {
+				return fmt.Errorf("[InsertMapTasks] failed to insert children of %s: %v", parent.TaskId, err)
+			}
+			if err := insertOutboxEvents(ctx, tx, events); err != nil {
+				return err
+			}
+		}
+		_, err := tx.Exec(ctx, "UPDATE tasks SET map_size = $2, status = $3 WHERE task_id = $1", parent.TaskId, len(children), u.TaskRunning)
+		if err != nil {
+			return fmt.Errorf("[InsertMapTasks] failed to update parent %s: %v", parent.TaskId, err)
+		}
+		return nil
+	})
+}
+
+func (r *PgxRepository) ClaimMapCollection(parentId uuid.UUID) (bool, error) {
+	tag, err := r.pool.Exec(context.Background(), "UPDATE tasks SET map_collected = true WHERE task_id = $1 AND NOT map_collected", parentId)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// TransitionTaskStatus finishes the workflow in the same transaction when the
+// task reaches a terminal status and was the last one to.
+func (r *PgxRepository) TransitionTaskStatus(taskId uuid.UUID, from, to u.TaskStatus) (bool, error) {
+	var won bool
+	err := r.withTx(func(ctx context.Context, tx pgx.Tx) error {
+		var workflowId uuid.UUID
+		err := tx.QueryRow(ctx, "UPDATE tasks SET status = $3 WHERE task_id = $1 AND status = $2 RETURNING workflow_id",
+			taskId, from, to).Scan(&workflowId)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		won = true
+		if !to.Terminal() {
+			return nil
+		}
+		return finishWorkflowIfDone(ctx, tx, workflowId)
+	})
+	return won, err
+}
+
+func (r *PgxRepository) QueueTask(event u.OutboxEvent) (bool, error) {
+	var queued bool
+	err := r.withTx(func(ctx context.Context, tx pgx.Tx) error {
+		tag, err := tx.Exec(ctx, "UPDATE tasks SET status = $3 WHERE task_id = $1 AND status = $2", event.TaskID, u.TaskPending, u.TaskQueued)
+		if err != nil || tag.RowsAffected() == 0 {
+			return err
+		}
+		queued = true
+		return insertOutboxEvents(ctx, tx, []u.OutboxEvent{event})
+	})
+	if err != nil {
+		return false, fmt.Errorf("[QueueTask] failed to queue task %s: %v", event.TaskID, err)
+	}
+	return queued, nil
+}
+
+// finishWorkflowIfDone moves a RUNNING workflow to SUCCEEDED or FAILED once
+// all of its tasks are terminal, like finish_workflow_if_done. Transactions
+// call it after their task updates; the workflow row is locked first, so of
+// two transactions finishing the last tasks concurrently the second one sees
+// the first one's writes.
+func finishWorkflowIfDone(ctx context.Context, tx pgx.Tx, workflowId uuid.UUID) error {
+	var locked bool
+	err := tx.QueryRow(ctx, "SELECT true FROM workflows WHERE workflow_id = $1 AND status = $2 FOR UPDATE",
+		workflowId, u.RunRunning).Scan(&locked)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	tasks, err := collect[u.Task](ctx, tx, "SELECT * FROM tasks WHERE workflow_id = $1", workflowId)
+	if err != nil {
+		return err
+	}
+	status, done := WorkflowOutcome(tasks)
+	if !done {
+		return nil
+	}
+	_, err = tx.Exec(ctx, "UPDATE workflows SET status = $2, finished_at = $3 WHERE workflow_id = $1", workflowId, status, time.Now())
+	return err
+}
+
+func (r *PgxRepository) ApplyRerun(rerun Rerun) error {
+	err := r.withTx(func(ctx context.Context, tx pgx.Tx) error {
+		if len(rerun.DropMaps) > 0 {
+			if _, err := tx.Exec(ctx, "DELETE FROM tasks WHERE parent_task_id = ANY ($1)", rerun.DropMaps); err != nil {
+				return err
+			}
+		}
+		batch := &pgx.Batch{}
+		for _, task := range rerun.Tasks {
+			batch.Queue(`UPDATE tasks
+				SET status = $2, attempt = $3, pending_preds = $4, map_size = $5, map_collected = $6
+				WHERE task_id = $1`,
+				task.TaskId, task.Status, task.Attempt, task.PendingPreds, task.MapSize, task.MapCollected)
+		}
+		if err := tx.SendBatch(ctx, batch).Close(); err != nil {
+			return err
+		}
+		_, err := tx.Exec(ctx, "UPDATE workflows SET status = $2, finished_at = NULL WHERE workflow_id = $1", rerun.WorkflowId, u.RunRunning)
+		if err != nil {
+			return err
+		}
+		return insertOutboxEvents(ctx, tx, rerun.Events)
+	})
+	if err != nil {
+		return fmt.Errorf("[ApplyRerun] failed to rerun workflow %s: %v", rerun.WorkflowId, err)
+	}
+	return nil
+}
+
+func (r *PgxRepository) SetTaskCacheKey(taskId uuid.UUID, key string) error {
+	_, err := r.pool.Exec(context.Background(), "UPDATE tasks SET cache_key = $2 WHERE task_id = $1", taskId, key)
+	return err
+}
+
+// UpsertTaskRun starts a run for a task that is waiting to run. It returns 0
+// without inserting anything when the task already has a run in progress or
+// has finished, so redelivered messages do not start a second Job. Admissions
+// under Limits hold an advisory lock, so two consumers cannot both take the
+// last slot.
+func (r *PgxRepository) UpsertTaskRun(taskRun u.TaskRun) (int64, error) {
+	var inserted int64
+	err := r.withTx(func(ctx context.Context, tx pgx.Tx) error {
+		task, err := collectOne[u.Task](ctx, tx, "SELECT * FROM tasks WHERE task_id = $1 FOR UPDATE", taskRun.TaskId)
+		if err != nil {
+			return err
+		}
+		if task == nil || task.Status == u.TaskRunning || task.Status.Terminal() {
+			return nil
+		}
+		if Limits.Enabled() && Counted(*task) {
+			if _, err := tx.Exec(ctx, "SELECT pg_advisory_xact_lock($1)", runLimitLock); err != nil {
+				return err
+			}
+			workflow, err := collectOne[u.Workflow](ctx, tx, "SELECT * FROM workflows WHERE workflow_id = $1", task.WorkflowId)
+			if err != nil || workflow == nil {
+				return fmt.Errorf("failed to load workflow %s: %v", task.WorkflowId, err)
+			}
+			counts, err := countRunning(ctx, tx)
+			if err != nil {
+				return err
+			}
+			if err := Limits.Check(counts, *workflow, *task); err != nil {
+				return err
+			}
+		}
+		if taskRun.Status == "" {
+			taskRun.Status = u.TaskRunning
+		}
+		taskRun.Attempt = task.Attempt + 1
+		_, err = tx.Exec(ctx, `INSERT INTO task_runs (task_id, run_id, workflow_id, status, attempt, last_error, lease_owner, lease_until, created_at, updated_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`,
+			taskRun.TaskId, taskRun.RunId, taskRun.WorkflowId, taskRun.Status, taskRun.Attempt, taskRun.LastError,
+			taskRun.LeaseOwner, taskRun.LeaseUntil, taskRun.CreatedAt, taskRun.UpdatedAt)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx, "UPDATE tasks SET status = $2 WHERE task_id = $1", taskRun.TaskId, u.TaskRunning); err != nil {
+			return err
+		}
+		inserted = 1
+		return nil
+	})
+	return inserted, err
+}
+
+func (r *PgxRepository) AbandonTaskRun(runId uuid.UUID, reason string) error {
+	err := r.withTx(func(ctx context.Context, tx pgx.Tx) error {
+		run, err := lockRunningRun(ctx, tx, runId.String())
+		if err != nil || run == nil {
+			return err
+		}
+		_, err = tx.Exec(ctx, "UPDATE task_runs SET status = $2, last_error = $3, updated_at = $4 WHERE run_id = $1",
+			runId, u.TaskFailed, reason, time.Now())
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(ctx, "UPDATE tasks SET status = $3 WHERE task_id = $1 AND status = $2", run.TaskId, u.TaskRunning, u.TaskQueued)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("[AbandonTaskRun] failed to abandon run %s: %v", runId, err)
+	}
+	return nil
+}
+
+func (r *PgxRepository) GetLatestTaskRun(taskId uuid.UUID) (*u.TaskRun, error) {
+	return collectOne[u.TaskRun](context.Background(), r.pool, "SELECT * FROM task_runs WHERE task_id = $1 ORDER BY created_at DESC LIMIT 1", taskId)
+}
+
+func (r *PgxRepository) GetTaskRun(runId uuid.UUID) (*u.TaskRun, error) {
+	return collectOne[u.TaskRun](context.Background(), r.pool, "SELECT * FROM task_runs WHERE run_id = $1", runId)
+}
+
+func (r *PgxRepository) ListTaskRuns(taskId uuid.UUID, opts ListOptions) ([]u.TaskRun, int64, error) {
+	rows, total, err := listPage[u.TaskRun](context.Background(), r.pool, "task_runs", opts, []string{"task_id = $1"}, []any{taskId})
+	if err != nil {
+		return nil, 0, fmt.Errorf("[ListTaskRuns] %v", err)
+	}
+	return rows, total, nil
+}
+
+// lockRunningRun locks a run and reports whether it is still RUNNING. Runs that
+// already have an outcome are left alone, which makes completion and failure
+// reports idempotent.
+func lockRunningRun(ctx context.Context, tx pgx.Tx, runId string) (*u.TaskRun, error) {
+	run, err := collectOne[u.TaskRun](ctx, tx, "SELECT * FROM task_runs WHERE run_id = $1 FOR UPDATE", runId)
+	if err != nil || run == nil || run.Status != u.TaskRunning {
+		return nil, err
+	}
+	return run, nil
+}
+
+// CompleteRunAndEnqueueSuccessors marks the run and its task SUCCEEDED and
+// releases one pending predecessor of every successor, queueing those with
+// none left.
+func (r *PgxRepository) CompleteRunAndEnqueueSuccessors(runId string) error {
+	return r.withTx(func(ctx context.Context, tx pgx.Tx) error {
+		run, err := lockRunningRun(ctx, tx, runId)
+		if err != nil || run == nil {
+			return err
+		}
+		now := time.Now()
+		if _, err := tx.Exec(ctx, "UPDATE task_runs SET status = $2, updated_at = $3 WHERE run_id = $1", run.RunId, u.TaskSucceeded, now); err != nil {
+			return err
+		}
+		var successors []string
+		err = tx.QueryRow(ctx, "UPDATE tasks SET status = $2 WHERE task_id = $1 RETURNING successors", run.TaskId, u.TaskSucceeded).Scan(&successors)
+		if err != nil {
+			return err
+		}
+		if len(successors) == 0 {
+			return finishWorkflowIfDone(ctx, tx, run.WorkflowId)
+		}
+		rows, err := tx.Query(ctx, `UPDATE tasks
+			SET pending_preds = pending_preds - 1,
+			    status = CASE WHEN pending_preds = 1 THEN $4 ELSE status END
+			WHERE workflow_id = $1 AND name = ANY ($2) AND status = $3
+			RETURNING task_id, status`,
+			run.WorkflowId, successors, u.TaskPending, u.TaskQueued)
+		if err != nil {
+			return err
+		}
+		var events []u.OutboxEvent
+		var taskId uuid.UUID
+		var status u.TaskStatus
+		_, err = pgx.ForEachRow(rows, []any{&taskId, &status}, func() error {
+			if status == u.TaskQueued {
+				events = append(events, u.OutboxEvent{
+					EventID:         uuid.New(),
+					WorkflowId:      run.WorkflowId,
+					TaskID:          taskId,
+					Type:            u.OutboxTaskReady,
+					CreatedAt:       now,
+					PublishAttempts: PublishAttempts,
+				})
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if err := insertOutboxEvents(ctx, tx, events); err != nil {
+			return err
+		}
+		return finishWorkflowIfDone(ctx, tx, run.WorkflowId)
+	})
+}
+
+// IncreaseAttempt records a failed run. The task is queued again while it has
+// attempts left and marked FAILED once it has none.
+func (r *PgxRepository) IncreaseAttempt(runId string, errmsg string) error {
+	return r.withTx(func(ctx context.Context, tx pgx.Tx) error {
+		run, err := lockRunningRun(ctx, tx, runId)
+		if err != nil || run == nil {
+			return err
+		}
+		now := time.Now()
+		_, err = tx.Exec(ctx, "UPDATE task_runs SET status = $2, last_error = $3, updated_at = $4 WHERE run_id = $1",
+			run.RunId, u.TaskFailed, errmsg, now)
+		if err != nil {
+			return err
+		}
+		var status u.TaskStatus
+		err = tx.QueryRow(ctx, `UPDATE tasks
+			SET attempt = attempt + 1,
+			    status = CASE WHEN attempt + 1 >= max_attempts THEN $2 ELSE $3 END
+			WHERE task_id = $1
+			RETURNING status`,
+			run.TaskId, u.TaskFailed, u.TaskQueued).Scan(&status)
+		if err != nil {
+			return err
+		}
+		if status != u.TaskQueued {
+			return finishWorkflowIfDone(ctx, tx, run.WorkflowId)
+		}
+		return insertOutboxEvents(ctx, tx, []u.OutboxEvent{{
+			EventID:         uuid.New(),
+			WorkflowId:      run.WorkflowId,
+			TaskID:          run.TaskId,
+			Type:            u.OutboxTaskRetryReady,
+			CreatedAt:       now,
+			PublishAttempts: PublishAttempts,
+		}})
+	})
+}
+
+// runLimitLock is the advisory lock key serializing admissions, shared with
+// the start_task_run procedure.
+const runLimitLock = 1685548912
+
+func countRunning(ctx context.Context, q querier) (RunningCounts, error) {
+	var counts RunningCounts
+	rows, err := q.Query(ctx, "SELECT workflow_id, pool, running FROM running_run_counts()")
+	if err != nil {
+		return counts, err
+	}
+	var workflowId uuid.UUID
+	var pool string
+	var running int
+	_, err = pgx.ForEachRow(rows, []any{&workflowId, &pool, &running}, func() error {
+		counts.add(workflowId, pool, running)
+		return nil
+	})
+	return counts, err
+}
+
+func (r *PgxRepository) CountRunningRuns() (RunningCounts, error) {
+	counts, err := countRunning(context.Background(), r.pool)
+	if err != nil {
+		return counts, fmt.Errorf("[CountRunningRuns] %v", err)
+	}
+	return counts, nil
+}
@@ -0,0 +1,98 @@
+package repository
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	u "github.com/Sayan-995/dwop/internal/utils"
+	"github.com/google/uuid"
+	"github.com/supabase-community/postgrest-go"
+)
+
+type ListOptions struct {
+	Status        string
+	CreatedAfter  *time.Time
+	CreatedBefore *time.Time
+	Limit         int
+	Offset        int
+}
+
+func (o ListOptions) apply(f *postgrest.FilterBuilder) *postgrest.FilterBuilder {
+	if o.Status != "" {
+		f = f.Eq("status", o.Status)
+	}
+	// both bounds filter created_at, so they go through one and=(...) clause
+	// rather than two params that would overwrite each other
+	var bounds []string
+	if o.CreatedAfter != nil {
+		bounds = append(bounds, "created_at.gte."+o.CreatedAfter.UTC().Format(time.RFC3339Nano))
+	}
+	if o.CreatedBefore != nil {
+		bounds = append(bounds, "created_at.lt."+o.CreatedBefore.UTC().Format(time.RFC3339Nano))
+	}
+	if len(bounds) > 0 {
+		f = f.And(strings.Join(bounds, ","), "")
+	}
+	return f.Order("created_at", &postgrest.OrderOpts{Ascending: false}).
+		Range(o.Offset, o.Offset+o.Limit-1, "")
+}
+
+func (r *SupabaseRepository) ListWorkflows(opts ListOptions) ([]u.Workflow, int64, error) {
+	data, count, err := opts.apply(r.db.From("workflows").Select("*", "exact", false)).Execute()
+	if err != nil {
+		return nil, 0, fmt.Errorf("[ListWorkflows] %v", err)
+	}
+	var rows []u.Workflow
+	if err := json.Unmarshal(data, &rows); err != nil {
+		return nil, 0, err
+	}
+	return rows, count, nil
+}
+
+func (r *SupabaseRepository) GetTaskByName(workflowId uuid.UUID, name string) (*u.Task, error) {
+	data, _, err := r.db.From("tasks").
+		Select("*", "", false).
+		Eq("workflow_id", workflowId.String()).
+		Eq("name", name).
+		Execute()
+	if err != nil {
+		return nil, err
+	}
+	var rows []u.Task
+	if err := json.Unmarshal(data, &rows); err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, nil
+	}
+	return &rows[0], nil
+}
+
+func (r *SupabaseRepository) ListTaskRuns(taskId uuid.UUID, opts ListOptions) ([]u.TaskRun, int64, error) {
+	data, count, err := opts.apply(r.db.From("task_runs").Select("*", "exact", false).Eq("task_id", taskId.String())).Execute()
+	if err != nil {
+		return nil, 0, fmt.Errorf("[ListTaskRuns] %v", err)
+	}
+	var rows []u.TaskRun
+	if err := json.Unmarshal(data, &rows); err != nil {
+		return nil, 0, err
+	}
+	return rows, count, nil
+}
+
+func (r *SupabaseRepository) GetTaskRun(runId uuid.UUID) (*u.TaskRun, error) {
+	data, _, err := r.db.From("task_runs").Select("*", "", false).Eq("run_id", runId.String()).Execute()
+	if err != nil {
+		return nil, err
+	}
+	var rows []u.TaskRun
+	if err := json.Unmarshal(data, &rows); err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, nil
+	}
+	return &rows[0], nil
+}
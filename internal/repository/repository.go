@@ -1,42 +1,422 @@
 package repository
 
 import (
+	"context"
 	"fmt"
-	"log"
 	"os"
+	"time"
 
-	"github.com/joho/godotenv"
-	storage_go "github.com/supabase-community/storage-go"
+	u "github.com/Sayan-995/dwop/internal/utils"
+	"github.com/google/uuid"
 	supabase "github.com/supabase-community/supabase-go"
 )
 
+const (
+	ClaimBatchSize = 200
+	// PublishAttempts is how often an event is published before it is
+	// dead-lettered.
+	PublishAttempts = 5
+	// OutboxLease is how long a claimer may hold events before publishing
+	// them. Claims older than that are released for any claimer to take.
+	OutboxLease = 2 * time.Minute
+)
+
+// Repository is the orchestrator's view of the database. Methods that stand
+// in for the original stored procedures (InsertWorkflow, ClaimOutboxEvents,
+// CompleteRunAndEnqueueSuccessors, IncreaseAttempt) must be atomic. Those that
+// can leave every task of a workflow terminal (CompleteRunAndEnqueueSuccessors,
+// IncreaseAttempt, TransitionTaskStatus) also finish the workflow, in the same
+// transaction, with the status WorkflowOutcome gives.
+type Repository interface {
+	InsertWorkflow(workflow u.Workflow, tasks []u.Task) error
+	GetWorkflowByID(id uuid.UUID) (*u.Workflow, error)
+	GetWorkflowsByStatus(status u.RunStatus) ([]u.Workflow, error)
+	ListWorkflows(opts ListOptions) ([]u.Workflow, int64, error)
+	CancelWorkflowById(id string) error
+	FinishWorkflow(id uuid.UUID, status u.RunStatus, finishedAt time.Time) (bool, error)
+
+	GetTaskByID(id uuid.UUID) (*u.Task, error)
+	GetTaskByName(workflowId uuid.UUID, name string) (*u.Task, error)
+	GetTasksByWorkflow(workflowId uuid.UUID) ([]u.Task, error)
+	GetChildTasks(parentId uuid.UUID) ([]u.Task, error)
+	InsertMapTasks(parent u.Task, children []u.Task) error
+	ClaimMapCollection(parentId uuid.UUID) (bool, error)
+	TransitionTaskStatus(taskId uuid.UUID, from, to u.TaskStatus) (bool, error)
+	// QueueTask moves the event's task from PENDING to QUEUED and inserts the
+	// event, atomically. It reports false, inserting nothing, if the task was
+	// no longer PENDING.
+	QueueTask(event u.OutboxEvent) (bool, error)
+	// ApplyRerun writes a rerun of part of a workflow in one transaction.
+	ApplyRerun(rerun Rerun) error
+	SetTaskCacheKey(taskId uuid.UUID, key string) error
+
+	// UpsertTaskRun returns an error wrapping ErrRunLimit when starting the
+	// run would exceed Limits. The check and the insert must be atomic.
+	UpsertTaskRun(taskRun u.TaskRun) (int64, error)
+	// AbandonTaskRun undoes UpsertTaskRun for a run that was never handed to
+	// an executor: the run is marked FAILED with reason and its task goes back
+	// to QUEUED, atomically, without using up an attempt.
+	AbandonTaskRun(runId uuid.UUID, reason string) error
+	CountRunningRuns() (RunningCounts, error)
+	GetLatestTaskRun(taskId uuid.UUID) (*u.TaskRun, error)
+	GetTaskRun(runId uuid.UUID) (*u.TaskRun, error)
+	ListTaskRuns(taskId uuid.UUID, opts ListOptions) ([]u.TaskRun, int64, error)
+	CompleteRunAndEnqueueSuccessors(runId string) error
+	IncreaseAttempt(runId string, errmsg string) error
+
+	ClaimOutboxEvents(claimerId int) ([]u.OutboxEvent, error)
+	ReleaseExpiredOutboxLeases() (int64, error)
+	ListStuckOutboxEvents(claimedBefore time.Time, opts ListOptions) ([]u.OutboxEvent, int64, error)
+	GetOutboxEvent(id uuid.UUID) (*u.OutboxEvent, error)
+	ListDeadLetterOutboxEvents(opts ListOptions) ([]u.OutboxEvent, int64, error)
+	// ReplayOutboxEvent gives a dead-lettered event a fresh set of publish
+	// attempts. It reports false if the event is not dead-lettered.
+	ReplayOutboxEvent(id uuid.UUID) (bool, error)
+	// DeferOutboxEvent parks a published event whose run did not fit the
+	// limits until ReleaseDeferredOutboxEvents makes it claimable again.
+	DeferOutboxEvent(id uuid.UUID) error
+	DeferredOutboxEvents(limit int) ([]u.OutboxEvent, error)
+	ReleaseDeferredOutboxEvents(ids []uuid.UUID) (int64, error)
+	AddOutboxEvent(event u.OutboxEvent) error
+	AddOutboxEvents(events []u.OutboxEvent) error
+	// UpdateOutboxEvent records how publishing an event claimed by claimerId
+	// went. It reports false, writing nothing, if the event is no longer
+	// claimed by claimerId or is already published, e.g. because a consumer
+	// deferred the message and it was released before the claimer got here.
+	UpdateOutboxEvent(event u.OutboxEvent, claimerId int) (bool, error)
+
+	InsertSchedule(schedule u.Schedule) error
+	GetSchedule(id uuid.UUID) (*u.Schedule, error)
+	ListSchedules(opts ListOptions) ([]u.Schedule, int64, error)
+	DueSchedules(now time.Time) ([]u.Schedule, error)
+	// MoveScheduleNextRun claims the tick at from by moving an active
+	// schedule's next_run_at to to. Only one caller can win a tick.
+	MoveScheduleNextRun(id uuid.UUID, from, to time.Time) (bool, error)
+	SetScheduleStatus(id uuid.UUID, status u.ScheduleStatus, nextRunAt time.Time) (bool, error)
+	ListScheduleRuns(scheduleId uuid.UUID, opts ListOptions) ([]u.Workflow, int64, error)
+	ScheduledRunTimes(scheduleId uuid.UUID, from, to time.Time) ([]time.Time, error)
+
+	// CreateDefinitionVersion stores definition as the next version of its
+	// name and returns it, or returns the latest version unchanged when its
+	// hash matches. Concurrent calls must not number two versions alike.
+	CreateDefinitionVersion(definition u.WorkflowDefinition) (*u.WorkflowDefinition, error)
+	// GetDefinitionVersion returns the latest version when version is 0.
+	GetDefinitionVersion(name string, version int) (*u.WorkflowDefinition, error)
+	ListDefinitions(opts ListOptions) ([]u.WorkflowDefinition, int64, error)
+	ListDefinitionVersions(name string, opts ListOptions) ([]u.WorkflowDefinition, int64, error)
+	ListDefinitionRuns(definitionId uuid.UUID, opts ListOptions) ([]u.Workflow, int64, error)
+}
+
 var (
-	DB            *supabase.Client
-	StorageClient *storage_go.Client
+	Repo Repository
 )
 
-func init() {
-	godotenv.Load()
-	err := NewDB()
-	if err != nil {
-		log.Fatalf("error setting up the DB connection: %v", err)
+// NewFromEnv connects straight to PostgreSQL when DWOP_DATABASE_URL is set,
+// applying migrations first, and falls back to Supabase otherwise.
+func NewFromEnv() (Repository, error) {
+	if dsn := os.Getenv("DWOP_DATABASE_URL"); dsn != "" {
+		return NewPgxRepository(context.Background(), dsn)
 	}
-	StorageClient = storage_go.NewClient(fmt.Sprintf("%v/storage/v1", os.Getenv("SUPABASE_PROJECT_URL")), os.Getenv("SUPABASE_SERVICE_KEY"), nil)
+	return NewSupabaseRepository(os.Getenv("SUPABASE_PROJECT_URL"), os.Getenv("SUPABASE_SERVICE_KEY"))
 }
 
-func NewDB() error {
-	godotenv.Load()
-	url := os.Getenv("SUPABASE_PROJECT_URL")
-	key := os.Getenv("SUPABASE_SERVICE_KEY")
+type SupabaseRepository struct {
+	db *supabase.Client
+}
 
+// NewSupabaseRepository talks to the database through PostgREST. It relies
+// on the stored procedures created by the migrations in this package.
+func NewSupabaseRepository(url, key string) (*SupabaseRepository, error) {
 	fmt.Println(url)
-
 	client, err := supabase.NewClient(url, key, nil)
-
 	if err != nil {
-		return err
+		return nil, err
 	}
+	return &SupabaseRepository{db: client}, nil
+}
+
+// Rerun puts tasks of a finished or failing workflow back to run: the
+// children of DropMaps are deleted, Tasks are written back (status, attempt,
+// pending predecessors and fan-out bookkeeping), the workflow is reopened and
+// Events are inserted.
+type Rerun struct {
+	WorkflowId uuid.UUID
+	DropMaps   []uuid.UUID
+	Tasks      []u.Task
+	Events     []u.OutboxEvent
+}
+
+// WorkflowOutcome reports the status a workflow finishes with, and false while
+// any of its tasks is not terminal yet. A task that ran out of attempts fails
+// the workflow.
+func WorkflowOutcome(tasks []u.Task) (u.RunStatus, bool) {
+	if len(tasks) == 0 {
+		return "", false
+	}
+	status := u.RunSucceeded
+	for _, task := range tasks {
+		switch {
+		case task.Status == u.TaskFailed || (task.Status != u.TaskSucceeded && task.Attempt >= task.MaxAttempts):
+			status = u.RunFailed
+		case !task.Status.Terminal():
+			return "", false
+		}
+	}
+	return status, true
+}
+
+// readyEvents builds the TASK_READY events for the tasks of a new workflow
+// that have nothing left to wait for.
+func readyEvents(workflowId uuid.UUID, tasks []u.Task) []u.OutboxEvent {
+	var events []u.OutboxEvent
+	for _, task := range tasks {
+		if task.PendingPreds != 0 || task.Status != u.TaskPending {
+			continue
+		}
+		events = append(events, u.OutboxEvent{
+			EventID:         uuid.New(),
+			WorkflowId:      workflowId,
+			TaskID:          task.TaskId,
+			Type:            u.OutboxTaskReady,
+			CreatedAt:       time.Now(),
+			PublishAttempts: PublishAttempts,
+		})
+	}
+	return events
+}
+
+func InsertWorkflow(workflow u.Workflow, tasks []u.Task) error {
+	err := Repo.InsertWorkflow(workflow, tasks)
+	if err == nil {
+		notifyOutbox()
+	}
+	return err
+}
+
+func GetWorkflowByID(id uuid.UUID) (*u.Workflow, error) {
+	return Repo.GetWorkflowByID(id)
+}
+
+func GetWorkflowsByStatus(status u.RunStatus) ([]u.Workflow, error) {
+	return Repo.GetWorkflowsByStatus(status)
+}
+
+func ListWorkflows(opts ListOptions) ([]u.Workflow, int64, error) {
+	return Repo.ListWorkflows(opts)
+}
+
+func CancelWorkflowById(id string) error {
+	return Repo.CancelWorkflowById(id)
+}
+
+func FinishWorkflow(id uuid.UUID, status u.RunStatus, finishedAt time.Time) (bool, error) {
+	return Repo.FinishWorkflow(id, status, finishedAt)
+}
+
+func GetTaskByID(id uuid.UUID) (*u.Task, error) {
+	return Repo.GetTaskByID(id)
+}
+
+func GetTaskByName(workflowId uuid.UUID, name string) (*u.Task, error) {
+	return Repo.GetTaskByName(workflowId, name)
+}
+
+func GetTasksByWorkflow(workflowId uuid.UUID) ([]u.Task, error) {
+	return Repo.GetTasksByWorkflow(workflowId)
+}
+
+func GetChildTasks(parentId uuid.UUID) ([]u.Task, error) {
+	return Repo.GetChildTasks(parentId)
+}
+
+func InsertMapTasks(parent u.Task, children []u.Task) error {
+	err := Repo.InsertMapTasks(parent, children)
+	if err == nil {
+		notifyOutbox()
+	}
+	return err
+}
+
+func ClaimMapCollection(parentId uuid.UUID) (bool, error) {
+	return Repo.ClaimMapCollection(parentId)
+}
+
+func TransitionTaskStatus(taskId uuid.UUID, from, to u.TaskStatus) (bool, error) {
+	return Repo.TransitionTaskStatus(taskId, from, to)
+}
+
+func QueueTask(event u.OutboxEvent) (bool, error) {
+	queued, err := Repo.QueueTask(event)
+	if queued {
+		notifyOutbox()
+	}
+	return queued, err
+}
+
+func ApplyRerun(rerun Rerun) error {
+	err := Repo.ApplyRerun(rerun)
+	if err == nil && len(rerun.Events) > 0 {
+		notifyOutbox()
+	}
+	return err
+}
+
+func SetTaskCacheKey(taskId uuid.UUID, key string) error {
+	return Repo.SetTaskCacheKey(taskId, key)
+}
+
+func UpsertTaskRun(taskRun u.TaskRun) (int64, error) {
+	return Repo.UpsertTaskRun(taskRun)
+}
+
+func AbandonTaskRun(runId uuid.UUID, reason string) error {
+	return Repo.AbandonTaskRun(runId, reason)
+}
+
+func CountRunningRuns() (RunningCounts, error) {
+	return Repo.CountRunningRuns()
+}
+
+func GetLatestTaskRun(taskId uuid.UUID) (*u.TaskRun, error) {
+	return Repo.GetLatestTaskRun(taskId)
+}
+
+func GetTaskRun(runId uuid.UUID) (*u.TaskRun, error) {
+	return Repo.GetTaskRun(runId)
+}
+
+func ListTaskRuns(taskId uuid.UUID, opts ListOptions) ([]u.TaskRun, int64, error) {
+	return Repo.ListTaskRuns(taskId, opts)
+}
+
+func CompleteRunAndEnqueueSuccessors(runId string) error {
+	err := Repo.CompleteRunAndEnqueueSuccessors(runId)
+	if err == nil {
+		notifyOutbox()
+	}
+	return err
+}
+
+func IncreaseAttempt(runId string, errmsg string) error {
+	err := Repo.IncreaseAttempt(runId, errmsg)
+	if err == nil {
+		notifyOutbox()
+	}
+	return err
+}
+
+func ClaimOutboxEvents(claimerId int) ([]u.OutboxEvent, error) {
+	return Repo.ClaimOutboxEvents(claimerId)
+}
+
+func ReleaseExpiredOutboxLeases() (int64, error) {
+	return Repo.ReleaseExpiredOutboxLeases()
+}
+
+func ListStuckOutboxEvents(claimedBefore time.Time, opts ListOptions) ([]u.OutboxEvent, int64, error) {
+	return Repo.ListStuckOutboxEvents(claimedBefore, opts)
+}
+
+func GetOutboxEvent(id uuid.UUID) (*u.OutboxEvent, error) {
+	return Repo.GetOutboxEvent(id)
+}
+
+func ListDeadLetterOutboxEvents(opts ListOptions) ([]u.OutboxEvent, int64, error) {
+	return Repo.ListDeadLetterOutboxEvents(opts)
+}
+
+func ReplayOutboxEvent(id uuid.UUID) (bool, error) {
+	ok, err := Repo.ReplayOutboxEvent(id)
+	if ok {
+		notifyOutbox()
+	}
+	return ok, err
+}
+
+func DeferOutboxEvent(id uuid.UUID) error {
+	return Repo.DeferOutboxEvent(id)
+}
+
+func DeferredOutboxEvents(limit int) ([]u.OutboxEvent, error) {
+	return Repo.DeferredOutboxEvents(limit)
+}
+
+func ReleaseDeferredOutboxEvents(ids []uuid.UUID) (int64, error) {
+	released, err := Repo.ReleaseDeferredOutboxEvents(ids)
+	if released > 0 {
+		notifyOutbox()
+	}
+	return released, err
+}
+
+func AddOutboxEvent(event u.OutboxEvent) error {
+	err := Repo.AddOutboxEvent(event)
+	if err == nil {
+		notifyOutbox()
+	}
+	return err
+}
+
+func AddOutboxEvents(events []u.OutboxEvent) error {
+	err := Repo.AddOutboxEvents(events)
+	if err == nil {
+		notifyOutbox()
+	}
+	return err
+}
+
+func UpdateOutboxEvent(event u.OutboxEvent, claimerId int) (bool, error) {
+	return Repo.UpdateOutboxEvent(event, claimerId)
+}
+
+func InsertSchedule(schedule u.Schedule) error {
+	return Repo.InsertSchedule(schedule)
+}
+
+func GetSchedule(id uuid.UUID) (*u.Schedule, error) {
+	return Repo.GetSchedule(id)
+}
+
+func ListSchedules(opts ListOptions) ([]u.Schedule, int64, error) {
+	return Repo.ListSchedules(opts)
+}
+
+func DueSchedules(now time.Time) ([]u.Schedule, error) {
+	return Repo.DueSchedules(now)
+}
+
+func MoveScheduleNextRun(id uuid.UUID, from, to time.Time) (bool, error) {
+	return Repo.MoveScheduleNextRun(id, from, to)
+}
+
+func SetScheduleStatus(id uuid.UUID, status u.ScheduleStatus, nextRunAt time.Time) (bool, error) {
+	return Repo.SetScheduleStatus(id, status, nextRunAt)
+}
+
+func ListScheduleRuns(scheduleId uuid.UUID, opts ListOptions) ([]u.Workflow, int64, error) {
+	return Repo.ListScheduleRuns(scheduleId, opts)
+}
+
+func ScheduledRunTimes(scheduleId uuid.UUID, from, to time.Time) ([]time.Time, error) {
+	return Repo.ScheduledRunTimes(scheduleId, from, to)
+}
+
+func CreateDefinitionVersion(definition u.WorkflowDefinition) (*u.WorkflowDefinition, error) {
+	return Repo.CreateDefinitionVersion(definition)
+}
+
+func GetDefinitionVersion(name string, version int) (*u.WorkflowDefinition, error) {
+	return Repo.GetDefinitionVersion(name, version)
+}
+
+func ListDefinitions(opts ListOptions) ([]u.WorkflowDefinition, int64, error) {
+	return Repo.ListDefinitions(opts)
+}
+
+func ListDefinitionVersions(name string, opts ListOptions) ([]u.WorkflowDefinition, int64, error) {
+	return Repo.ListDefinitionVersions(name, opts)
+}
 
-	DB = client
-	return nil
+func ListDefinitionRuns(definitionId uuid.UUID, opts ListOptions) ([]u.Workflow, int64, error) {
+	return Repo.ListDefinitionRuns(definitionId, opts)
 }
@@ -0,0 +1,128 @@
+package repository
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	u "github.com/Sayan-995/dwop/internal/utils"
+	"github.com/google/uuid"
+	"github.com/supabase-community/postgrest-go"
+)
+
+func (r *SupabaseRepository) InsertSchedule(schedule u.Schedule) error {
+	_, _, err := r.db.From("schedules").Insert(schedule, false, "", "minimal", "").Execute()
+	if err != nil {
+		return fmt.Errorf("[InsertSchedule] failed to insert schedule %s: %v", schedule.ScheduleId, err)
+	}
+	return nil
+}
+
+func (r *SupabaseRepository) GetSchedule(id uuid.UUID) (*u.Schedule, error) {
+	data, _, err := r.db.From("schedules").Select("*", "", false).Eq("schedule_id", id.String()).Execute()
+	if err != nil {
+		return nil, err
+	}
+	var rows []u.Schedule
+	if err := json.Unmarshal(data, &rows); err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, nil
+	}
+	return &rows[0], nil
+}
+
+func (r *SupabaseRepository) ListSchedules(opts ListOptions) ([]u.Schedule, int64, error) {
+	data, count, err := opts.apply(r.db.From("schedules").Select("*", "exact", false)).Execute()
+	if err != nil {
+		return nil, 0, fmt.Errorf("[ListSchedules] %v", err)
+	}
+	var rows []u.Schedule
+	if err := json.Unmarshal(data, &rows); err != nil {
+		return nil, 0, err
+	}
+	return rows, count, nil
+}
+
+func (r *SupabaseRepository) DueSchedules(now time.Time) ([]u.Schedule, error) {
+	data, _, err := r.db.From("schedules").Select("*", "", false).
+		Eq("status", string(u.ScheduleActive)).
+		Lte("next_run_at", now.UTC().Format(time.RFC3339Nano)).
+		Order("next_run_at", &postgrest.OrderOpts{Ascending: true}).
+		Execute()
+	if err != nil {
+		return nil, fmt.Errorf("[DueSchedules] %v", err)
+	}
+	var rows []u.Schedule
+	if err := json.Unmarshal(data, &rows); err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
+
+func (r *SupabaseRepository) MoveScheduleNextRun(id uuid.UUID, from, to time.Time) (bool, error) {
+	data, _, err := r.db.From("schedules").
+		Update(map[string]any{"next_run_at": to, "updated_at": time.Now()}, "representation", "").
+		Eq("schedule_id", id.String()).
+		Eq("status", string(u.ScheduleActive)).
+		Eq("next_run_at", from.UTC().Format(time.RFC3339Nano)).
+		Execute()
+	if err != nil {
+		return false, fmt.Errorf("[MoveScheduleNextRun] %v", err)
+	}
+	var rows []u.Schedule
+	if err := json.Unmarshal(data, &rows); err != nil {
+		return false, err
+	}
+	return len(rows) > 0, nil
+}
+
+func (r *SupabaseRepository) SetScheduleStatus(id uuid.UUID, status u.ScheduleStatus, nextRunAt time.Time) (bool, error) {
+	data, _, err := r.db.From("schedules").
+		Update(map[string]any{"status": status, "next_run_at": nextRunAt, "updated_at": time.Now()}, "representation", "").
+		Eq("schedule_id", id.String()).
+		Execute()
+	if err != nil {
+		return false, fmt.Errorf("[SetScheduleStatus] %v", err)
+	}
+	var rows []u.Schedule
+	if err := json.Unmarshal(data, &rows); err != nil {
+		return false, err
+	}
+	return len(rows) > 0, nil
+}
+
+func (r *SupabaseRepository) ListScheduleRuns(scheduleId uuid.UUID, opts ListOptions) ([]u.Workflow, int64, error) {
+	data, count, err := opts.apply(r.db.From("workflows").Select("*", "exact", false).Eq("schedule_id", scheduleId.String())).Execute()
+	if err != nil {
+		return nil, 0, fmt.Errorf("[ListScheduleRuns] %v", err)
+	}
+	var rows []u.Workflow
+	if err := json.Unmarshal(data, &rows); err != nil {
+		return nil, 0, err
+	}
+	return rows, count, nil
+}
+
+func (r *SupabaseRepository) ScheduledRunTimes(scheduleId uuid.UUID, from, to time.Time) ([]time.Time, error) {
+	data, _, err := r.db.From("workflows").Select("scheduled_for", "", false).
+		Eq("schedule_id", scheduleId.String()).
+		Gte("scheduled_for", from.UTC().Format(time.RFC3339Nano)).
+		Lte("scheduled_for", to.UTC().Format(time.RFC3339Nano)).
+		Execute()
+	if err != nil {
+		return nil, fmt.Errorf("[ScheduledRunTimes] %v", err)
+	}
+	var rows []struct {
+		ScheduledFor time.Time `json:"scheduled_for"`
+	}
+	if err := json.Unmarshal(data, &rows); err != nil {
+		return nil, err
+	}
+	times := make([]time.Time, len(rows))
+	for i, row := range rows {
+		times[i] = row.ScheduledFor
+	}
+	return times, nil
+}
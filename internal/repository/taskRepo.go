@@ -2,49 +2,157 @@ package repository
 
 import (
 	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
 
 	"github.com/Sayan-995/dwop/internal/utils"
 	"github.com/google/uuid"
+	"github.com/supabase-community/postgrest-go"
 )
 
-// "sync"
-
-func InsertTask(tasks any) error {
-	_, _, err := DB.
+func (r *SupabaseRepository) insertTasks(tasks []utils.Task) error {
+	_, _, err := r.db.
 		From("tasks").
 		Insert(tasks, false, "", "", "").
 		Execute()
 	return err
 }
-func InsertTaskRun(taskRuns any) error {
-	_, _, err := DB.
-		From("task_runs").
-		Insert(taskRuns, false, "", "", "").
+
+func (r *SupabaseRepository) UpsertTaskRun(taskRun utils.TaskRun) (int64, error) {
+	data := r.db.Rpc("start_task_run", "", map[string]any{
+		"run":              taskRun,
+		"max_global":       Limits.Global,
+		"max_per_workflow": Limits.PerWorkflow,
+		"pool_slots":       Limits.Pools,
+	})
+	if strings.HasPrefix(strings.TrimSpace(data), `{"code"`) {
+		return 0, fmt.Errorf("[UpsertTaskRun] RPC error: %s", data)
+	}
+	var result string
+	if err := json.Unmarshal([]byte(data), &result); err != nil {
+		return 0, fmt.Errorf("[UpsertTaskRun] invalid response: %v", err)
+	}
+	switch {
+	case result == "started":
+		return 1, nil
+	case strings.HasPrefix(result, "limit: "):
+		return 0, fmt.Errorf("%w: %s", ErrRunLimit, strings.TrimPrefix(result, "limit: "))
+	}
+	return 0, nil
+}
+
+func (r *SupabaseRepository) CountRunningRuns() (RunningCounts, error) {
+	var counts RunningCounts
+	data := r.db.Rpc("running_run_counts", "", nil)
+	if strings.HasPrefix(strings.TrimSpace(data), `{"code"`) {
+		return counts, fmt.Errorf("[CountRunningRuns] RPC error: %s", data)
+	}
+	var rows []struct {
+		WorkflowId uuid.UUID `json:"workflow_id"`
+		Pool       string    `json:"pool"`
+		Running    int       `json:"running"`
+	}
+	if err := json.Unmarshal([]byte(data), &rows); err != nil {
+		return counts, fmt.Errorf("[CountRunningRuns] invalid response: %v", err)
+	}
+	for _, row := range rows {
+		counts.add(row.WorkflowId, row.Pool, row.Running)
+	}
+	return counts, nil
+}
+
+func (r *SupabaseRepository) GetTaskByID(id uuid.UUID) (*utils.Task, error) {
+	data, _, err := r.db.From("tasks").Select("*", "", false).Eq("task_id", id.String()).Execute()
+	if err != nil {
+		return nil, err
+	}
+	var rows []utils.Task
+	if err := json.Unmarshal(data, &rows); err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, nil
+	}
+	return &rows[0], nil
+}
+
+func (r *SupabaseRepository) GetChildTasks(parentId uuid.UUID) ([]utils.Task, error) {
+	data, _, err := r.db.From("tasks").
+		Select("*", "", false).
+		Eq("parent_task_id", parentId.String()).
+		Order("map_index", &postgrest.OrderOpts{Ascending: true}).
 		Execute()
-	return err
+	if err != nil {
+		return nil, err
+	}
+	var rows []utils.Task
+	if err := json.Unmarshal(data, &rows); err != nil {
+		return nil, err
+	}
+	return rows, nil
 }
 
-func UpsertTaskRun(taskRuns any) (int64, error) {
-	_, count, err := DB.
-		From("task_runs").
-		Insert(taskRuns, true, "", "minimal", "exact").
+func (r *SupabaseRepository) InsertMapTasks(parent utils.Task, children []utils.Task) error {
+	size := len(children)
+	if size > 0 {
+		if err := r.insertTasks(children); err != nil {
+			return fmt.Errorf("[InsertMapTasks] failed to insert children of %s: %v", parent.TaskId, err)
+		}
+		events := make([]utils.OutboxEvent, 0, size)
+		for _, child := range children {
+			events = append(events, utils.OutboxEvent{
+				EventID:         uuid.New(),
+				WorkflowId:      child.WorkflowId,
+				TaskID:          child.TaskId,
+				Type:            utils.OutboxTaskReady,
+				CreatedAt:       time.Now(),
+				PublishAttempts: PublishAttempts,
+			})
+		}
+		if err := r.AddOutboxEvents(events); err != nil {
+			return err
+		}
+	}
+	_, _, err := r.db.From("tasks").
+		Update(map[string]any{"map_size": size, "status": utils.TaskRunning}, "minimal", "").
+		Eq("task_id", parent.TaskId.String()).
 		Execute()
-	return count, err
+	if err != nil {
+		return fmt.Errorf("[InsertMapTasks] failed to update parent %s: %v", parent.TaskId, err)
+	}
+	return nil
 }
-func UpdateTaskStatus(taskId string, status utils.TaskStatus) error {
-	_, _, err := DB.From("tasks").
-		Update(map[string]any{"status": status}, "minimal", "").
-		Eq("task_id", taskId).
+
+// ClaimMapCollection flips map_collected on a mapped parent and reports whether
+// this caller won, so only one observer gathers the children's outputs.
+func (r *SupabaseRepository) ClaimMapCollection(parentId uuid.UUID) (bool, error) {
+	data, _, err := r.db.From("tasks").
+		Update(map[string]any{"map_collected": true}, "representation", "").
+		Eq("task_id", parentId.String()).
+		Eq("map_collected", "false").
 		Execute()
-	return err
+	if err != nil {
+		return false, err
+	}
+	var rows []utils.Task
+	if err := json.Unmarshal(data, &rows); err != nil {
+		return false, err
+	}
+	return len(rows) > 0, nil
 }
 
-func GetTaskByID(id uuid.UUID) (*utils.Task, error) {
-	data, _, err := DB.From("tasks").Select("*", "", false).Eq("task_id", id.String()).Execute()
+func (r *SupabaseRepository) GetLatestTaskRun(taskId uuid.UUID) (*utils.TaskRun, error) {
+	data, _, err := r.db.From("task_runs").
+		Select("*", "", false).
+		Eq("task_id", taskId.String()).
+		Order("created_at", &postgrest.OrderOpts{Ascending: false}).
+		Limit(1, "").
+		Execute()
 	if err != nil {
 		return nil, err
 	}
-	var rows []utils.Task
+	var rows []utils.TaskRun
 	if err := json.Unmarshal(data, &rows); err != nil {
 		return nil, err
 	}
@@ -53,3 +161,75 @@ func GetTaskByID(id uuid.UUID) (*utils.Task, error) {
 	}
 	return &rows[0], nil
 }
+
+func (r *SupabaseRepository) GetTasksByWorkflow(workflowId uuid.UUID) ([]utils.Task, error) {
+	data, _, err := r.db.From("tasks").
+		Select("*", "", false).
+		Eq("workflow_id", workflowId.String()).
+		Execute()
+	if err != nil {
+		return nil, err
+	}
+	var rows []utils.Task
+	if err := json.Unmarshal(data, &rows); err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
+
+// TransitionTaskStatus moves a task from one status to another only if it is
+// still in the expected status, and reports whether this caller made the move.
+// The transition_task_status procedure also finishes the workflow when the
+// task was the last one to reach a terminal status.
+func (r *SupabaseRepository) TransitionTaskStatus(taskId uuid.UUID, from, to utils.TaskStatus) (bool, error) {
+	data := r.db.Rpc("transition_task_status", "", map[string]string{
+		"p_task_id": taskId.String(),
+		"p_from":    string(from),
+		"p_to":      string(to),
+	})
+	if strings.HasPrefix(strings.TrimSpace(data), `{"code"`) {
+		return false, fmt.Errorf("rpc transition_task_status failed: %v", data)
+	}
+	var won bool
+	if err := json.Unmarshal([]byte(data), &won); err != nil {
+		return false, fmt.Errorf("rpc transition_task_status: invalid response: %v", err)
+	}
+	return won, nil
+}
+
+func (r *SupabaseRepository) QueueTask(event utils.OutboxEvent) (bool, error) {
+	data := r.db.Rpc("queue_task", "", map[string]any{"event": event})
+	if strings.HasPrefix(strings.TrimSpace(data), `{"code"`) {
+		return false, fmt.Errorf("rpc queue_task failed: %v", data)
+	}
+	var queued bool
+	if err := json.Unmarshal([]byte(data), &queued); err != nil {
+		return false, fmt.Errorf("rpc queue_task: invalid response: %v", err)
+	}
+	return queued, nil
+}
+
+func (r *SupabaseRepository) ApplyRerun(rerun Rerun) error {
+	dropMaps := rerun.DropMaps
+	if dropMaps == nil {
+		dropMaps = []uuid.UUID{}
+	}
+	data := r.db.Rpc("apply_rerun", "", map[string]any{
+		"p_workflow_id": rerun.WorkflowId,
+		"drop_maps":     dropMaps,
+		"tasks":         rerun.Tasks,
+		"outbox_events": rerun.Events,
+	})
+	if strings.HasPrefix(strings.TrimSpace(data), `{"code"`) {
+		return fmt.Errorf("[ApplyRerun] RPC error: %s", data)
+	}
+	return nil
+}
+
+func (r *SupabaseRepository) SetTaskCacheKey(taskId uuid.UUID, key string) error {
+	_, _, err := r.db.From("tasks").
+		Update(map[string]any{"cache_key": key}, "minimal", "").
+		Eq("task_id", taskId.String()).
+		Execute()
+	return err
+}
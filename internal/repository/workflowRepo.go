@@ -3,8 +3,6 @@ package repository
 import (
 	"encoding/json"
 	"fmt"
-
-	// "log"
 	"strings"
 	"time"
 
@@ -12,24 +10,8 @@ import (
 	"github.com/google/uuid"
 )
 
-func InsertWorkflow(workflow u.Workflow, tasks []u.Task) error {
-	var outboxEvents []u.OutboxEvent
-	for _, task := range tasks {
-		if task.PendingPreds != 0 {
-			continue
-		}
-		fmt.Println(task.TaskId)
-		event := u.OutboxEvent{
-			EventID:         uuid.New(),
-			WorkflowId:      workflow.WorkflowId,
-			TaskID:          task.TaskId,
-			Type:            u.OutboxTaskReady,
-			CreatedAt:       time.Now(),
-			PublishAttempts: 5,
-		}
-		fmt.Println(event.EventID)
-		outboxEvents = append(outboxEvents, event)
-	}
+func (r *SupabaseRepository) InsertWorkflow(workflow u.Workflow, tasks []u.Task) error {
+	outboxEvents := readyEvents(workflow.WorkflowId, tasks)
 
 	if workflow.Status == "" {
 		workflow.Status = u.RunRunning
@@ -42,12 +24,12 @@ func InsertWorkflow(workflow u.Workflow, tasks []u.Task) error {
 	}
 
 	fmt.Printf("[InsertWorkflow] Creating workflow %s with status %s\n", workflow.WorkflowId, workflow.Status)
-	data := DB.Rpc("create_workflow_with_tasks_and_outbox", "", args)
+	data := r.db.Rpc("create_workflow_with_tasks_and_outbox", "", args)
 	if strings.Contains(data, `"code"`) {
 		return fmt.Errorf("rpc create_workflow_with_tasks_and_outbox failed: %v", data)
 	}
 
-	_, _, err := DB.From("workflows").Update(map[string]string{
+	_, _, err := r.db.From("workflows").Update(map[string]string{
 		"status": string(u.RunRunning),
 	}, "", "").Eq("workflow_id", workflow.WorkflowId.String()).Execute()
 	if err != nil {
@@ -59,8 +41,8 @@ func InsertWorkflow(workflow u.Workflow, tasks []u.Task) error {
 	return nil
 }
 
-func GetWorkflowByID(id uuid.UUID) (*u.Workflow, error) {
-	data, _, err := DB.From("workflows").Select("*", "", false).Eq("workflow_id", id.String()).Execute()
+func (r *SupabaseRepository) GetWorkflowByID(id uuid.UUID) (*u.Workflow, error) {
+	data, _, err := r.db.From("workflows").Select("*", "", false).Eq("workflow_id", id.String()).Execute()
 	if err != nil {
 		return nil, err
 	}
@@ -74,9 +56,42 @@ func GetWorkflowByID(id uuid.UUID) (*u.Workflow, error) {
 	return &rows[0], nil
 }
 
-func CancelWorkflowById(id string) error {
-	_, _, err := DB.From("workflows").Update(map[string]string{
-		"status": string(u.RunCanceled),
+func (r *SupabaseRepository) CancelWorkflowById(id string) error {
+	_, _, err := r.db.From("workflows").Update(map[string]any{
+		"status":      u.RunCanceled,
+		"finished_at": time.Now(),
 	}, "", "").Eq("workflow_id", id).Execute()
 	return err
 }
+
+func (r *SupabaseRepository) GetWorkflowsByStatus(status u.RunStatus) ([]u.Workflow, error) {
+	data, _, err := r.db.From("workflows").Select("*", "", false).Eq("status", string(status)).Execute()
+	if err != nil {
+		return nil, err
+	}
+	var rows []u.Workflow
+	if err = json.Unmarshal(data, &rows); err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
+
+// FinishWorkflow records the final status of a workflow that is still
+// RUNNING, and reports whether this call made the transition.
+func (r *SupabaseRepository) FinishWorkflow(id uuid.UUID, status u.RunStatus, finishedAt time.Time) (bool, error) {
+	data, _, err := r.db.From("workflows").Update(map[string]any{
+		"status":      status,
+		"finished_at": finishedAt,
+	}, "representation", "").
+		Eq("workflow_id", id.String()).
+		Eq("status", string(u.RunRunning)).
+		Execute()
+	if err != nil {
+		return false, err
+	}
+	var rows []u.Workflow
+	if err = json.Unmarshal(data, &rows); err != nil {
+		return false, err
+	}
+	return len(rows) > 0, nil
+}
@@ -0,0 +1,70 @@
+package scheduler
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Sayan-995/dwop/internal/mapper"
+	"github.com/Sayan-995/dwop/internal/repository"
+	u "github.com/Sayan-995/dwop/internal/utils"
+	"github.com/google/uuid"
+)
+
+// FinalizeWorkflow moves a RUNNING workflow to SUCCEEDED or FAILED once every
+// task has reached a terminal state. Workflows are normally finished by the
+// repository in the transaction that settles their last task; this covers
+// workflows inserted with nothing left to run and the periodic sweep. It is
+// safe to call repeatedly and from several observers: only the first caller
+// flips the status.
+func FinalizeWorkflow(workflowId uuid.UUID) error {
+	tasks, err := repository.GetTasksByWorkflow(workflowId)
+	if err != nil {
+		return err
+	}
+	status, done := repository.WorkflowOutcome(tasks)
+	if !done {
+		return nil
+	}
+	won, err := repository.FinishWorkflow(workflowId, status, time.Now())
+	if err != nil {
+		return err
+	}
+	if won {
+		fmt.Printf("[Scheduler] Workflow %s finished with status %s\n", workflowId, status)
+	}
+	return nil
+}
+
+// SweepRunningWorkflows is the safety net for every RUNNING workflow: it
+// completes mapped tasks whose children all succeeded but whose outputs were
+// never collected, re-resolves the successors of finished tasks, which picks
+// up a skip whose propagation was cut short by a crash, and finalizes
+// workflows left RUNNING with all of their tasks terminal.
+func SweepRunningWorkflows() error {
+	workflows, err := repository.GetWorkflowsByStatus(u.RunRunning)
+	if err != nil {
+		return err
+	}
+	for _, wf := range workflows {
+		if err := collectMaps(wf.WorkflowId); err != nil {
+			fmt.Printf("[Scheduler] ERROR collecting mapped tasks of workflow %s: %v\n", wf.WorkflowId, err)
+		}
+		if err := Resolve(wf.WorkflowId); err != nil {
+			fmt.Printf("[Scheduler] ERROR sweeping workflow %s: %v\n", wf.WorkflowId, err)
+		}
+	}
+	return nil
+}
+
+func collectMaps(workflowId uuid.UUID) error {
+	tasks, err := repository.GetTasksByWorkflow(workflowId)
+	if err != nil {
+		return err
+	}
+	for _, task := range tasks {
+		if err := mapper.Collect(task); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package scheduler
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Sayan-995/dwop/internal/mapper"
+	"github.com/Sayan-995/dwop/internal/repository"
+	"github.com/Sayan-995/dwop/internal/storage"
+	u "github.com/Sayan-995/dwop/internal/utils"
+	"github.com/google/uuid"
+)
+
+// flakyStore fails the first failures uploads.
+type flakyStore struct {
+	storage.ArtifactStore
+	failures int
+}
+
+func (s *flakyStore) Upload(bucket storage.Bucket, path string, data io.Reader) error {
+	if s.failures > 0 {
+		s.failures--
+		return errors.New("store unavailable")
+	}
+	return s.ArtifactStore.Upload(bucket, path, data)
+}
+
+// startRun starts a run of a queued task the way the consumer does.
+func startRun(t *testing.T, task u.Task) uuid.UUID {
+	t.Helper()
+	runId := uuid.New()
+	n, err := repository.UpsertTaskRun(u.TaskRun{RunId: runId, TaskId: task.TaskId, WorkflowId: task.WorkflowId, CreatedAt: time.Now()})
+	if err != nil || n != 1 {
+		t.Fatalf("starting %s: %d, %v", task.Name, n, err)
+	}
+	return runId
+}
+
+func TestSweepCollectsMapAfterFailedUpload(t *testing.T) {
+	repository.Repo = repository.NewMemoryRepository()
+	mem, err := storage.NewMemoryStore("http://localhost", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := &flakyStore{ArtifactStore: mem}
+	storage.Store = store
+
+	workflow := u.Workflow{WorkflowId: uuid.New(), Status: u.RunRunning, CreatedAt: time.Now()}
+	parent := u.Task{TaskId: uuid.New(), WorkflowId: workflow.WorkflowId, Name: "process", MapOver: "split",
+		Successors: []string{"sum"}, Status: u.TaskPending, MaxAttempts: 3, CreatedAt: time.Now()}
+	sum := u.Task{TaskId: uuid.New(), WorkflowId: workflow.WorkflowId, Name: "sum", Predecessors: []string{"process"},
+		PendingPreds: 1, Status: u.TaskPending, MaxAttempts: 3, CreatedAt: time.Now()}
+	if err := repository.InsertWorkflow(workflow, []u.Task{parent, sum}); err != nil {
+		t.Fatal(err)
+	}
+	startRun(t, parent)
+	var children []u.Task
+	for i := 0; i < 2; i++ {
+		index, parentId := i, parent.TaskId
+		children = append(children, u.Task{TaskId: uuid.New(), WorkflowId: workflow.WorkflowId, Name: fmt.Sprintf("process.%d", i),
+			MapOver: "split", ParentTaskId: &parentId, MapIndex: &index, MaxAttempts: 3, CreatedAt: time.Now()})
+	}
+	if err := repository.InsertMapTasks(parent, children); err != nil {
+		t.Fatal(err)
+	}
+
+	// the children succeed, but uploading the combined output fails once
+	store.failures = 1
+	for i, child := range children {
+		runId := startRun(t, child)
+		if err := mem.Upload(storage.OutputBucket, mapper.OutputPath(workflow.WorkflowId, child.Name), strings.NewReader(fmt.Sprint(i+1))); err != nil {
+			t.Fatal(err)
+		}
+		if err := repository.CompleteRunAndEnqueueSuccessors(runId.String()); err != nil {
+			t.Fatal(err)
+		}
+		err := TaskSucceeded(child.TaskId)
+		if last := i == len(children)-1; last != (err != nil) {
+			t.Fatalf("completing %s: %v", child.Name, err)
+		}
+	}
+	stored, _ := repository.GetTaskByID(parent.TaskId)
+	if stored.Status != u.TaskRunning {
+		t.Fatalf("parent is %s after a failed collection, want RUNNING", stored.Status)
+	}
+
+	if err := SweepRunningWorkflows(); err != nil {
+		t.Fatal(err)
+	}
+	stored, _ = repository.GetTaskByID(parent.TaskId)
+	if stored.Status != u.TaskSucceeded {
+		t.Fatalf("parent is %s after the sweep, want SUCCEEDED", stored.Status)
+	}
+	out, err := mem.Download(storage.OutputBucket, mapper.OutputPath(workflow.WorkflowId, parent.Name))
+	if err != nil || string(out) != "[1,2]" {
+		t.Fatalf("parent output is %q (%v), want [1,2]", out, err)
+	}
+	stored, _ = repository.GetTaskByID(sum.TaskId)
+	if stored.Status != u.TaskQueued {
+		t.Fatalf("successor is %s after the sweep, want QUEUED", stored.Status)
+	}
+}
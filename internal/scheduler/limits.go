@@ -0,0 +1,74 @@
+package scheduler
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/Sayan-995/dwop/internal/repository"
+	u "github.com/Sayan-995/dwop/internal/utils"
+	"github.com/google/uuid"
+)
+
+var releaseMu sync.Mutex
+
+// ReleaseDeferred makes deferred outbox events claimable again, in priority
+// order, for as many of them as fit the concurrency limits now. It is called
+// whenever a slot may have freed up; a consumer can still defer a released
+// event again if another run took the slot first.
+func ReleaseDeferred() error {
+	releaseMu.Lock()
+	defer releaseMu.Unlock()
+
+	events, err := repository.DeferredOutboxEvents(repository.ClaimBatchSize)
+	if err != nil || len(events) == 0 {
+		return err
+	}
+	limits := repository.Limits
+	counts, err := repository.CountRunningRuns()
+	if err != nil {
+		return err
+	}
+	if limits.Global > 0 && counts.Global >= limits.Global {
+		return nil
+	}
+
+	workflows := make(map[uuid.UUID]*u.Workflow)
+	var ids []uuid.UUID
+	for _, event := range events {
+		if !limits.Enabled() {
+			ids = append(ids, event.EventID)
+			continue
+		}
+		workflow, ok := workflows[event.WorkflowId]
+		if !ok {
+			if workflow, err = repository.GetWorkflowByID(event.WorkflowId); err != nil {
+				return err
+			}
+			workflows[event.WorkflowId] = workflow
+		}
+		task, err := repository.GetTaskByID(event.TaskID)
+		if err != nil {
+			return err
+		}
+		// Events of finished workflows or deleted tasks are released so the
+		// consumer can drop them the usual way.
+		if workflow == nil || task == nil || workflow.Status != u.RunRunning {
+			ids = append(ids, event.EventID)
+			continue
+		}
+		if limits.Check(counts, *workflow, *task) != nil {
+			continue
+		}
+		counts.Add(*task)
+		ids = append(ids, event.EventID)
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	released, err := repository.ReleaseDeferredOutboxEvents(ids)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("[ReleaseDeferred] Released %d of %d deferred events\n", released, len(events))
+	return nil
+}
@@ -0,0 +1,238 @@
+package scheduler
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Sayan-995/dwop/internal/repository"
+	u "github.com/Sayan-995/dwop/internal/utils"
+	"github.com/google/uuid"
+)
+
+var (
+	ErrConflict = errors.New("conflict")
+)
+
+type RerunResult struct {
+	Queued []string `json:"queued"`
+	Reset  []string `json:"reset"`
+}
+
+// RetryTask reruns a finished task with a fresh attempt counter. Downstream
+// tasks that never ran because of it are reset too; with cascade every
+// downstream task is rerun. Outputs of everything else are reused as-is.
+func RetryTask(workflowId uuid.UUID, name string, cascade bool) (*RerunResult, error) {
+	tasks, err := repository.GetTasksByWorkflow(workflowId)
+	if err != nil {
+		return nil, err
+	}
+	return rerun(workflowId, tasks, []string{name}, cascade)
+}
+
+// Resume retries every task that has failed for good, together with the
+// downstream tasks that were skipped because of it.
+func Resume(workflowId uuid.UUID) (*RerunResult, error) {
+	tasks, err := repository.GetTasksByWorkflow(workflowId)
+	if err != nil {
+		return nil, err
+	}
+	var roots []string
+	for _, task := range tasks {
+		if task.ParentTaskId == nil && failedForGood(task) {
+			roots = append(roots, task.Name)
+		}
+	}
+	if len(roots) == 0 {
+		return nil, fmt.Errorf("workflow %s has no failed tasks to resume: %w", workflowId, ErrConflict)
+	}
+	return rerun(workflowId, tasks, roots, false)
+}
+
+type rerunPlan struct {
+	byName   map[string]*u.Task
+	children map[uuid.UUID][]*u.Task
+	roots    map[string]bool
+	reset    map[string]bool
+	order    []*u.Task
+	queued   []*u.Task
+	dropMaps []uuid.UUID
+}
+
+func rerun(workflowId uuid.UUID, tasks []u.Task, roots []string, cascade bool) (*RerunResult, error) {
+	p := &rerunPlan{
+		byName:   make(map[string]*u.Task, len(tasks)),
+		children: make(map[uuid.UUID][]*u.Task),
+		roots:    make(map[string]bool, len(roots)),
+		reset:    make(map[string]bool),
+	}
+	for i := range tasks {
+		task := &tasks[i]
+		p.byName[task.Name] = task
+		if task.ParentTaskId != nil {
+			p.children[*task.ParentTaskId] = append(p.children[*task.ParentTaskId], task)
+		}
+	}
+
+	for _, name := range roots {
+		task := p.byName[name]
+		if task == nil {
+			return nil, fmt.Errorf("task %q not found in workflow %s: %w", name, workflowId, ErrConflict)
+		}
+		if task.ParentTaskId != nil {
+			return nil, fmt.Errorf("task %q is part of a mapped task, retry the mapped task instead: %w", name, ErrConflict)
+		}
+		if !task.Status.Terminal() && !failedForGood(*task) {
+			return nil, fmt.Errorf("task %q is %s and cannot be retried until it finishes: %w", name, task.Status, ErrConflict)
+		}
+		p.roots[name] = true
+		p.include(task)
+	}
+	if err := p.walkDownstream(roots, cascade); err != nil {
+		return nil, err
+	}
+
+	for _, task := range p.order {
+		if err := p.schedule(task); err != nil {
+			return nil, err
+		}
+	}
+	return p.apply(workflowId)
+}
+
+func (p *rerunPlan) include(task *u.Task) {
+	p.reset[task.Name] = true
+	p.order = append(p.order, task)
+	task.Status = u.TaskPending
+	task.Attempt = 0
+}
+
+func (p *rerunPlan) walkDownstream(roots []string, cascade bool) error {
+	queue := append([]string(nil), roots...)
+	for len(queue) > 0 {
+		current := p.byName[queue[0]]
+		queue = queue[1:]
+		for _, name := range current.Successors {
+			succ := p.byName[name]
+			if succ == nil || p.reset[name] {
+				continue
+			}
+			switch succ.Status {
+			case u.TaskQueued, u.TaskRunning:
+				if cascade {
+					return fmt.Errorf("downstream task %q is %s: %w", name, succ.Status, ErrConflict)
+				}
+				continue
+			case u.TaskSucceeded, u.TaskFailed:
+				if !cascade {
+					continue
+				}
+			}
+			p.include(succ)
+			queue = append(queue, name)
+		}
+	}
+	return nil
+}
+
+// schedule decides the new state of a task being rerun, given that every task
+// in the plan has been put back to PENDING.
+func (p *rerunPlan) schedule(task *u.Task) error {
+	if task.MapOver != "" && task.MapSize != nil {
+		if p.roots[task.Name] && !task.MapCollected && p.retryFailedChildren(task) {
+			return nil
+		}
+		p.dropMaps = append(p.dropMaps, task.TaskId)
+		task.MapSize = nil
+		task.MapCollected = false
+	}
+
+	pending := 0
+	for _, pred := range task.Predecessors {
+		if pt := p.byName[pred]; pt == nil || pt.Status != u.TaskSucceeded {
+			pending++
+		}
+	}
+	task.PendingPreds = pending
+	if task.TriggerRule != "" && task.TriggerRule != u.TriggerAllSuccess && pending > 0 {
+		task.PendingPreds++
+	}
+
+	switch decision := decide(*task, p.byName); {
+	case pending == 0, decision == actionRun:
+		p.queue(task)
+	case p.roots[task.Name] && task.TriggerRule != "" && task.TriggerRule != u.TriggerAllSuccess && allPredsDone(*task, p.byName):
+		p.queue(task)
+	case p.roots[task.Name] && decision == actionSkip:
+		for _, pred := range task.Predecessors {
+			if pt := p.byName[pred]; pt != nil && pt.Status.Terminal() && pt.Status != u.TaskSucceeded {
+				return fmt.Errorf("task %q cannot run because upstream %q is %s, retry it instead: %w", task.Name, pred, pt.Status, ErrConflict)
+			}
+		}
+	case decision == actionSkip:
+		task.Status = u.TaskSkipped
+	}
+	return nil
+}
+
+// retryFailedChildren reruns only the failed children of an expanded mapped
+// task, keeping the outputs of the children that already succeeded.
+func (p *rerunPlan) retryFailedChildren(task *u.Task) bool {
+	var failed []*u.Task
+	for _, child := range p.children[task.TaskId] {
+		if child.Status != u.TaskSucceeded {
+			failed = append(failed, child)
+		}
+	}
+	if len(failed) == 0 {
+		return false
+	}
+	task.Status = u.TaskRunning
+	for _, child := range failed {
+		child.Status = u.TaskPending
+		child.Attempt = 0
+		child.PendingPreds = 0
+		p.order = append(p.order, child)
+		p.queue(child)
+	}
+	return true
+}
+
+func (p *rerunPlan) queue(task *u.Task) {
+	task.Status = u.TaskQueued
+	p.queued = append(p.queued, task)
+}
+
+func allPredsDone(task u.Task, byName map[string]*u.Task) bool {
+	for _, pred := range task.Predecessors {
+		if pt := byName[pred]; pt == nil || !pt.Status.Terminal() {
+			return false
+		}
+	}
+	return true
+}
+
+func (p *rerunPlan) apply(workflowId uuid.UUID) (*RerunResult, error) {
+	result := &RerunResult{Queued: []string{}, Reset: []string{}}
+	rerun := repository.Rerun{WorkflowId: workflowId, DropMaps: p.dropMaps}
+	for _, task := range p.order {
+		rerun.Tasks = append(rerun.Tasks, *task)
+		result.Reset = append(result.Reset, task.Name)
+	}
+	for _, task := range p.queued {
+		rerun.Events = append(rerun.Events, u.OutboxEvent{
+			EventID:         uuid.New(),
+			WorkflowId:      workflowId,
+			TaskID:          task.TaskId,
+			Type:            u.OutboxTaskRetryReady,
+			CreatedAt:       time.Now(),
+			PublishAttempts: repository.PublishAttempts,
+		})
+		result.Queued = append(result.Queued, task.Name)
+	}
+	if err := repository.ApplyRerun(rerun); err != nil {
+		return nil, err
+	}
+	fmt.Printf("[Scheduler] Rerun of workflow %s reset %d tasks and queued %d\n", workflowId, len(result.Reset), len(result.Queued))
+	return result, nil
+}
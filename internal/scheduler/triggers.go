@@ -0,0 +1,190 @@
+package scheduler
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Sayan-995/dwop/internal/mapper"
+	"github.com/Sayan-995/dwop/internal/repository"
+	u "github.com/Sayan-995/dwop/internal/utils"
+	"github.com/google/uuid"
+)
+
+// TaskSucceeded runs after complete_run_and_enqueue_successors has recorded a
+// successful run. That RPC already releases all_success successors and
+// finishes the workflow if it can; this resolves the successors waiting on
+// other trigger rules.
+func TaskSucceeded(taskId uuid.UUID) error {
+	task, err := repository.GetTaskByID(taskId)
+	if err != nil || task == nil {
+		return err
+	}
+	if task.ParentTaskId != nil {
+		parent, err := mapper.CompleteChild(*task)
+		if err != nil || parent == nil {
+			return err
+		}
+		task = parent
+	}
+	return resolveSuccessors(*task)
+}
+
+// TaskFailed runs after increase_attempt. Nothing happens while the task still
+// has attempts left; once it has failed for good its successors are resolved.
+func TaskFailed(taskId uuid.UUID) error {
+	task, err := repository.GetTaskByID(taskId)
+	if err != nil || task == nil {
+		return err
+	}
+	if !failedForGood(*task) {
+		return nil
+	}
+	if task.ParentTaskId != nil {
+		parent, err := mapper.FailChild(*task)
+		if err != nil || parent == nil {
+			return err
+		}
+		task = parent
+	}
+	return resolveSuccessors(*task)
+}
+
+// Resolve evaluates the successors of every finished task in a workflow. It is
+// used when a workflow is inserted with some tasks already finished, whose
+// completions never went through the observer, so it also finalizes the
+// workflow in case no task is left to run.
+func Resolve(workflowId uuid.UUID) error {
+	tasks, err := repository.GetTasksByWorkflow(workflowId)
+	if err != nil {
+		return err
+	}
+	for _, task := range tasks {
+		if task.ParentTaskId != nil || !task.Status.Terminal() {
+			continue
+		}
+		if err := resolveSuccessors(task); err != nil {
+			return err
+		}
+	}
+	return FinalizeWorkflow(workflowId)
+}
+
+func failedForGood(task u.Task) bool {
+	return task.Status == u.TaskFailed || (task.Status != u.TaskSucceeded && task.Attempt >= task.MaxAttempts)
+}
+
+func resolveSuccessors(task u.Task) error {
+	if len(task.Successors) == 0 {
+		return nil
+	}
+	tasks, err := repository.GetTasksByWorkflow(task.WorkflowId)
+	if err != nil {
+		return err
+	}
+	byName := make(map[string]*u.Task, len(tasks))
+	for i := range tasks {
+		byName[tasks[i].Name] = &tasks[i]
+	}
+	// the row read back may predate this transition
+	if t, ok := byName[task.Name]; ok {
+		t.Status = task.Status
+		if failedForGood(task) {
+			t.Status = u.TaskFailed
+		}
+	}
+
+	queue := []string{task.Name}
+	for len(queue) > 0 {
+		current := byName[queue[0]]
+		queue = queue[1:]
+		if current == nil {
+			continue
+		}
+		for _, name := range current.Successors {
+			succ := byName[name]
+			if succ == nil || succ.Status != u.TaskPending {
+				continue
+			}
+			switch decide(*succ, byName) {
+			case actionRun:
+				if err := enqueue(*succ); err != nil {
+					return err
+				}
+				succ.Status = u.TaskQueued
+			case actionSkip:
+				won, err := repository.TransitionTaskStatus(succ.TaskId, u.TaskPending, u.TaskSkipped)
+				if err != nil {
+					return err
+				}
+				succ.Status = u.TaskSkipped
+				if won {
+					fmt.Printf("[Scheduler] Task %s skipped by trigger rule %s\n", succ.Name, succ.TriggerRule)
+					queue = append(queue, succ.Name)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+type action int
+
+const (
+	actionWait action = iota
+	actionRun
+	actionSkip
+)
+
+func decide(task u.Task, byName map[string]*u.Task) action {
+	var done, failed, succeeded int
+	for _, pred := range task.Predecessors {
+		p := byName[pred]
+		if p == nil || !p.Status.Terminal() {
+			continue
+		}
+		done++
+		switch p.Status {
+		case u.TaskSucceeded:
+			succeeded++
+		case u.TaskFailed:
+			failed++
+		}
+	}
+	allDone := done == len(task.Predecessors)
+
+	switch task.TriggerRule {
+	case u.TriggerAllDone:
+		if allDone {
+			return actionRun
+		}
+	case u.TriggerOneFailed:
+		if failed > 0 {
+			return actionRun
+		}
+		if allDone {
+			return actionSkip
+		}
+	default:
+		// successes are released by the completion RPC
+		if done > succeeded {
+			return actionSkip
+		}
+	}
+	return actionWait
+}
+
+func enqueue(task u.Task) error {
+	queued, err := repository.QueueTask(u.OutboxEvent{
+		EventID:         uuid.New(),
+		WorkflowId:      task.WorkflowId,
+		TaskID:          task.TaskId,
+		Type:            u.OutboxTaskReady,
+		CreatedAt:       time.Now(),
+		PublishAttempts: repository.PublishAttempts,
+	})
+	if err != nil || !queued {
+		return err
+	}
+	fmt.Printf("[Scheduler] Task %s released by trigger rule %s\n", task.Name, task.TriggerRule)
+	return nil
+}
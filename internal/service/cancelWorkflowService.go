@@ -1,9 +1,11 @@
 package service
 
 import (
-	"github.com/Sayan-995/dwop/internal/observer"
+	"fmt"
+
+	"github.com/Sayan-995/dwop/internal/executor"
 	"github.com/Sayan-995/dwop/internal/repository"
-	"github.com/Sayan-995/dwop/internal/utils"
+	"github.com/Sayan-995/dwop/internal/scheduler"
 )
 
 func CancelWorkflow(workflowId string) error {
@@ -11,5 +13,12 @@ func CancelWorkflow(workflowId string) error {
 	if err != nil {
 		return err
 	}
-	return observer.ForceStopJobs(utils.Conf.K8s, utils.Conf.Namespace, workflowId)
+	if err := executor.Default.StopWorkflow(workflowId); err != nil {
+		return err
+	}
+	// The canceled workflow's runs no longer take slots.
+	if err := scheduler.ReleaseDeferred(); err != nil {
+		fmt.Printf("[CancelWorkflow] ERROR releasing deferred events: %v\n", err)
+	}
+	return nil
 }
@@ -0,0 +1,168 @@
+package service
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+
+	p "github.com/Sayan-995/dwop/internal/parser"
+	"github.com/pmezard/go-difflib/difflib"
+)
+
+// DefinitionDiff compares two versions of a definition, both by what they
+// declare and line by line.
+type DefinitionDiff struct {
+	Name string `json:"name"`
+	From int    `json:"from"`
+	To   int    `json:"to"`
+
+	Tasks  Changes `json:"tasks"`
+	Params Changes `json:"params"`
+	// Settings names the header settings that changed: priority and
+	// max_running.
+	Settings            []string `json:"settings"`
+	RequirementsChanged bool     `json:"requirements_changed"`
+
+	// Definition and Requirements are unified diffs of the sources.
+	Definition   string `json:"definition"`
+	Requirements string `json:"requirements"`
+}
+
+// Changes lists what was added, removed and changed by name.
+type Changes struct {
+	Added   []string `json:"added"`
+	Removed []string `json:"removed"`
+	Changed []Change `json:"changed"`
+}
+
+// Change names the fields of one task or param that differ between versions.
+type Change struct {
+	Name   string   `json:"name"`
+	Fields []string `json:"fields"`
+}
+
+// DiffDefinitions compares version from of a definition with version to.
+func DiffDefinitions(name string, from, to int) (*DefinitionDiff, error) {
+	before, err := GetDefinitionVersion(name, from)
+	if err != nil {
+		return nil, err
+	}
+	after, err := GetDefinitionVersion(name, to)
+	if err != nil {
+		return nil, err
+	}
+	oldSpec, err := parseWorkflowFile(strings.NewReader(before.Definition))
+	if err != nil {
+		return nil, err
+	}
+	newSpec, err := parseWorkflowFile(strings.NewReader(after.Definition))
+	if err != nil {
+		return nil, err
+	}
+
+	diff := &DefinitionDiff{
+		Name:                name,
+		From:                before.Version,
+		To:                  after.Version,
+		Tasks:               diffByName(oldSpec.Tasks, newSpec.Tasks, func(t p.TaskSpec) string { return t.Name }, taskFields),
+		Params:              diffByName(oldSpec.Params, newSpec.Params, func(param p.Param) string { return param.Name }, paramFields),
+		Settings:            []string{},
+		RequirementsChanged: before.Requirements != after.Requirements,
+	}
+	if oldSpec.Priority != newSpec.Priority {
+		diff.Settings = append(diff.Settings, "priority")
+	}
+	if oldSpec.MaxRunning != newSpec.MaxRunning {
+		diff.Settings = append(diff.Settings, "max_running")
+	}
+	label := func(version int) string { return fmt.Sprintf("%s@v%d", name, version) }
+	if diff.Definition, err = unifiedDiff(before.Definition, after.Definition, label(before.Version), label(after.Version)); err != nil {
+		return nil, err
+	}
+	if diff.Requirements, err = unifiedDiff(before.Requirements, after.Requirements, label(before.Version), label(after.Version)); err != nil {
+		return nil, err
+	}
+	return diff, nil
+}
+
+// diffByName matches items by name, keeping the order of the newer version
+// for added and changed items and of the older one for removed items.
+func diffByName[T any](before, after []T, name func(T) string, fields func(T, T) []string) Changes {
+	changes := Changes{Added: []string{}, Removed: []string{}, Changed: []Change{}}
+	old := make(map[string]T, len(before))
+	for _, item := range before {
+		old[name(item)] = item
+	}
+	seen := make(map[string]bool, len(after))
+	for _, item := range after {
+		seen[name(item)] = true
+		prev, ok := old[name(item)]
+		if !ok {
+			changes.Added = append(changes.Added, name(item))
+			continue
+		}
+		if changed := fields(prev, item); len(changed) > 0 {
+			changes.Changed = append(changes.Changed, Change{Name: name(item), Fields: changed})
+		}
+	}
+	for _, item := range before {
+		if !seen[name(item)] {
+			changes.Removed = append(changes.Removed, name(item))
+		}
+	}
+	return changes
+}
+
+// taskFields names what differs between two versions of a task, using the
+// decorator names for settings.
+func taskFields(old, task p.TaskSpec) []string {
+	var fields []string
+	if p.HashCode(old.Code) != p.HashCode(task.Code) {
+		fields = append(fields, "code")
+	}
+	// columns move with unrelated edits, so only names are compared
+	sameArg := func(a, b p.Arg) bool { return a.Name == b.Name && a.Task == b.Task }
+	if !slices.EqualFunc(old.Args, task.Args, sameArg) {
+		fields = append(fields, "args")
+	}
+	for _, f := range []struct {
+		name string
+		same bool
+	}{
+		{"retries", old.MaxAttempts == task.MaxAttempts},
+		{"timeout", old.Timeout == task.Timeout},
+		{"image", old.Image == task.Image},
+		{"resources", old.Resources == task.Resources},
+		{"map", old.MapOver == task.MapOver},
+		{"trigger_rule", old.TriggerRule == task.TriggerRule},
+		{"cache", old.Cache == task.Cache},
+		{"pool", old.Pool == task.Pool},
+	} {
+		if !f.same {
+			fields = append(fields, f.name)
+		}
+	}
+	return fields
+}
+
+func paramFields(old, param p.Param) []string {
+	var fields []string
+	if old.Required != param.Required {
+		fields = append(fields, "required")
+	}
+	if !reflect.DeepEqual(old.Default, param.Default) {
+		fields = append(fields, "default")
+	}
+	return fields
+}
+
+func unifiedDiff(before, after, fromFile, toFile string) (string, error) {
+	return difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(before),
+		B:        difflib.SplitLines(after),
+		FromFile: fromFile,
+		ToFile:   toFile,
+		Context:  3,
+	})
+}
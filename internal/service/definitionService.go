@@ -0,0 +1,137 @@
+package service
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+
+	p "github.com/Sayan-995/dwop/internal/parser"
+	repo "github.com/Sayan-995/dwop/internal/repository"
+	u "github.com/Sayan-995/dwop/internal/utils"
+	"github.com/google/uuid"
+)
+
+var (
+	definitionNameRe = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+)
+
+// CreateDefinition validates a workflow file and stores it with its
+// requirements as the next version of name. It reports false, and returns
+// the latest version, when that already has the same content.
+func CreateDefinition(name string, file io.Reader, requirements io.Reader) (*u.WorkflowDefinition, bool, error) {
+	if !definitionNameRe.MatchString(name) {
+		return nil, false, fmt.Errorf("definition name must be letters, digits, '_', '.' or '-', got %q: %w", name, ErrInvalid)
+	}
+	source, err := io.ReadAll(file)
+	if err != nil {
+		return nil, false, fmt.Errorf("error while reading contents from file: %v", err)
+	}
+	reqs, err := io.ReadAll(requirements)
+	if err != nil {
+		return nil, false, fmt.Errorf("error while reading requirements: %v", err)
+	}
+	if _, err := parseWorkflowFile(bytes.NewReader(source)); err != nil {
+		return nil, false, err
+	}
+
+	definition := u.WorkflowDefinition{
+		DefinitionId: uuid.New(),
+		Name:         name,
+		Definition:   string(source),
+		Requirements: string(reqs),
+		Hash:         p.HashCode(string(source) + "\x00" + string(reqs)),
+		CreatedAt:    time.Now(),
+	}
+	stored, err := repo.CreateDefinitionVersion(definition)
+	if err != nil {
+		return nil, false, err
+	}
+	created := stored.DefinitionId == definition.DefinitionId
+	if created {
+		fmt.Printf("[Definition] Stored version %d of %s\n", stored.Version, name)
+	}
+	return stored, created, nil
+}
+
+// ListDefinitions lists the latest version of every definition.
+func ListDefinitions(opts repo.ListOptions) (*Page, error) {
+	rows, total, err := repo.ListDefinitions(opts)
+	if err != nil {
+		return nil, err
+	}
+	if rows == nil {
+		rows = []u.WorkflowDefinition{}
+	}
+	return &Page{Items: rows, Total: total, Limit: opts.Limit, Offset: opts.Offset}, nil
+}
+
+// ListDefinitionVersions lists the versions of a definition, newest first.
+func ListDefinitionVersions(name string, opts repo.ListOptions) (*Page, error) {
+	rows, total, err := repo.ListDefinitionVersions(name, opts)
+	if err != nil {
+		return nil, err
+	}
+	if total == 0 {
+		return nil, fmt.Errorf("definition %s: %w", name, ErrNotFound)
+	}
+	return &Page{Items: rows, Total: total, Limit: opts.Limit, Offset: opts.Offset}, nil
+}
+
+// GetDefinitionVersion returns the latest version when version is 0.
+func GetDefinitionVersion(name string, version int) (*u.WorkflowDefinition, error) {
+	definition, err := repo.GetDefinitionVersion(name, version)
+	if err != nil {
+		return nil, err
+	}
+	if definition == nil {
+		if version == 0 {
+			return nil, fmt.Errorf("definition %s: %w", name, ErrNotFound)
+		}
+		return nil, fmt.Errorf("version %d of definition %s: %w", version, name, ErrNotFound)
+	}
+	return definition, nil
+}
+
+// ListDefinitionRuns lists the runs of one version of a definition, newest
+// first.
+func ListDefinitionRuns(name string, version int, opts repo.ListOptions) (*Page, error) {
+	definition, err := GetDefinitionVersion(name, version)
+	if err != nil {
+		return nil, err
+	}
+	rows, total, err := repo.ListDefinitionRuns(definition.DefinitionId, opts)
+	if err != nil {
+		return nil, err
+	}
+	if rows == nil {
+		rows = []u.Workflow{}
+	}
+	return &Page{Items: rows, Total: total, Limit: opts.Limit, Offset: opts.Offset}, nil
+}
+
+// TriggerDefinition starts a run of a version of a definition, the latest
+// when version is 0. A non-nil priority overrides the one declared in it.
+func TriggerDefinition(name string, version int, params map[string]any, priority *int) (*u.Workflow, error) {
+	definition, err := GetDefinitionVersion(name, version)
+	if err != nil {
+		return nil, err
+	}
+	spec, err := parseWorkflowFile(strings.NewReader(definition.Definition))
+	if err != nil {
+		return nil, err
+	}
+	workflow, tasks, err := newWorkflow(spec, strings.NewReader(definition.Requirements), params, priority)
+	if err != nil {
+		return nil, err
+	}
+	workflow.DefinitionId = &definition.DefinitionId
+	workflow.DefinitionVersion = &definition.Version
+	if err := repo.InsertWorkflow(*workflow, tasks); err != nil {
+		return nil, err
+	}
+	fmt.Printf("[Definition] Started workflow %s of %s version %d\n", workflow.WorkflowId, name, definition.Version)
+	return workflow, nil
+}
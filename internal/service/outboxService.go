@@ -0,0 +1,67 @@
+package service
+
+import (
+	"fmt"
+	"time"
+
+	repo "github.com/Sayan-995/dwop/internal/repository"
+	u "github.com/Sayan-995/dwop/internal/utils"
+	"github.com/google/uuid"
+)
+
+// ListStuckOutboxEvents lists events that were claimed more than olderThan
+// ago and still are not published, including those whose lease ran out and
+// that no claimer has released yet.
+func ListStuckOutboxEvents(olderThan time.Duration, opts repo.ListOptions) (*Page, error) {
+	rows, total, err := repo.ListStuckOutboxEvents(time.Now().Add(-olderThan), opts)
+	if err != nil {
+		return nil, err
+	}
+	if rows == nil {
+		rows = []u.OutboxEvent{}
+	}
+	return &Page{Items: rows, Total: total, Limit: opts.Limit, Offset: opts.Offset}, nil
+}
+
+func ListDeadLetterOutboxEvents(opts repo.ListOptions) (*Page, error) {
+	rows, total, err := repo.ListDeadLetterOutboxEvents(opts)
+	if err != nil {
+		return nil, err
+	}
+	if rows == nil {
+		rows = []u.OutboxEvent{}
+	}
+	return &Page{Items: rows, Total: total, Limit: opts.Limit, Offset: opts.Offset}, nil
+}
+
+func GetOutboxEvent(eventId uuid.UUID) (*u.OutboxEvent, error) {
+	event, err := repo.GetOutboxEvent(eventId)
+	if err != nil {
+		return nil, err
+	}
+	if event == nil {
+		return nil, fmt.Errorf("outbox event %s: %w", eventId, ErrNotFound)
+	}
+	return event, nil
+}
+
+// ReplayOutboxEvent puts a dead-lettered event back in the outbox with a fresh
+// set of publish attempts.
+func ReplayOutboxEvent(eventId uuid.UUID) (*u.OutboxEvent, error) {
+	event, err := GetOutboxEvent(eventId)
+	if err != nil {
+		return nil, err
+	}
+	if event.DeadLetteredAt == nil {
+		return nil, fmt.Errorf("outbox event %s is not dead-lettered: %w", eventId, ErrConflict)
+	}
+	ok, err := repo.ReplayOutboxEvent(eventId)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("outbox event %s was replayed concurrently: %w", eventId, ErrConflict)
+	}
+	fmt.Printf("[Outbox] Replaying dead-lettered event %s of workflow %s\n", eventId, event.WorkflowId)
+	return GetOutboxEvent(eventId)
+}
@@ -0,0 +1,112 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/Sayan-995/dwop/internal/executor"
+	repo "github.com/Sayan-995/dwop/internal/repository"
+	u "github.com/Sayan-995/dwop/internal/utils"
+	"github.com/google/uuid"
+)
+
+var (
+	ErrNotFound = errors.New("not found")
+)
+
+type Page struct {
+	Items  any   `json:"items"`
+	Total  int64 `json:"total"`
+	Limit  int   `json:"limit"`
+	Offset int   `json:"offset"`
+}
+
+type WorkflowDetail struct {
+	u.Workflow
+	Tasks []u.Task `json:"tasks"`
+}
+
+type TaskRunsPage struct {
+	Task        string       `json:"task"`
+	Status      u.TaskStatus `json:"status"`
+	Attempt     int          `json:"attempt"`
+	MaxAttempts int          `json:"max_attempts"`
+	Page
+}
+
+func ListWorkflows(opts repo.ListOptions) (*Page, error) {
+	rows, total, err := repo.ListWorkflows(opts)
+	if err != nil {
+		return nil, err
+	}
+	if rows == nil {
+		rows = []u.Workflow{}
+	}
+	return &Page{Items: rows, Total: total, Limit: opts.Limit, Offset: opts.Offset}, nil
+}
+
+func GetWorkflow(workflowId uuid.UUID) (*WorkflowDetail, error) {
+	workflow, err := repo.GetWorkflowByID(workflowId)
+	if err != nil {
+		return nil, err
+	}
+	if workflow == nil {
+		return nil, fmt.Errorf("workflow %s: %w", workflowId, ErrNotFound)
+	}
+	tasks, err := repo.GetTasksByWorkflow(workflowId)
+	if err != nil {
+		return nil, err
+	}
+	if tasks == nil {
+		tasks = []u.Task{}
+	}
+	return &WorkflowDetail{Workflow: *workflow, Tasks: tasks}, nil
+}
+
+func ListTaskRuns(workflowId uuid.UUID, taskName string, opts repo.ListOptions) (*TaskRunsPage, error) {
+	task, err := repo.GetTaskByName(workflowId, taskName)
+	if err != nil {
+		return nil, err
+	}
+	if task == nil {
+		return nil, fmt.Errorf("task %s in workflow %s: %w", taskName, workflowId, ErrNotFound)
+	}
+	rows, total, err := repo.ListTaskRuns(task.TaskId, opts)
+	if err != nil {
+		return nil, err
+	}
+	if rows == nil {
+		rows = []u.TaskRun{}
+	}
+	return &TaskRunsPage{
+		Task:        task.Name,
+		Status:      task.Status,
+		Attempt:     task.Attempt,
+		MaxAttempts: task.MaxAttempts,
+		Page:        Page{Items: rows, Total: total, Limit: opts.Limit, Offset: opts.Offset},
+	}, nil
+}
+
+func GetTaskRun(workflowId, runId uuid.UUID) (*u.TaskRun, error) {
+	run, err := repo.GetTaskRun(runId)
+	if err != nil {
+		return nil, err
+	}
+	if run == nil || run.WorkflowId != workflowId {
+		return nil, fmt.Errorf("run %s in workflow %s: %w", runId, workflowId, ErrNotFound)
+	}
+	return run, nil
+}
+
+// GetTaskRunLogs returns the tail of the run's output from the executor. Logs
+// are only available while the executor still holds the run.
+func GetTaskRunLogs(workflowId, runId uuid.UUID) (string, error) {
+	if _, err := GetTaskRun(workflowId, runId); err != nil {
+		return "", err
+	}
+	logs, err := executor.Default.Logs(runId)
+	if errors.Is(err, executor.ErrRunNotFound) {
+		return "", fmt.Errorf("logs of run %s: %w", runId, ErrNotFound)
+	}
+	return logs, err
+}
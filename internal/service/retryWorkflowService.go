@@ -0,0 +1,49 @@
+package service
+
+import (
+	"fmt"
+
+	repo "github.com/Sayan-995/dwop/internal/repository"
+	"github.com/Sayan-995/dwop/internal/scheduler"
+	u "github.com/Sayan-995/dwop/internal/utils"
+	"github.com/google/uuid"
+)
+
+var (
+	ErrConflict = scheduler.ErrConflict
+)
+
+func RetryTask(workflowId uuid.UUID, taskName string, cascade bool) (*scheduler.RerunResult, error) {
+	if err := checkRerunnable(workflowId); err != nil {
+		return nil, err
+	}
+	task, err := repo.GetTaskByName(workflowId, taskName)
+	if err != nil {
+		return nil, err
+	}
+	if task == nil {
+		return nil, fmt.Errorf("task %s in workflow %s: %w", taskName, workflowId, ErrNotFound)
+	}
+	return scheduler.RetryTask(workflowId, taskName, cascade)
+}
+
+func ResumeWorkflow(workflowId uuid.UUID) (*scheduler.RerunResult, error) {
+	if err := checkRerunnable(workflowId); err != nil {
+		return nil, err
+	}
+	return scheduler.Resume(workflowId)
+}
+
+func checkRerunnable(workflowId uuid.UUID) error {
+	workflow, err := repo.GetWorkflowByID(workflowId)
+	if err != nil {
+		return err
+	}
+	if workflow == nil {
+		return fmt.Errorf("workflow %s: %w", workflowId, ErrNotFound)
+	}
+	if workflow.Status == u.RunCanceled {
+		return fmt.Errorf("workflow %s was canceled: %w", workflowId, ErrConflict)
+	}
+	return nil
+}
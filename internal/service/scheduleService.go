@@ -0,0 +1,362 @@
+package service
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	p "github.com/Sayan-995/dwop/internal/parser"
+	repo "github.com/Sayan-995/dwop/internal/repository"
+	u "github.com/Sayan-995/dwop/internal/utils"
+	"github.com/google/uuid"
+	"github.com/robfig/cron/v3"
+)
+
+const (
+	// ScheduledForParam is filled with the tick a scheduled run is for, in
+	// the schedule's timezone, when the definition declares it.
+	ScheduledForParam = "scheduled_for"
+	// MaxBackfillRuns bounds the runs created by one backfill request, and
+	// by one pass over a schedule catching up on every missed tick.
+	MaxBackfillRuns = 100
+	// misfireGrace is how late a tick may be picked up by a schedule that
+	// does not catch up.
+	misfireGrace = time.Minute
+)
+
+var (
+	ErrInvalid = errors.New("invalid request")
+)
+
+// ScheduleSpec holds the settings of a new schedule. Timezone defaults to
+// UTC and CatchUp to u.CatchUpLatest.
+type ScheduleSpec struct {
+	Name     string
+	Cron     string
+	Timezone string
+	CatchUp  u.CatchUpPolicy
+	Params   map[string]any
+	Priority *int
+}
+
+// CreateSchedule validates and stores a schedule. Its first run is created at
+// the first tick after now.
+func CreateSchedule(spec ScheduleSpec, file io.Reader, requirements io.Reader) (*u.Schedule, error) {
+	if spec.Timezone == "" {
+		spec.Timezone = "UTC"
+	}
+	if spec.CatchUp == "" {
+		spec.CatchUp = u.CatchUpLatest
+	}
+	switch spec.CatchUp {
+	case u.CatchUpLatest, u.CatchUpAll, u.CatchUpNone:
+	default:
+		return nil, fmt.Errorf("catch_up must be latest, all or none, got %q: %w", spec.CatchUp, ErrInvalid)
+	}
+	sched, loc, err := parseCron(spec.Cron, spec.Timezone)
+	if err != nil {
+		return nil, err
+	}
+
+	definition, err := io.ReadAll(file)
+	if err != nil {
+		return nil, fmt.Errorf("error while reading contents from file: %v", err)
+	}
+	reqs, err := io.ReadAll(requirements)
+	if err != nil {
+		return nil, fmt.Errorf("error while reading requirements: %v", err)
+	}
+	parsed, err := parseWorkflowFile(bytes.NewReader(definition))
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	if _, err := parsed.ResolveParams(tickParams(parsed.Params, spec.Params, now.In(loc))); err != nil {
+		return nil, fmt.Errorf("Error while resolving params: %w", err)
+	}
+
+	schedule := u.Schedule{
+		ScheduleId:   uuid.New(),
+		Name:         spec.Name,
+		Cron:         spec.Cron,
+		Timezone:     spec.Timezone,
+		CatchUp:      spec.CatchUp,
+		Definition:   string(definition),
+		Requirements: string(reqs),
+		Params:       spec.Params,
+		Priority:     spec.Priority,
+		Status:       u.ScheduleActive,
+		NextRunAt:    sched.Next(now.In(loc)).UTC(),
+		CreatedAt:    now,
+		UpdatedAt:    now,
+	}
+	if err := repo.InsertSchedule(schedule); err != nil {
+		return nil, err
+	}
+	return &schedule, nil
+}
+
+func ListSchedules(opts repo.ListOptions) (*Page, error) {
+	rows, total, err := repo.ListSchedules(opts)
+	if err != nil {
+		return nil, err
+	}
+	if rows == nil {
+		rows = []u.Schedule{}
+	}
+	return &Page{Items: rows, Total: total, Limit: opts.Limit, Offset: opts.Offset}, nil
+}
+
+func GetSchedule(scheduleId uuid.UUID) (*u.Schedule, error) {
+	schedule, err := repo.GetSchedule(scheduleId)
+	if err != nil {
+		return nil, err
+	}
+	if schedule == nil {
+		return nil, fmt.Errorf("schedule %s: %w", scheduleId, ErrNotFound)
+	}
+	return schedule, nil
+}
+
+// ListScheduleRuns lists the workflows a schedule created, newest first.
+func ListScheduleRuns(scheduleId uuid.UUID, opts repo.ListOptions) (*Page, error) {
+	if _, err := GetSchedule(scheduleId); err != nil {
+		return nil, err
+	}
+	rows, total, err := repo.ListScheduleRuns(scheduleId, opts)
+	if err != nil {
+		return nil, err
+	}
+	if rows == nil {
+		rows = []u.Workflow{}
+	}
+	return &Page{Items: rows, Total: total, Limit: opts.Limit, Offset: opts.Offset}, nil
+}
+
+// PauseSchedule stops a schedule from creating runs. Runs already created
+// keep going.
+func PauseSchedule(scheduleId uuid.UUID) (*u.Schedule, error) {
+	schedule, err := GetSchedule(scheduleId)
+	if err != nil {
+		return nil, err
+	}
+	if schedule.Status == u.SchedulePaused {
+		return schedule, nil
+	}
+	return setScheduleStatus(*schedule, u.SchedulePaused, schedule.NextRunAt)
+}
+
+// ResumeSchedule reactivates a paused schedule from the first tick after
+// now. Ticks that passed while it was paused are not caught up; use
+// BackfillSchedule for those.
+func ResumeSchedule(scheduleId uuid.UUID) (*u.Schedule, error) {
+	schedule, err := GetSchedule(scheduleId)
+	if err != nil {
+		return nil, err
+	}
+	if schedule.Status == u.ScheduleActive {
+		return schedule, nil
+	}
+	sched, loc, err := parseCron(schedule.Cron, schedule.Timezone)
+	if err != nil {
+		return nil, err
+	}
+	return setScheduleStatus(*schedule, u.ScheduleActive, sched.Next(time.Now().In(loc)).UTC())
+}
+
+func setScheduleStatus(schedule u.Schedule, status u.ScheduleStatus, nextRunAt time.Time) (*u.Schedule, error) {
+	found, err := repo.SetScheduleStatus(schedule.ScheduleId, status, nextRunAt)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, fmt.Errorf("schedule %s: %w", schedule.ScheduleId, ErrNotFound)
+	}
+	fmt.Printf("[Schedule] Schedule %s is %s\n", schedule.ScheduleId, status)
+	schedule.Status = status
+	schedule.NextRunAt = nextRunAt
+	schedule.UpdatedAt = time.Now()
+	return &schedule, nil
+}
+
+// BackfillSchedule creates a run for every tick in [from, to] that has none
+// yet, oldest first, whether the schedule is paused or not.
+func BackfillSchedule(scheduleId uuid.UUID, from, to time.Time) ([]u.Workflow, error) {
+	if to.Before(from) {
+		return nil, fmt.Errorf("backfill range ends before it starts: %w", ErrInvalid)
+	}
+	if to.After(time.Now()) {
+		return nil, fmt.Errorf("backfill range must end in the past: %w", ErrInvalid)
+	}
+	schedule, err := GetSchedule(scheduleId)
+	if err != nil {
+		return nil, err
+	}
+	sched, loc, err := parseCron(schedule.Cron, schedule.Timezone)
+	if err != nil {
+		return nil, err
+	}
+	var ticks []time.Time
+	for t := sched.Next(from.Add(-time.Second).In(loc)); !t.After(to); t = sched.Next(t) {
+		if len(ticks) == MaxBackfillRuns {
+			return nil, fmt.Errorf("backfill range has more than %d ticks: %w", MaxBackfillRuns, ErrInvalid)
+		}
+		ticks = append(ticks, t)
+	}
+	ticks, err = withoutRuns(scheduleId, ticks)
+	if err != nil {
+		return nil, err
+	}
+
+	runs := []u.Workflow{}
+	for _, tick := range ticks {
+		workflow, err := startScheduledRun(*schedule, tick)
+		if err != nil {
+			return runs, fmt.Errorf("error while starting the run for %s: %w", tick.Format(time.RFC3339), err)
+		}
+		runs = append(runs, *workflow)
+	}
+	fmt.Printf("[Schedule] Backfilled %d runs of schedule %s\n", len(runs), scheduleId)
+	return runs, nil
+}
+
+// RunDueSchedules starts the runs of every active schedule whose next tick
+// has passed. Each tick is claimed by moving next_run_at before its runs are
+// created, so orchestrators sharing a database do not start it twice.
+func RunDueSchedules(now time.Time) {
+	schedules, err := repo.DueSchedules(now)
+	if err != nil {
+		fmt.Printf("[Schedule] ERROR listing due schedules: %v\n", err)
+		return
+	}
+	for _, schedule := range schedules {
+		if err := runDueSchedule(schedule, now); err != nil {
+			fmt.Printf("[Schedule] ERROR running schedule %s: %v\n", schedule.ScheduleId, err)
+		}
+	}
+}
+
+func runDueSchedule(schedule u.Schedule, now time.Time) error {
+	sched, loc, err := parseCron(schedule.Cron, schedule.Timezone)
+	if err != nil {
+		return err
+	}
+	ticks, next := dueTicks(sched, schedule.NextRunAt.In(loc), now, schedule.CatchUp)
+	claimed, err := repo.MoveScheduleNextRun(schedule.ScheduleId, schedule.NextRunAt, next.UTC())
+	if err != nil || !claimed {
+		return err
+	}
+	if skipped := countTicks(sched, schedule.NextRunAt.In(loc), next) - len(ticks); skipped > 0 {
+		fmt.Printf("[Schedule] Schedule %s skipped %d missed ticks (catch_up=%s)\n", schedule.ScheduleId, skipped, schedule.CatchUp)
+	}
+	// A backfill may have got to a tick first.
+	if ticks, err = withoutRuns(schedule.ScheduleId, ticks); err != nil {
+		return err
+	}
+	for _, tick := range ticks {
+		if _, err := startScheduledRun(schedule, tick); err != nil {
+			// Hand the tick back so the next pass retries it.
+			if _, moveErr := repo.MoveScheduleNextRun(schedule.ScheduleId, next.UTC(), tick.UTC()); moveErr != nil {
+				fmt.Printf("[Schedule] ERROR rescheduling tick %s of schedule %s: %v\n", tick, schedule.ScheduleId, moveErr)
+			}
+			return fmt.Errorf("error while starting the run for %s: %w", tick.Format(time.RFC3339), err)
+		}
+	}
+	return nil
+}
+
+// dueTicks returns the ticks from first up to now that the catch-up policy
+// runs, and the tick to wait for next. A schedule catching up on every tick
+// takes at most MaxBackfillRuns per pass and continues on the next one.
+func dueTicks(sched cron.Schedule, first, now time.Time, policy u.CatchUpPolicy) ([]time.Time, time.Time) {
+	var missed []time.Time
+	t := first
+	for !t.After(now) {
+		if policy == u.CatchUpAll && len(missed) == MaxBackfillRuns {
+			break
+		}
+		if policy == u.CatchUpAll || len(missed) == 0 {
+			missed = append(missed, t)
+		} else {
+			missed[0] = t
+		}
+		t = sched.Next(t)
+	}
+	if policy == u.CatchUpNone && len(missed) > 0 && now.Sub(missed[0]) > misfireGrace {
+		return nil, t
+	}
+	return missed, t
+}
+
+func countTicks(sched cron.Schedule, first, next time.Time) int {
+	n := 0
+	for t := first; t.Before(next); t = sched.Next(t) {
+		n++
+	}
+	return n
+}
+
+// withoutRuns drops the ticks, in order, that already have a run.
+func withoutRuns(scheduleId uuid.UUID, ticks []time.Time) ([]time.Time, error) {
+	if len(ticks) == 0 {
+		return ticks, nil
+	}
+	existing, err := repo.ScheduledRunTimes(scheduleId, ticks[0], ticks[len(ticks)-1])
+	if err != nil {
+		return nil, err
+	}
+	return slices.DeleteFunc(ticks, func(tick time.Time) bool {
+		return slices.ContainsFunc(existing, tick.Equal)
+	}), nil
+}
+
+func startScheduledRun(schedule u.Schedule, tick time.Time) (*u.Workflow, error) {
+	spec, err := parseWorkflowFile(strings.NewReader(schedule.Definition))
+	if err != nil {
+		return nil, err
+	}
+	params := tickParams(spec.Params, schedule.Params, tick)
+	workflow, tasks, err := newWorkflow(spec, strings.NewReader(schedule.Requirements), params, schedule.Priority)
+	if err != nil {
+		return nil, err
+	}
+	scheduledFor := tick.UTC()
+	workflow.ScheduleId = &schedule.ScheduleId
+	workflow.ScheduledFor = &scheduledFor
+	if err := repo.InsertWorkflow(*workflow, tasks); err != nil {
+		return nil, err
+	}
+	fmt.Printf("[Schedule] Started workflow %s of schedule %s for %s\n", workflow.WorkflowId, schedule.ScheduleId, tick.Format(time.RFC3339))
+	return workflow, nil
+}
+
+// tickParams adds the tick to the schedule's params when the definition
+// declares ScheduledForParam and the schedule does not set it.
+func tickParams(declared []p.Param, params map[string]any, tick time.Time) map[string]any {
+	if _, ok := params[ScheduledForParam]; ok || !slices.ContainsFunc(declared, func(param p.Param) bool { return param.Name == ScheduledForParam }) {
+		return params
+	}
+	withTick := maps.Clone(params)
+	if withTick == nil {
+		withTick = map[string]any{}
+	}
+	withTick[ScheduledForParam] = tick.Format(time.RFC3339)
+	return withTick
+}
+
+func parseCron(expr, timezone string) (cron.Schedule, *time.Location, error) {
+	loc, err := time.LoadLocation(timezone)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unknown timezone %q: %w", timezone, ErrInvalid)
+	}
+	sched, err := cron.ParseStandard(expr)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid cron expression %q: %v: %w", expr, err, ErrInvalid)
+	}
+	return sched, loc, nil
+}
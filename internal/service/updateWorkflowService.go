@@ -1,15 +1,185 @@
 package service
 
 import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
 	"os"
+	"slices"
 
+	"github.com/Sayan-995/dwop/internal/mapper"
+	p "github.com/Sayan-995/dwop/internal/parser"
+	repo "github.com/Sayan-995/dwop/internal/repository"
+	"github.com/Sayan-995/dwop/internal/scheduler"
+	"github.com/Sayan-995/dwop/internal/storage"
 	"github.com/Sayan-995/dwop/internal/utils"
+	"github.com/google/uuid"
 )
 
-func UpdateWorkflow(workflowId string, file *os.File, requirements *os.File) (*utils.Workflow, error) {
-	err := CancelWorkflow(workflowId)
+// UpdateWorkflow replaces a workflow with a new definition. Tasks whose code,
+// dependencies and settings are unchanged and that already succeeded keep
+// their outputs; only changed tasks and everything downstream of them run.
+func UpdateWorkflow(workflowId string, file *os.File, requirements *os.File, params map[string]any, priority *int) (*utils.Workflow, error) {
+	spec, err := parseWorkflowFile(file)
 	if err != nil {
 		return nil, err
 	}
-	return UploadWorkflowfile(file, requirements)
+	if _, err = spec.ResolveParams(params); err != nil {
+		return nil, fmt.Errorf("Error while resolving params: %w", err)
+	}
+	id, err := uuid.Parse(workflowId)
+	if err != nil {
+		return nil, fmt.Errorf("workflow %s: %w", workflowId, ErrNotFound)
+	}
+	previous, err := repo.GetWorkflowByID(id)
+	if err != nil {
+		return nil, err
+	}
+	if previous == nil {
+		return nil, fmt.Errorf("workflow %s: %w", workflowId, ErrNotFound)
+	}
+	previousTasks, err := repo.GetTasksByWorkflow(id)
+	if err != nil {
+		return nil, err
+	}
+	reqs, err := io.ReadAll(requirements)
+	if err != nil {
+		return nil, fmt.Errorf("error while reading requirements: %v", err)
+	}
+
+	workflow, tasks, err := newWorkflow(spec, bytes.NewReader(reqs), params, priority)
+	if err != nil {
+		return nil, err
+	}
+	reused := 0
+	if sameEnvironment(*previous, *workflow, reqs) {
+		if reused, err = reuseOutputs(*previous, previousTasks, *workflow, tasks); err != nil {
+			return nil, err
+		}
+	}
+	fmt.Printf("[UpdateWorkflow] Workflow %s replaces %s, reusing %d of %d tasks\n", workflow.WorkflowId, previous.WorkflowId, reused, len(tasks))
+
+	// The replacement is stored before the old workflow is canceled, so a
+	// failed update leaves the old one running.
+	if err = repo.InsertWorkflow(*workflow, tasks); err != nil {
+		return nil, err
+	}
+	if reused > 0 {
+		if err = scheduler.Resolve(workflow.WorkflowId); err != nil {
+			// the observer's sweep resolves it again
+			fmt.Printf("[UpdateWorkflow] ERROR resolving reused tasks of %s: %v\n", workflow.WorkflowId, err)
+		}
+	}
+	if err = CancelWorkflow(workflowId); err != nil {
+		return nil, fmt.Errorf("workflow %s replaces %s, but canceling the old workflow failed: %v", workflow.WorkflowId, workflowId, err)
+	}
+	return workflow, nil
+}
+
+// sameEnvironment reports whether params and requirements are unchanged,
+// since either can change the result of every task.
+func sameEnvironment(previous, workflow utils.Workflow, requirements []byte) bool {
+	before, _ := json.Marshal(previous.Params)
+	after, _ := json.Marshal(workflow.Params)
+	if !bytes.Equal(before, after) {
+		return false
+	}
+	env, err := storage.Store.Download(storage.EnvBucket, previous.EnvLink)
+	if err != nil {
+		fmt.Printf("[UpdateWorkflow] Could not download requirements of %s: %v\n", previous.WorkflowId, err)
+		return false
+	}
+	return bytes.Equal(env, requirements)
+}
+
+// reuseOutputs marks the tasks of the new run that can keep the previous
+// run's result as succeeded and copies their outputs over. It returns the
+// number of tasks reused.
+func reuseOutputs(previous utils.Workflow, previousTasks []utils.Task, workflow utils.Workflow, tasks []utils.Task) (int, error) {
+	before := make(map[string]utils.Task, len(previousTasks))
+	for _, task := range previousTasks {
+		if task.ParentTaskId == nil {
+			before[task.Name] = task
+		}
+	}
+
+	changed := make(map[string]bool, len(tasks))
+	for _, task := range tasks {
+		old, ok := before[task.Name]
+		if !ok || old.Status != utils.TaskSucceeded || !sameTask(old, task) {
+			changed[task.Name] = true
+		}
+	}
+	// everything downstream of a change has to run again
+	for grown := true; grown; {
+		grown = false
+		for _, task := range tasks {
+			if changed[task.Name] {
+				continue
+			}
+			for _, pred := range task.Predecessors {
+				if changed[pred] {
+					changed[task.Name] = true
+					grown = true
+					break
+				}
+			}
+		}
+	}
+
+	reused := 0
+	for i := range tasks {
+		task := &tasks[i]
+		if !changed[task.Name] {
+			output, err := storage.Store.Download(storage.OutputBucket, mapper.OutputPath(previous.WorkflowId, task.Name))
+			if err != nil {
+				return 0, fmt.Errorf("error while downloading output of %s: %v", task.Name, err)
+			}
+			err = storage.Store.Upload(storage.OutputBucket, mapper.OutputPath(workflow.WorkflowId, task.Name), bytes.NewReader(output))
+			if err != nil {
+				return 0, fmt.Errorf("error while uploading output of %s: %v", task.Name, err)
+			}
+			task.Status = utils.TaskSucceeded
+			task.PendingPreds = 0
+			reused++
+			continue
+		}
+		pending := 0
+		for _, pred := range task.Predecessors {
+			if changed[pred] {
+				pending++
+			}
+		}
+		task.PendingPreds = pending
+		if task.TriggerRule != utils.TriggerAllSuccess && len(task.Predecessors) > 0 {
+			task.PendingPreds++
+		}
+	}
+	return reused, nil
+}
+
+func sameTask(old, task utils.Task) bool {
+	if old.CodeHash == "" {
+		code, err := storage.Store.Download(storage.CodeBucket, old.CodeLink)
+		if err != nil {
+			return false
+		}
+		old.CodeHash = p.HashCode(string(code))
+	}
+	if old.CodeHash != task.CodeHash || old.MapOver != task.MapOver || old.TriggerRule != task.TriggerRule {
+		return false
+	}
+	if old.Image != task.Image || old.TimeoutSeconds != task.TimeoutSeconds || old.Resources != task.Resources {
+		return false
+	}
+	if len(old.FuncArgMap) != len(task.FuncArgMap) {
+		return false
+	}
+	for pred, arg := range task.FuncArgMap {
+		if old.FuncArgMap[pred] != arg {
+			return false
+		}
+	}
+	return slices.Equal(slices.Sorted(slices.Values(old.Predecessors)), slices.Sorted(slices.Values(task.Predecessors)))
 }
@@ -9,41 +9,86 @@ import (
 
 	p "github.com/Sayan-995/dwop/internal/parser"
 	repo "github.com/Sayan-995/dwop/internal/repository"
+	"github.com/Sayan-995/dwop/internal/storage"
 	u "github.com/Sayan-995/dwop/internal/utils"
 	"github.com/google/uuid"
 )
 
-func UploadWorkflowfile(file *os.File, requirements *os.File) (*u.Workflow, error) {
+// UploadWorkflowfile stores and starts a workflow. A non-nil priority
+// overrides the one declared in the file.
+func UploadWorkflowfile(file *os.File, requirements *os.File, params map[string]any, priority *int) (*u.Workflow, error) {
+	spec, err := parseWorkflowFile(file)
+	if err != nil {
+		return nil, err
+	}
+	workflow, tasks, err := newWorkflow(spec, requirements, params, priority)
+	if err != nil {
+		return nil, err
+	}
+	err = repo.InsertWorkflow(*workflow, tasks)
+	return workflow, err
+}
 
+func parseWorkflowFile(file io.Reader) (*p.Workflow, error) {
 	byteContent, err := io.ReadAll(file)
 
 	if err != nil {
 		return nil, fmt.Errorf("error while reading contents from file: %v", err)
 	}
 
+	content := strings.Split(string(byteContent), "\n")
+
+	spec, err := p.Parse(content)
+
+	if err != nil {
+		return nil, fmt.Errorf("Error while generating tasks: %w", err)
+	}
+	return spec, nil
+}
+
+// newWorkflow stores the requirements and task code of a parsed workflow and
+// returns the rows to insert for a fresh run.
+func newWorkflow(spec *p.Workflow, requirements io.Reader, params map[string]any, priority *int) (*u.Workflow, []u.Task, error) {
+	resolved, err := spec.ResolveParams(params)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Error while resolving params: %w", err)
+	}
+
 	workflow := u.Workflow{
 		WorkflowId: uuid.New(),
+		Params:     resolved,
+		Priority:   spec.Priority,
+		MaxRunning: spec.MaxRunning,
 		CreatedAt:  time.Now(),
 		Status:     u.RunRunning,
 	}
+	if priority != nil {
+		workflow.Priority = *priority
+	}
 
-	_, err = repo.StorageClient.UploadFile("Workflow_Env", fmt.Sprintf("%v/env", workflow.WorkflowId), requirements)
+	err = storage.Store.Upload(storage.EnvBucket, fmt.Sprintf("%v/env", workflow.WorkflowId), requirements)
 
 	if err != nil {
-		return nil, fmt.Errorf("error while uploading requirements to supabase: %v", err)
+		return nil, nil, fmt.Errorf("error while uploading requirements to storage: %v", err)
 	}
 
 	workflow.EnvLink = fmt.Sprintf("%v/env", workflow.WorkflowId)
 
-	content := strings.Split(string(byteContent), "\n")
-
-	tasks, err := p.ParseWorkflow(workflow.WorkflowId, content)
+	tasks := spec.Build(workflow.WorkflowId)
 
-	if err != nil {
-		return nil, fmt.Errorf("Error while generating tasks: %v", err)
+	if err = uploadTaskCode(spec, tasks); err != nil {
+		return nil, nil, err
 	}
 
-	err = repo.InsertWorkflow(workflow, tasks)
+	return &workflow, tasks, nil
+}
 
-	return &workflow, err
+func uploadTaskCode(spec *p.Workflow, tasks []u.Task) error {
+	for i, task := range tasks {
+		err := storage.Store.Upload(storage.CodeBucket, task.CodeLink, strings.NewReader(spec.Tasks[i].Code))
+		if err != nil {
+			return fmt.Errorf("error while uploading code of task %s to storage: %v", task.Name, err)
+		}
+	}
+	return nil
 }
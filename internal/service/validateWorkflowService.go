@@ -0,0 +1,19 @@
+package service
+
+import (
+	"fmt"
+	"os"
+
+	p "github.com/Sayan-995/dwop/internal/parser"
+)
+
+func ValidateWorkflow(file *os.File, params map[string]any) (*p.Workflow, error) {
+	spec, err := parseWorkflowFile(file)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = spec.ResolveParams(params); err != nil {
+		return nil, fmt.Errorf("Error while resolving params: %w", err)
+	}
+	return spec, nil
+}
@@ -0,0 +1,119 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// LocalStore keeps artifacts in a directory and serves them over HTTP from
+// the orchestrator.
+type LocalStore struct {
+	*signer
+	root string
+}
+
+func NewLocalStore(root, baseURL, secret string) (*LocalStore, error) {
+	abs, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(abs, 0o755); err != nil {
+		return nil, fmt.Errorf("error while creating storage directory %s: %v", abs, err)
+	}
+	s := &LocalStore{root: abs}
+	s.signer, err = newSigner(s, baseURL, secret)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *LocalStore) file(bucket Bucket, path string) (string, error) {
+	dir := filepath.Join(s.root, string(bucket))
+	name := filepath.Join(dir, filepath.FromSlash(path))
+	if !strings.HasPrefix(name, dir+string(filepath.Separator)) {
+		return "", fmt.Errorf("invalid object path %q", path)
+	}
+	return name, nil
+}
+
+func (s *LocalStore) Upload(bucket Bucket, path string, data io.Reader) error {
+	name, err := s.file(bucket, path)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(name), ".upload-*")
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(tmp, data); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), name)
+}
+
+func (s *LocalStore) Download(bucket Bucket, path string) ([]byte, error) {
+	name, err := s.file(bucket, path)
+	if err != nil {
+		return nil, err
+	}
+	return os.ReadFile(name)
+}
+
+func (s *LocalStore) List(bucket Bucket, prefix string) ([]Object, error) {
+	dir := filepath.Join(s.root, string(bucket))
+	var objects []Object
+	err := filepath.WalkDir(dir, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() || strings.HasPrefix(d.Name(), ".upload-") {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, name)
+		if err != nil {
+			return err
+		}
+		path := filepath.ToSlash(rel)
+		if !strings.HasPrefix(path, prefix) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		objects = append(objects, Object{Path: path, Size: info.Size(), UpdatedAt: info.ModTime()})
+		return nil
+	})
+	return objects, err
+}
+
+func (s *LocalStore) Delete(bucket Bucket, paths ...string) error {
+	for _, path := range paths {
+		name, err := s.file(bucket, path)
+		if err != nil {
+			return err
+		}
+		if err := os.Remove(name); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
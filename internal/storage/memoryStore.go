@@ -0,0 +1,83 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type memoryObject struct {
+	data      []byte
+	updatedAt time.Time
+}
+
+// MemoryStore keeps artifacts in process memory and serves them the same way
+// LocalStore does. Everything is lost when the process exits.
+type MemoryStore struct {
+	*signer
+	mu      sync.RWMutex
+	objects map[Bucket]map[string]memoryObject
+}
+
+func NewMemoryStore(baseURL, secret string) (*MemoryStore, error) {
+	s := &MemoryStore{objects: map[Bucket]map[string]memoryObject{}}
+	var err error
+	s.signer, err = newSigner(s, baseURL, secret)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *MemoryStore) Upload(bucket Bucket, path string, data io.Reader) error {
+	if err := checkPath(path); err != nil {
+		return err
+	}
+	b, err := io.ReadAll(data)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.objects[bucket] == nil {
+		s.objects[bucket] = map[string]memoryObject{}
+	}
+	s.objects[bucket][path] = memoryObject{data: b, updatedAt: time.Now()}
+	return nil
+}
+
+func (s *MemoryStore) Download(bucket Bucket, path string) ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	obj, ok := s.objects[bucket][path]
+	if !ok {
+		return nil, fmt.Errorf("object %s/%s: %w", bucket, path, fs.ErrNotExist)
+	}
+	return append([]byte(nil), obj.data...), nil
+}
+
+func (s *MemoryStore) List(bucket Bucket, prefix string) ([]Object, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var objects []Object
+	for path, obj := range s.objects[bucket] {
+		if strings.HasPrefix(path, prefix) {
+			objects = append(objects, Object{Path: path, Size: int64(len(obj.data)), UpdatedAt: obj.updatedAt})
+		}
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Path < objects[j].Path })
+	return objects, nil
+}
+
+func (s *MemoryStore) Delete(bucket Bucket, paths ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, path := range paths {
+		delete(s.objects[bucket], path)
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+type S3Config struct {
+	Endpoint  string
+	AccessKey string
+	SecretKey string
+	Bucket    string
+	Region    string
+	UseSSL    bool
+}
+
+// S3Store keeps all artifacts in a single S3 bucket, using the logical bucket
+// name as the first path segment, since names like Task_Code are not valid S3
+// bucket names.
+type S3Store struct {
+	client *minio.Client
+	bucket string
+}
+
+func NewS3Store(conf S3Config) (*S3Store, error) {
+	if conf.Endpoint == "" || conf.Bucket == "" {
+		return nil, fmt.Errorf("DWOP_S3_ENDPOINT and DWOP_S3_BUCKET must be set")
+	}
+	client, err := minio.New(conf.Endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(conf.AccessKey, conf.SecretKey, ""),
+		Secure: conf.UseSSL,
+		Region: conf.Region,
+	})
+	if err != nil {
+		return nil, err
+	}
+	ctx := context.Background()
+	exists, err := client.BucketExists(ctx, conf.Bucket)
+	if err != nil {
+		return nil, fmt.Errorf("error while checking bucket %s: %v", conf.Bucket, err)
+	}
+	if !exists {
+		if err := client.MakeBucket(ctx, conf.Bucket, minio.MakeBucketOptions{Region: conf.Region}); err != nil {
+			return nil, fmt.Errorf("error while creating bucket %s: %v", conf.Bucket, err)
+		}
+	}
+	return &S3Store{client: client, bucket: conf.Bucket}, nil
+}
+
+func (s *S3Store) key(bucket Bucket, path string) string {
+	return string(bucket) + "/" + strings.TrimPrefix(path, "/")
+}
+
+func (s *S3Store) Upload(bucket Bucket, path string, data io.Reader) error {
+	body, err := io.ReadAll(data)
+	if err != nil {
+		return err
+	}
+	_, err = s.client.PutObject(context.Background(), s.bucket, s.key(bucket, path), bytes.NewReader(body), int64(len(body)), minio.PutObjectOptions{
+		ContentType: "application/octet-stream",
+	})
+	return err
+}
+
+func (s *S3Store) Download(bucket Bucket, path string) ([]byte, error) {
+	obj, err := s.client.GetObject(context.Background(), s.bucket, s.key(bucket, path), minio.GetObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer obj.Close()
+	return io.ReadAll(obj)
+}
+
+func (s *S3Store) SignedGetURL(bucket Bucket, path string, expiry time.Duration) (string, error) {
+	u, err := s.client.PresignedGetObject(context.Background(), s.bucket, s.key(bucket, path), clampExpiry(expiry), nil)
+	if err != nil {
+		return "", err
+	}
+	return u.String(), nil
+}
+
+func (s *S3Store) SignedPutURL(bucket Bucket, path string, expiry time.Duration) (string, error) {
+	u, err := s.client.PresignedPutObject(context.Background(), s.bucket, s.key(bucket, path), clampExpiry(expiry))
+	if err != nil {
+		return "", err
+	}
+	return u.String(), nil
+}
+
+func (s *S3Store) List(bucket Bucket, prefix string) ([]Object, error) {
+	var objects []Object
+	root := string(bucket) + "/"
+	for info := range s.client.ListObjects(context.Background(), s.bucket, minio.ListObjectsOptions{
+		Prefix:    root + prefix,
+		Recursive: true,
+	}) {
+		if info.Err != nil {
+			return nil, info.Err
+		}
+		objects = append(objects, Object{
+			Path:      strings.TrimPrefix(info.Key, root),
+			Size:      info.Size,
+			UpdatedAt: info.LastModified,
+		})
+	}
+	return objects, nil
+}
+
+func (s *S3Store) Delete(bucket Bucket, paths ...string) error {
+	for _, path := range paths {
+		if err := s.client.RemoveObject(context.Background(), s.bucket, s.key(bucket, path), minio.RemoveObjectOptions{}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// clampExpiry keeps presigned URLs within the seven days SigV4 allows.
+func clampExpiry(expiry time.Duration) time.Duration {
+	if expiry > 7*24*time.Hour {
+		return 7 * 24 * time.Hour
+	}
+	if expiry < time.Second {
+		return time.Second
+	}
+	return expiry
+}
@@ -0,0 +1,137 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	localURLPrefix = "/artifacts/"
+)
+
+// signer hands out URLs served by the orchestrator itself under /artifacts/.
+// Signed URLs carry an expiry and an HMAC of the method, object and expiry,
+// so workers still never hold credentials.
+type signer struct {
+	store   ArtifactStore
+	baseURL string
+	secret  []byte
+}
+
+func newSigner(store ArtifactStore, baseURL, secret string) (*signer, error) {
+	key := []byte(secret)
+	if secret == "" {
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+		fmt.Printf("[Storage] WARNING: DWOP_STORAGE_SECRET not set, signed URLs will not survive a restart\n")
+	}
+	return &signer{store: store, baseURL: strings.TrimRight(baseURL, "/"), secret: key}, nil
+}
+
+func (s *signer) SignedGetURL(bucket Bucket, path string, expiry time.Duration) (string, error) {
+	return s.sign(http.MethodGet, bucket, path, expiry)
+}
+
+func (s *signer) SignedPutURL(bucket Bucket, path string, expiry time.Duration) (string, error) {
+	return s.sign(http.MethodPut, bucket, path, expiry)
+}
+
+func (s *signer) sign(method string, bucket Bucket, path string, expiry time.Duration) (string, error) {
+	if err := checkPath(path); err != nil {
+		return "", err
+	}
+	expires := strconv.FormatInt(time.Now().Add(expiry).Unix(), 10)
+	q := url.Values{}
+	q.Set("expires", expires)
+	q.Set("signature", s.signature(method, bucket, path, expires))
+	return fmt.Sprintf("%s%s%s/%s?%s", s.baseURL, localURLPrefix, bucket, path, q.Encode()), nil
+}
+
+func (s *signer) signature(method string, bucket Bucket, path, expires string) string {
+	mac := hmac.New(sha256.New, s.secret)
+	fmt.Fprintf(mac, "%s\n%s\n%s\n%s", method, bucket, path, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// checkPath rejects object paths that could escape their bucket.
+func checkPath(path string) error {
+	for _, part := range strings.Split(path, "/") {
+		if part == "" || part == "." || part == ".." {
+			return fmt.Errorf("invalid object path %q", path)
+		}
+	}
+	return nil
+}
+
+// ServeHTTP answers GET and PUT requests on URLs produced by SignedGetURL and
+// SignedPutURL.
+func (s *signer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rest, ok := strings.CutPrefix(r.URL.Path, localURLPrefix)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	b, path, ok := strings.Cut(rest, "/")
+	bucket := Bucket(b)
+	known := false
+	for _, kb := range Buckets {
+		known = known || kb == bucket
+	}
+	if !ok || !known || path == "" {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodPut {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	expires := r.URL.Query().Get("expires")
+	deadline, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || time.Now().Unix() > deadline {
+		http.Error(w, "signed url expired", http.StatusForbidden)
+		return
+	}
+	want := s.signature(r.Method, bucket, path, expires)
+	if !hmac.Equal([]byte(want), []byte(r.URL.Query().Get("signature"))) {
+		http.Error(w, "invalid signature", http.StatusForbidden)
+		return
+	}
+	if err := checkPath(path); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if r.Method == http.MethodPut {
+		if err := s.store.Upload(bucket, path, r.Body); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	data, err := s.store.Download(bucket, path)
+	if errors.Is(err, fs.ErrNotExist) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+}
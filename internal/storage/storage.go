@@ -0,0 +1,88 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+type Bucket string
+
+const (
+	CodeBucket   Bucket = "Task_Code"
+	EnvBucket    Bucket = "Workflow_Env"
+	OutputBucket Bucket = "Task_Output"
+)
+
+var Buckets = []Bucket{CodeBucket, EnvBucket, OutputBucket}
+
+type Object struct {
+	Path      string    `json:"path"`
+	Size      int64     `json:"size"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// ArtifactStore holds task code, requirements and task outputs. Workers never
+// see credentials: they only receive signed URLs to read their inputs and to
+// PUT their output.
+type ArtifactStore interface {
+	// Upload writes an object, replacing any existing one.
+	Upload(bucket Bucket, path string, data io.Reader) error
+	Download(bucket Bucket, path string) ([]byte, error)
+	SignedGetURL(bucket Bucket, path string, expiry time.Duration) (string, error)
+	SignedPutURL(bucket Bucket, path string, expiry time.Duration) (string, error)
+	// List returns every object whose path starts with prefix.
+	List(bucket Bucket, prefix string) ([]Object, error)
+	Delete(bucket Bucket, paths ...string) error
+}
+
+var (
+	Store ArtifactStore
+)
+
+// NewFromEnv builds the store selected by DWOP_STORAGE: supabase (default),
+// s3 or local.
+func NewFromEnv() (ArtifactStore, error) {
+	switch backend := os.Getenv("DWOP_STORAGE"); backend {
+	case "", "supabase":
+		return NewSupabaseStore(os.Getenv("SUPABASE_PROJECT_URL"), os.Getenv("SUPABASE_SERVICE_KEY")), nil
+	case "s3":
+		useSSL, _ := strconv.ParseBool(os.Getenv("DWOP_S3_USE_SSL"))
+		return NewS3Store(S3Config{
+			Endpoint:  os.Getenv("DWOP_S3_ENDPOINT"),
+			AccessKey: os.Getenv("DWOP_S3_ACCESS_KEY"),
+			SecretKey: os.Getenv("DWOP_S3_SECRET_KEY"),
+			Bucket:    os.Getenv("DWOP_S3_BUCKET"),
+			Region:    os.Getenv("DWOP_S3_REGION"),
+			UseSSL:    useSSL,
+		})
+	case "local":
+		dir := os.Getenv("DWOP_STORAGE_DIR")
+		if dir == "" {
+			dir = "data/artifacts"
+		}
+		baseURL := os.Getenv("DWOP_STORAGE_URL")
+		if baseURL == "" {
+			port := os.Getenv("DWOP_PORT")
+			if port == "" {
+				port = "8080"
+			}
+			baseURL = "http://localhost:" + port
+		}
+		return NewLocalStore(dir, baseURL, os.Getenv("DWOP_STORAGE_SECRET"))
+	default:
+		return nil, fmt.Errorf("unknown DWOP_STORAGE %q, expected supabase, s3 or local", backend)
+	}
+}
+
+// Handler returns the HTTP handler serving signed URLs when the store is
+// backed by the orchestrator itself, and nil otherwise.
+func Handler() http.Handler {
+	if h, ok := Store.(http.Handler); ok {
+		return h
+	}
+	return nil
+}
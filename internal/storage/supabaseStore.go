@@ -0,0 +1,141 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	storage_go "github.com/supabase-community/storage-go"
+)
+
+type SupabaseStore struct {
+	client     *storage_go.Client
+	projectURL string
+}
+
+func NewSupabaseStore(projectURL, serviceKey string) *SupabaseStore {
+	projectURL = strings.TrimRight(strings.TrimSpace(projectURL), "/")
+	return &SupabaseStore{
+		client:     storage_go.NewClient(fmt.Sprintf("%v/storage/v1", projectURL), serviceKey, nil),
+		projectURL: projectURL,
+	}
+}
+
+func (s *SupabaseStore) Upload(bucket Bucket, path string, data io.Reader) error {
+	upsert := true
+	_, err := s.client.UploadFile(string(bucket), path, data, storage_go.FileOptions{Upsert: &upsert})
+	return err
+}
+
+func (s *SupabaseStore) Download(bucket Bucket, path string) ([]byte, error) {
+	return s.client.DownloadFile(string(bucket), path)
+}
+
+func (s *SupabaseStore) SignedGetURL(bucket Bucket, path string, expiry time.Duration) (string, error) {
+	res, err := s.client.CreateSignedUrl(string(bucket), path, int(expiry/time.Second))
+	if err != nil {
+		return "", err
+	}
+	return s.normalizeURL(res.SignedURL), nil
+}
+
+// SignedPutURL returns a Supabase signed upload URL. Supabase fixes their
+// lifetime at two hours, so expiry is not used.
+func (s *SupabaseStore) SignedPutURL(bucket Bucket, path string, expiry time.Duration) (string, error) {
+	res, err := s.client.CreateSignedUploadUrl(string(bucket), path)
+	if err != nil {
+		return "", err
+	}
+	if strings.TrimSpace(res.Url) == "" {
+		return "", fmt.Errorf("signed upload url is empty")
+	}
+	return s.normalizeURL(res.Url), nil
+}
+
+// List walks the folders under prefix, since Supabase lists one level at a
+// time.
+func (s *SupabaseStore) List(bucket Bucket, prefix string) ([]Object, error) {
+	dir, namePrefix := "", prefix
+	if i := strings.LastIndex(prefix, "/"); i >= 0 {
+		dir, namePrefix = prefix[:i], prefix[i+1:]
+	}
+	var objects []Object
+	var walk func(dir, namePrefix string) error
+	walk = func(dir, namePrefix string) error {
+		for offset := 0; ; {
+			page, err := s.client.ListFiles(string(bucket), dir, storage_go.FileSearchOptions{Limit: 1000, Offset: offset})
+			if err != nil {
+				return err
+			}
+			for _, f := range page {
+				if !strings.HasPrefix(f.Name, namePrefix) {
+					continue
+				}
+				path := f.Name
+				if dir != "" {
+					path = dir + "/" + f.Name
+				}
+				if f.Id == "" {
+					if err := walk(path, ""); err != nil {
+						return err
+					}
+					continue
+				}
+				obj := Object{Path: path}
+				obj.UpdatedAt, _ = time.Parse(time.RFC3339, f.UpdatedAt)
+				if meta, ok := f.Metadata.(map[string]any); ok {
+					if size, ok := meta["size"].(float64); ok {
+						obj.Size = int64(size)
+					}
+				}
+				objects = append(objects, obj)
+			}
+			if len(page) < 1000 {
+				return nil
+			}
+			offset += len(page)
+		}
+	}
+	if err := walk(dir, namePrefix); err != nil {
+		return nil, err
+	}
+	return objects, nil
+}
+
+func (s *SupabaseStore) Delete(bucket Bucket, paths ...string) error {
+	if len(paths) == 0 {
+		return nil
+	}
+	_, err := s.client.RemoveFile(string(bucket), paths)
+	return err
+}
+
+// normalizeURL turns the relative URLs returned by the storage API into
+// absolute ones workers can reach.
+func (s *SupabaseStore) normalizeURL(raw string) string {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return raw
+	}
+	if strings.HasPrefix(raw, "http://") || strings.HasPrefix(raw, "https://") {
+		return raw
+	}
+	if s.projectURL == "" {
+		return raw
+	}
+	storageBase := s.projectURL + "/storage/v1"
+
+	trimmed := strings.TrimPrefix(raw, "/")
+	if strings.HasPrefix(trimmed, "object/") {
+		return storageBase + "/" + trimmed
+	}
+	if strings.HasPrefix(trimmed, "storage/v1/") {
+		return s.projectURL + "/" + trimmed
+	}
+
+	if strings.HasPrefix(raw, "/") {
+		return s.projectURL + raw
+	}
+	return s.projectURL + "/" + raw
+}
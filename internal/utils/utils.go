@@ -7,13 +7,14 @@ import (
 	"time"
 
 	"github.com/google/uuid"
-	amqp "github.com/rabbitmq/amqp091-go"
-	"k8s.io/client-go/kubernetes"
 )
 
 type RunStatus string
 type TaskStatus string
 type OutboxEventType string
+type TriggerRule string
+type ScheduleStatus string
+type CatchUpPolicy string
 
 const (
 	RunRunning   RunStatus = "RUNNING"
@@ -29,32 +30,99 @@ const (
 	TaskSucceeded TaskStatus = "SUCCEEDED"
 	TaskFailed    TaskStatus = "FAILED"
 	TaskCanceled  TaskStatus = "CANCELED"
+	TaskSkipped   TaskStatus = "SKIPPED"
 )
 
 const (
-	OutboxTaskReady      OutboxEventType = "TASK_READY"
-	OutboxTaskRetryReady OutboxEventType = "TASK_RETRY_READY"
+	TriggerAllSuccess TriggerRule = "all_success"
+	TriggerAllDone    TriggerRule = "all_done"
+	TriggerOneFailed  TriggerRule = "one_failed"
+)
+
+const (
+	ScheduleActive ScheduleStatus = "ACTIVE"
+	SchedulePaused ScheduleStatus = "PAUSED"
 )
 
 const (
-	WorkerCount = 15
+	// CatchUpLatest runs ticks missed while the orchestrator was down as one
+	// run for the most recent of them.
+	CatchUpLatest CatchUpPolicy = "latest"
+	// CatchUpAll runs every missed tick, oldest first.
+	CatchUpAll CatchUpPolicy = "all"
+	// CatchUpNone drops missed ticks.
+	CatchUpNone CatchUpPolicy = "none"
 )
 
-var (
-	Conf *Kubeconfigs
+const (
+	OutboxTaskReady      OutboxEventType = "TASK_READY"
+	OutboxTaskRetryReady OutboxEventType = "TASK_RETRY_READY"
+)
+
+const (
+	WorkerCount        = 15
+	DefaultMaxAttempts = 5
+	// MaxPriority is the highest workflow priority; 0 is the default and the
+	// lowest. It matches the queue's x-max-priority.
+	MaxPriority = 9
 )
 
+// Workflow is one run: a submitted workflow file or a version of a
+// WorkflowDefinition, with its own tasks.
 type Workflow struct {
-	WorkflowId uuid.UUID  `json:"workflow_id" db:"workflow_id"`
-	EnvLink    string     `json:"env_link" db:"env_link"`
+	WorkflowId uuid.UUID      `json:"workflow_id" db:"workflow_id"`
+	EnvLink    string         `json:"env_link" db:"env_link"`
+	Params     map[string]any `json:"params" db:"params"`
+	Priority   int            `json:"priority" db:"priority"`
+	// MaxRunning caps the workflow's concurrently running tasks; 0 falls
+	// back to the orchestrator's default.
+	MaxRunning int        `json:"max_running" db:"max_running"`
 	CreatedAt  time.Time  `json:"created_at" db:"created_at"`
 	FinishedAt *time.Time `json:"finished_at" db:"finished_at"`
 	Status     RunStatus  `json:"status" db:"status"`
+	// ScheduleId and ScheduledFor are set on runs created by a schedule, for
+	// the tick they were created for.
+	ScheduleId   *uuid.UUID `json:"schedule_id,omitempty" db:"schedule_id"`
+	ScheduledFor *time.Time `json:"scheduled_for,omitempty" db:"scheduled_for"`
+	// DefinitionId and DefinitionVersion are set on runs of a stored
+	// WorkflowDefinition. A run needs its own tasks, outputs and status
+	// anyway, so it is a Workflow rather than a separate run record.
+	DefinitionId      *uuid.UUID `json:"definition_id,omitempty" db:"definition_id"`
+	DefinitionVersion *int       `json:"definition_version,omitempty" db:"definition_version"`
 }
-type WorkflowRun struct {
-	WorkflowId uuid.UUID `json:"workflow_id" db:"workflow_id"`
-	RunId      uuid.UUID `json:"run_id" db:"run_id"`
-	EnvLink    string    `json:"env_link" db:"env_link"`
+
+// Schedule starts a run of a stored workflow definition at every tick of a
+// cron expression, evaluated in Timezone.
+type Schedule struct {
+	ScheduleId   uuid.UUID      `json:"schedule_id" db:"schedule_id"`
+	Name         string         `json:"name" db:"name"`
+	Cron         string         `json:"cron" db:"cron"`
+	Timezone     string         `json:"timezone" db:"timezone"`
+	CatchUp      CatchUpPolicy  `json:"catch_up" db:"catch_up"`
+	Definition   string         `json:"definition" db:"definition"`
+	Requirements string         `json:"requirements" db:"requirements"`
+	Params       map[string]any `json:"params" db:"params"`
+	// Priority overrides the one declared in the definition when set.
+	Priority  *int           `json:"priority" db:"priority"`
+	Status    ScheduleStatus `json:"status" db:"status"`
+	NextRunAt time.Time      `json:"next_run_at" db:"next_run_at"`
+	CreatedAt time.Time      `json:"created_at" db:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at" db:"updated_at"`
+}
+
+// WorkflowDefinition is one version of a named workflow file and its
+// requirements. Versions are immutable; every Workflow started from one is a
+// run of it with its own tasks, task runs and outputs.
+type WorkflowDefinition struct {
+	DefinitionId uuid.UUID `json:"definition_id" db:"definition_id"`
+	Name         string    `json:"name" db:"name"`
+	Version      int       `json:"version" db:"version"`
+	Definition   string    `json:"definition" db:"definition"`
+	Requirements string    `json:"requirements" db:"requirements"`
+	// Hash fingerprints Definition and Requirements, so registering the
+	// same content again does not add a version.
+	Hash      string    `json:"hash" db:"hash"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
 }
 
 type Task struct {
@@ -63,6 +131,7 @@ type Task struct {
 
 	Name     string `json:"name" db:"name"`
 	CodeLink string `json:"code_link" db:"code_link"`
+	CodeHash string `json:"code_hash,omitempty" db:"code_hash"`
 
 	PendingPreds int               `json:"pending_preds" db:"pending_preds"`
 	FuncArgMap   map[string]string `json:"func_arg_map" db:"func_arg_map"`
@@ -72,13 +141,34 @@ type Task struct {
 	Attempt      int               `json:"attempt" db:"attempt"`
 	MaxAttempts  int               `json:"max_attempts" db:"max_attempts"`
 	CreatedAt    time.Time         `json:"created_at" db:"created_at"`
+
+	Image          string        `json:"image,omitempty" db:"image"`
+	TimeoutSeconds int64         `json:"timeout_seconds,omitempty" db:"timeout_seconds"`
+	Resources      TaskResources `json:"resources" db:"resources"`
+	TriggerRule    TriggerRule   `json:"trigger_rule" db:"trigger_rule"`
+	Cache          bool          `json:"cache" db:"cache"`
+	Pool           string        `json:"pool,omitempty" db:"pool"`
+	CacheKey       string        `json:"cache_key,omitempty" db:"cache_key"`
+
+	// MapOver names the predecessor whose JSON list output is fanned out into
+	// one child task per element. Children point back through ParentTaskId.
+	MapOver      string     `json:"map_over,omitempty" db:"map_over"`
+	ParentTaskId *uuid.UUID `json:"parent_task_id,omitempty" db:"parent_task_id"`
+	MapIndex     *int       `json:"map_index,omitempty" db:"map_index"`
+	MapSize      *int       `json:"map_size,omitempty" db:"map_size"`
+	MapCollected bool       `json:"map_collected" db:"map_collected"`
+}
+type TaskResources struct {
+	CPU    string `json:"cpu,omitempty"`
+	Memory string `json:"memory,omitempty"`
 }
 type TaskRun struct {
-	TaskId     uuid.UUID `json:"task_id" db:"task_run_id"`
+	TaskId     uuid.UUID `json:"task_id" db:"task_id"`
 	RunId      uuid.UUID `json:"run_id" db:"run_id"`
 	WorkflowId uuid.UUID `json:"workflow_id" db:"workflow_id"`
 
 	Status     TaskStatus `json:"status" db:"status"`
+	Attempt    int        `json:"attempt" db:"attempt"`
 	LastError  *string    `json:"last_error" db:"last_error"`
 	LeaseOwner *int       `json:"lease_owner" db:"lease_owner"`
 	LeaseUntil *time.Time `json:"lease_until" db:"lease_until"`
@@ -91,22 +181,26 @@ type OutboxEvent struct {
 	WorkflowId uuid.UUID       `json:"workflow_id" db:"workflow_id"`
 	Type       OutboxEventType `json:"event_type" db:"event_type"`
 	Payload    any             `json:"payload" db:"payload"`
+	// Priority is copied from the workflow when the event is inserted.
+	Priority int `json:"priority" db:"priority"`
 
 	CreatedAt        time.Time  `json:"created_at" db:"created_at"`
 	PublishedAt      *time.Time `json:"published_at" db:"published_at"`
 	ClaimedAt        *time.Time `json:"claimed_at" db:"claimed_at"`
 	ClaimedBy        *int       `json:"claimed_by" db:"claimed_by"`
+	LeaseUntil       *time.Time `json:"lease_until" db:"lease_until"`
 	PublishAttempts  int        `json:"publish_attempts" db:"publish_attempts"`
 	LastPublishError *string    `json:"last_publish_error" db:"last_publish_error"`
+	NextAttemptAt    *time.Time `json:"next_attempt_at" db:"next_attempt_at"`
+	DeadLetteredAt   *time.Time `json:"dead_lettered_at" db:"dead_lettered_at"`
+	// DeferredAt is set while the event waits for a concurrency slot.
+	DeferredAt *time.Time `json:"deferred_at" db:"deferred_at"`
 }
 
-type Kubeconfigs struct {
-	K8s       kubernetes.Interface
-	Namespace string
-	ImageName string
-}
-
-type RabbitMQ struct {
-	Conn          *amqp.Connection
-	PublisherPool chan *amqp.Channel
+func (s TaskStatus) Terminal() bool {
+	switch s {
+	case TaskSucceeded, TaskFailed, TaskCanceled, TaskSkipped:
+		return true
+	}
+	return false
 }
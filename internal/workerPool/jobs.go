@@ -1,149 +1,256 @@
 package workerpool
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"math/rand/v2"
 	"time"
 
+	"github.com/Sayan-995/dwop/internal/cache"
 	"github.com/Sayan-995/dwop/internal/executor"
-	rabitmq "github.com/Sayan-995/dwop/internal/rabitMQ"
-	rmq "github.com/Sayan-995/dwop/internal/rabitMQ"
+	"github.com/Sayan-995/dwop/internal/mapper"
+	"github.com/Sayan-995/dwop/internal/metrics"
+	"github.com/Sayan-995/dwop/internal/queue"
 	repo "github.com/Sayan-995/dwop/internal/repository"
-	"github.com/Sayan-995/dwop/internal/service"
+	"github.com/Sayan-995/dwop/internal/scheduler"
 	"github.com/Sayan-995/dwop/internal/utils"
 	"github.com/google/uuid"
 )
 
-func OutboxClaimJob(id int) {
+const (
+	// retryDelay is how long a message waits before it is retried after a
+	// consumer hit a transient error.
+	retryDelay = 5 * time.Second
+
+	publishBackoffBase = 5 * time.Second
+	publishBackoffMax  = 5 * time.Minute
+)
+
+// publishBackoff doubles the delay with every failed publish, up to
+// publishBackoffMax, and randomises the upper half of it so events that failed
+// together during an outage do not all come back at once.
+func publishBackoff(failures int) time.Duration {
+	delay := publishBackoffMax
+	if failures < 16 {
+		delay = min(publishBackoffBase<<(failures-1), publishBackoffMax)
+	}
+	return delay/2 + rand.N(delay/2)
+}
+
+// OutboxClaimJob claims and publishes one batch of outbox events and returns
+// how many it claimed.
+func OutboxClaimJob(id int) int {
+	// Counted before the expired leases are released, with the query behind
+	// /admin/outbox/stuck.
+	_, stuck, err := repo.ListStuckOutboxEvents(time.Now().Add(-repo.OutboxLease), repo.ListOptions{Limit: 1})
+	if err != nil {
+		fmt.Printf("[OutboxClaimJob] ERROR counting stuck events: %v\n", err)
+	} else {
+		metrics.OutboxStuckEvents.Set(float64(stuck))
+	}
+
+	released, err := repo.ReleaseExpiredOutboxLeases()
+	if err != nil {
+		fmt.Printf("[OutboxClaimJob] ERROR releasing expired leases: %v\n", err)
+	} else if released > 0 {
+		fmt.Printf("[OutboxClaimJob %d] Released %d events whose lease expired\n", id, released)
+		metrics.OutboxReclaimedEvents.Add(float64(released))
+	}
+
 	data, err := repo.ClaimOutboxEvents(id)
 	if err != nil {
 		fmt.Printf("[OutboxClaimJob] ERROR claiming events: %v\n", err)
-		return
+		return 0
 	}
-	if data == "[]" {
+	if len(data) == 0 {
 		fmt.Printf("[OutboxClaimJob %d] No events to claim\n", id)
-		return
+		return 0
 	}
 	fmt.Printf("[OutboxClaimJob %d] Claimed %d events, sending to RMQ\n", id, len(data))
 	errCh := make(chan utils.OutboxEvent, 200)
-	rmq.SendTaskEvents(id, data, errCh)
+	queue.SendTaskEvents(id, data, errCh)
 	for event := range errCh {
 		if event.LastPublishError != nil {
 			fmt.Printf("[OutboxClaimJob] Publish failed for event %s: %v\n", event.EventID, *event.LastPublishError)
-			if event.PublishAttempts == 0 {
-				fmt.Printf("[OutboxClaimJob] Max attempts reached for event %s, canceling workflow %s\n", event.EventID, event.WorkflowId)
-				_ = service.CancelWorkflow(event.WorkflowId.String())
+			metrics.OutboxPublishFailures.Inc()
+			// Unclaim the event rather than copying it, so that its lease
+			// cannot expire and get it published a second time.
+			now := time.Now()
+			event.ClaimedAt = nil
+			event.ClaimedBy = nil
+			event.LeaseUntil = nil
+			event.PublishAttempts--
+			if event.PublishAttempts <= 0 {
+				fmt.Printf("[OutboxClaimJob] Max attempts reached for event %s of workflow %s, dead-lettering it\n", event.EventID, event.WorkflowId)
+				event.NextAttemptAt = nil
+				event.DeadLetteredAt = &now
 			} else {
-				fmt.Printf("[OutboxClaimJob] Retrying event %s (attempts left: %d)\n", event.EventID, event.PublishAttempts-1)
-				event.EventID = uuid.New()
-				event.ClaimedAt = nil
-				event.ClaimedBy = nil
-				event.PublishAttempts--
+				delay := publishBackoff(repo.PublishAttempts - event.PublishAttempts)
+				fmt.Printf("[OutboxClaimJob] Retrying event %s in %s (attempts left: %d)\n", event.EventID, delay, event.PublishAttempts)
+				next := now.Add(delay)
+				event.NextAttemptAt = &next
 				event.Type = utils.OutboxTaskRetryReady
-				addErr := repo.AddOutboxEvent(event)
-				if addErr != nil {
-					fmt.Printf("[OutboxClaimJob] ERROR re-adding event for retry: %v\n", addErr)
-				}
+			}
+			updated, updateErr := repo.UpdateOutboxEvent(event, id)
+			if updateErr != nil {
+				fmt.Printf("[OutboxClaimJob] ERROR rescheduling event %s: %v\n", event.EventID, updateErr)
+			} else if !updated {
+				fmt.Printf("[OutboxClaimJob] Event %s is no longer claimed by %d, not rescheduling it\n", event.EventID, id)
+			} else if event.DeadLetteredAt != nil {
+				metrics.OutboxDeadLetteredEvents.Inc()
 			}
 		} else {
 			fmt.Printf("[OutboxClaimJob] Event %s published successfully, marking in DB\n", event.EventID)
 			t := time.Now()
 			event.PublishedAt = &t
-			updateErr := repo.UpdateOutboxEvent(event)
+			// A consumer may already have deferred the message and had the
+			// event released, in which case it must stay unpublished.
+			updated, updateErr := repo.UpdateOutboxEvent(event, id)
 			if updateErr != nil {
 				fmt.Printf("[OutboxClaimJob] ERROR updating published event %s: %v\n", event.EventID, updateErr)
+			} else if !updated {
+				fmt.Printf("[OutboxClaimJob] Event %s is no longer claimed by %d, not marking it published\n", event.EventID, id)
 			}
 		}
 	}
+	return len(data)
 }
 
-func ConsumeRabitMQJob(id int) {
-	ch, err := rabitmq.RabbitMQClient.Conn.Channel()
+func ConsumeTaskQueueJob(id int) {
+	msg, err := queue.Tasks.Consume(context.Background())
 	if err != nil {
-		log.Fatalf("error getting the consumer channel: %v", err)
+		fmt.Printf("[ConsumeJob] ERROR starting consumer %d: %v\n", id, err)
+		return
 	}
 	fmt.Printf("%dth consumer started\n", id)
-	err = ch.Qos(1, 0, false)
 
+	for d := range msg {
+		handleTaskMessage(d)
+	}
+	fmt.Printf("%dth consumer exitted\n", id)
+}
+
+// handleTaskMessage starts a run for the task of one TASK_READY message and
+// settles the message.
+func handleTaskMessage(d queue.Delivery) {
+	var event utils.OutboxEvent
+	err := json.Unmarshal(d.Body(), &event)
 	if err != nil {
-		log.Fatalf("error while setting consumer's qos: %v", err)
-	}
-	msg, err := ch.Consume(
-		rabitmq.QueueName,
-		"",
-		false,
-		false,
-		false,
-		false,
-		nil,
-	)
+		fmt.Printf("[ConsumeJob] Dropping malformed message: %v\n", err)
+		_ = d.Nack(false)
+		return
+	}
+	taskInstance := utils.TaskRun{
+		RunId:      uuid.New(),
+		TaskId:     event.TaskID,
+		WorkflowId: event.WorkflowId,
+		Status:     utils.TaskRunning,
+		LastError:  nil,
+		CreatedAt:  time.Now(),
+	}
+	cnt, err := repo.UpsertTaskRun(taskInstance)
+	if errors.Is(err, repo.ErrRunLimit) {
+		// The message is dropped and its outbox event parked until the
+		// observer sees a slot free up.
+		fmt.Printf("[ConsumeJob] Deferring task %s of workflow %s: %v\n", event.TaskID, event.WorkflowId, err)
+		if err := repo.DeferOutboxEvent(event.EventID); err != nil {
+			fmt.Printf("[ConsumeJob] ERROR deferring event %s: %v\n", event.EventID, err)
+			_ = d.Requeue(retryDelay)
+			return
+		}
+		metrics.DeferredRuns.Inc()
+		_ = d.Ack()
+		return
+	} else if err != nil {
+		fmt.Println(err)
+		_ = d.Requeue(retryDelay)
+		return
+	} else if cnt == 0 {
+		_ = d.Nack(false)
+		return
+	}
 
-	for d := range msg {
-		var event utils.OutboxEvent
-		err := json.Unmarshal(d.Body, &event)
+	// retry gives the task back before the message is redelivered; the run
+	// started above would otherwise make the redelivery look like a duplicate.
+	retry := func(reason string) {
+		if err := repo.AbandonTaskRun(taskInstance.RunId, reason); err != nil {
+			fmt.Printf("[ConsumeJob] ERROR abandoning run %s: %v\n", taskInstance.RunId, err)
+		}
+		_ = d.Requeue(retryDelay)
+	}
+	workflow, err := repo.GetWorkflowByID(event.WorkflowId)
+	if err != nil {
+		fmt.Printf("[ConsumeJob] Error getting workflow %s: %v\n", event.WorkflowId, err)
+		retry(fmt.Sprintf("error getting workflow: %v", err))
+		return
+	}
+	if workflow == nil {
+		fmt.Printf("[ConsumeJob] Workflow %s not found\n", event.WorkflowId)
+		retry("workflow not found")
+		return
+	}
+	if workflow.Status != utils.RunRunning {
+		fmt.Printf("[ConsumeJob] Workflow %s status is %s, skipping\n", event.WorkflowId, workflow.Status)
+		_ = d.Ack()
+		return
+	}
+	task, err := repo.GetTaskByID(event.TaskID)
+	if err != nil {
+		fmt.Printf("[ConsumeJob] Error getting task %s: %v\n", event.TaskID, err)
+		retry(fmt.Sprintf("error getting task: %v", err))
+		return
+	}
+	if task == nil {
+		fmt.Printf("[ConsumeJob] Task %s not found\n", event.TaskID)
+		retry("task not found")
+		return
+	}
+	if mapper.IsParent(*task) {
+		fmt.Printf("[ConsumeJob] Expanding mapped task %s (run %s) in workflow %s\n", task.TaskId, taskInstance.RunId, event.WorkflowId)
+		outcome, err := mapper.Expand(*task, taskInstance.RunId)
 		if err != nil {
-			fmt.Println(err)
-			_ = d.Reject(true)
-			continue
+			fmt.Printf("[ConsumeJob] ERROR expanding mapped task: %v\n", err)
+			retry(fmt.Sprintf("error expanding mapped task: %v", err))
+			return
 		}
-		taskInstance := utils.TaskRun{
-			RunId:      uuid.New(),
-			TaskId:     event.TaskID,
-			WorkflowId: event.WorkflowId,
-			LastError:  nil,
-			CreatedAt:  time.Now(),
+		switch outcome {
+		case mapper.Completed:
+			err = scheduler.TaskSucceeded(task.TaskId)
+		case mapper.Failed:
+			err = scheduler.TaskFailed(task.TaskId)
 		}
-		cnt, err := repo.UpsertTaskRun(taskInstance)
 		if err != nil {
-			fmt.Println(err)
-			_ = d.Reject(true)
-		} else if cnt == 0 {
-			_ = d.Reject(false)
-		} else {
-			workflow, err := repo.GetWorkflowByID(event.WorkflowId)
-			if err != nil {
-				fmt.Printf("[ConsumeJob] Error getting workflow %s: %v\n", event.WorkflowId, err)
-				_ = d.Reject(true)
-				continue
-			}
-			if workflow == nil {
-				fmt.Printf("[ConsumeJob] Workflow %s not found\n", event.WorkflowId)
-				_ = d.Reject(true)
-				continue
-			}
-			if workflow.Status != utils.RunRunning {
-				fmt.Printf("[ConsumeJob] Workflow %s status is %s, skipping\n", event.WorkflowId, workflow.Status)
-				_ = d.Ack(false)
-				continue
-			}
-			task, err := repo.GetTaskByID(event.TaskID)
-			if err != nil {
-				fmt.Printf("[ConsumeJob] Error getting task %s: %v\n", event.TaskID, err)
-				_ = d.Reject(true)
-				continue
-			}
-			if task == nil {
-				fmt.Printf("[ConsumeJob] Task %s not found\n", event.TaskID)
-				_ = d.Reject(true)
-				continue
-			}
-			if utils.Conf == nil || utils.Conf.K8s == nil {
-				fmt.Printf("[ConsumeJob] ERROR: Kubernetes client not initialized\n")
-				_ = d.Reject(true)
-				continue
-			}
-			fmt.Printf("[ConsumeJob] Creating job for task %s (run %s) in workflow %s\n", task.TaskId, taskInstance.RunId, event.WorkflowId)
-			_, err = executor.CreateJob(utils.Conf.K8s, utils.Conf.Namespace, utils.Conf.ImageName, *workflow, *task, taskInstance.RunId)
-			if err != nil {
-				fmt.Printf("[ConsumeJob] ERROR creating job: %v\n", err)
-				_ = d.Reject(true)
-				continue
+			fmt.Printf("[ConsumeJob] ERROR resolving dependents of %s: %v\n", task.TaskId, err)
+		}
+		_ = d.Ack()
+		return
+	}
+	if task.Cache {
+		hit, err := cache.Resolve(*workflow, *task, taskInstance.RunId)
+		if err != nil {
+			fmt.Printf("[ConsumeJob] WARNING: cache lookup for task %s failed, running it: %v\n", task.TaskId, err)
+		} else if hit {
+			if err := scheduler.TaskSucceeded(task.TaskId); err != nil {
+				fmt.Printf("[ConsumeJob] ERROR resolving dependents of %s: %v\n", task.TaskId, err)
 			}
-			fmt.Printf("[ConsumeJob] Job created successfully\n")
-			_ = d.Ack(false)
+			_ = d.Ack()
+			return
 		}
-
 	}
-	fmt.Printf("%dth consumer exitted\n", id)
+	if executor.Default == nil {
+		fmt.Printf("[ConsumeJob] ERROR: executor not initialized\n")
+		retry("executor not initialized")
+		return
+	}
+	fmt.Printf("[ConsumeJob] Creating job for task %s (run %s) in workflow %s\n", task.TaskId, taskInstance.RunId, event.WorkflowId)
+	err = executor.Default.Submit(*workflow, *task, taskInstance.RunId)
+	if err != nil {
+		fmt.Printf("[ConsumeJob] ERROR creating job: %v\n", err)
+		retry(fmt.Sprintf("error creating job: %v", err))
+		return
+	}
+	fmt.Printf("[ConsumeJob] Job created successfully\n")
+	_ = d.Ack()
 }
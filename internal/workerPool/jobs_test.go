@@ -0,0 +1,294 @@
+package workerpool
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Sayan-995/dwop/internal/executor"
+	"github.com/Sayan-995/dwop/internal/mapper"
+	"github.com/Sayan-995/dwop/internal/queue"
+	repo "github.com/Sayan-995/dwop/internal/repository"
+	"github.com/Sayan-995/dwop/internal/scheduler"
+	"github.com/Sayan-995/dwop/internal/storage"
+	"github.com/Sayan-995/dwop/internal/utils"
+	"github.com/google/uuid"
+)
+
+type fakeDelivery struct {
+	body    []byte
+	settled string
+}
+
+func (d *fakeDelivery) Body() []byte { return d.body }
+
+func (d *fakeDelivery) Ack() error {
+	d.settled = "ack"
+	return nil
+}
+
+func (d *fakeDelivery) Nack(requeue bool) error {
+	d.settled = "nack"
+	return nil
+}
+
+func (d *fakeDelivery) Requeue(delay time.Duration) error {
+	d.settled = "requeue"
+	return nil
+}
+
+// stubExecutor fails the first failures submits and records the rest.
+type stubExecutor struct {
+	failures  int
+	submitted []uuid.UUID
+}
+
+func (e *stubExecutor) Submit(workflow utils.Workflow, task utils.Task, runID uuid.UUID) error {
+	if e.failures > 0 {
+		e.failures--
+		return errors.New("executor unavailable")
+	}
+	e.submitted = append(e.submitted, runID)
+	return nil
+}
+
+func (e *stubExecutor) Status(runID uuid.UUID) (executor.RunStatus, error) {
+	return executor.RunStatus{State: executor.StateRunning}, nil
+}
+func (e *stubExecutor) Logs(runID uuid.UUID) (string, error) { return "", nil }
+func (e *stubExecutor) Cancel(runID uuid.UUID) error         { return nil }
+func (e *stubExecutor) StopWorkflow(workflowId string) error { return nil }
+func (e *stubExecutor) Runs() ([]uuid.UUID, error)           { return e.submitted, nil }
+func (e *stubExecutor) Watch(ctx context.Context) (<-chan uuid.UUID, error) {
+	return nil, errors.New("not supported")
+}
+
+// queueTask stores a workflow with one ready task and returns the message
+// that would start it.
+func queueTask(t *testing.T, task utils.Task) []byte {
+	t.Helper()
+	workflow := utils.Workflow{WorkflowId: uuid.New(), Status: utils.RunRunning, CreatedAt: time.Now()}
+	task.TaskId = uuid.New()
+	task.WorkflowId = workflow.WorkflowId
+	task.Status = utils.TaskPending
+	task.MaxAttempts = 3
+	task.CreatedAt = time.Now()
+	if err := repo.Repo.InsertWorkflow(workflow, []utils.Task{task}); err != nil {
+		t.Fatal(err)
+	}
+	body, _ := json.Marshal(utils.OutboxEvent{
+		EventID:    uuid.New(),
+		WorkflowId: workflow.WorkflowId,
+		TaskID:     task.TaskId,
+		Type:       utils.OutboxTaskReady,
+	})
+	return body
+}
+
+func deliver(t *testing.T, body []byte, want string) {
+	t.Helper()
+	d := &fakeDelivery{body: body}
+	handleTaskMessage(d)
+	if d.settled != want {
+		t.Fatalf("message settled with %q, want %q", d.settled, want)
+	}
+}
+
+func taskOf(t *testing.T, body []byte) (utils.Task, []utils.TaskRun) {
+	t.Helper()
+	var event utils.OutboxEvent
+	_ = json.Unmarshal(body, &event)
+	task, err := repo.Repo.GetTaskByID(event.TaskID)
+	if err != nil || task == nil {
+		t.Fatalf("task %s: %v", event.TaskID, err)
+	}
+	runs, _, err := repo.Repo.ListTaskRuns(event.TaskID, repo.ListOptions{Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return *task, runs
+}
+
+func TestRedeliveryAfterFailedSubmitRunsTask(t *testing.T) {
+	repo.Repo = repo.NewMemoryRepository()
+	exec := &stubExecutor{failures: 1}
+	executor.Default = exec
+	body := queueTask(t, utils.Task{Name: "a"})
+
+	deliver(t, body, "requeue")
+	task, runs := taskOf(t, body)
+	if task.Status != utils.TaskQueued {
+		t.Fatalf("task is %s after a failed submit, want QUEUED", task.Status)
+	}
+	if len(runs) != 1 || runs[0].Status != utils.TaskFailed || runs[0].LastError == nil {
+		t.Fatalf("want one FAILED run with an error, got %+v", runs)
+	}
+	if task.Attempt != 0 {
+		t.Fatalf("a failed submit used up attempt %d", task.Attempt)
+	}
+
+	deliver(t, body, "ack")
+	task, runs = taskOf(t, body)
+	if task.Status != utils.TaskRunning {
+		t.Fatalf("task is %s after redelivery, want RUNNING", task.Status)
+	}
+	if len(exec.submitted) != 1 {
+		t.Fatalf("submitted %d runs, want 1", len(exec.submitted))
+	}
+	latest, _ := repo.Repo.GetLatestTaskRun(task.TaskId)
+	if len(runs) != 2 || latest.RunId != exec.submitted[0] || latest.Status != utils.TaskRunning {
+		t.Fatalf("want the submitted run to be the latest RUNNING run, got %+v", runs)
+	}
+	// the abandoned run did not use up the attempt, so both runs are attempt 1
+	for _, run := range runs {
+		if run.Attempt != 1 {
+			t.Fatalf("run %s is attempt %d, want 1", run.RunId, run.Attempt)
+		}
+	}
+
+	// a further duplicate is still dropped
+	deliver(t, body, "nack")
+}
+
+func TestRedeliveryAfterFailedExpandExpandsTask(t *testing.T) {
+	repo.Repo = repo.NewMemoryRepository()
+	store, err := storage.NewMemoryStore("http://localhost", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	storage.Store = store
+	executor.Default = &stubExecutor{}
+	body := queueTask(t, utils.Task{Name: "process", MapOver: "split"})
+
+	// the output of split is not there yet, so downloading it fails
+	deliver(t, body, "requeue")
+	task, runs := taskOf(t, body)
+	if task.Status != utils.TaskQueued || task.MapSize != nil {
+		t.Fatalf("task is %s with map size %v after a failed expand, want QUEUED and unexpanded", task.Status, task.MapSize)
+	}
+	if len(runs) != 1 || runs[0].Status != utils.TaskFailed {
+		t.Fatalf("want one FAILED run, got %+v", runs)
+	}
+
+	if err := store.Upload(storage.OutputBucket, mapper.OutputPath(task.WorkflowId, "split"), strings.NewReader(`[1, 2]`)); err != nil {
+		t.Fatal(err)
+	}
+	deliver(t, body, "ack")
+	task, _ = taskOf(t, body)
+	if task.Status != utils.TaskRunning || task.MapSize == nil || *task.MapSize != 2 {
+		t.Fatalf("task is %s with map size %v after redelivery, want RUNNING with 2 children", task.Status, task.MapSize)
+	}
+	children, _ := repo.Repo.GetChildTasks(task.TaskId)
+	if len(children) != 2 {
+		t.Fatalf("got %d children, want 2", len(children))
+	}
+}
+
+// flakyStore fails the first failures uploads.
+type flakyStore struct {
+	storage.ArtifactStore
+	failures int
+}
+
+func (s *flakyStore) Upload(bucket storage.Bucket, path string, data io.Reader) error {
+	if s.failures > 0 {
+		s.failures--
+		return errors.New("store unavailable")
+	}
+	return s.ArtifactStore.Upload(bucket, path, data)
+}
+
+func TestRedeliveryAfterFailedEmptyExpandCompletesTask(t *testing.T) {
+	repo.Repo = repo.NewMemoryRepository()
+	mem, err := storage.NewMemoryStore("http://localhost", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := &flakyStore{ArtifactStore: mem}
+	storage.Store = store
+	executor.Default = &stubExecutor{}
+	body := queueTask(t, utils.Task{Name: "process", MapOver: "split"})
+	task, _ := taskOf(t, body)
+	if err := mem.Upload(storage.OutputBucket, mapper.OutputPath(task.WorkflowId, "split"), strings.NewReader(`[]`)); err != nil {
+		t.Fatal(err)
+	}
+
+	// the task is expanded into no children, but its empty output cannot be
+	// uploaded
+	store.failures = 1
+	deliver(t, body, "requeue")
+	task, _ = taskOf(t, body)
+	if task.Status != utils.TaskQueued || task.MapSize == nil || *task.MapSize != 0 {
+		t.Fatalf("task is %s with map size %v after a failed completion, want QUEUED with 0 children", task.Status, task.MapSize)
+	}
+
+	deliver(t, body, "ack")
+	task, _ = taskOf(t, body)
+	if task.Status != utils.TaskSucceeded {
+		t.Fatalf("task is %s after redelivery, want SUCCEEDED", task.Status)
+	}
+	out, err := mem.Download(storage.OutputBucket, mapper.OutputPath(task.WorkflowId, task.Name))
+	if err != nil || string(out) != "[]" {
+		t.Fatalf("output is %q (%v), want []", out, err)
+	}
+	workflow, _ := repo.Repo.GetWorkflowByID(task.WorkflowId)
+	if workflow.Status != utils.RunSucceeded {
+		t.Fatalf("workflow is %s, want SUCCEEDED", workflow.Status)
+	}
+}
+
+// hookQueue hands every published message to publish.
+type hookQueue struct {
+	publish func(body []byte)
+}
+
+func (q *hookQueue) Publish(body []byte, priority int) error {
+	q.publish(body)
+	return nil
+}
+
+func (q *hookQueue) Consume(ctx context.Context) (<-chan queue.Delivery, error) {
+	return nil, errors.New("not supported")
+}
+
+func TestClaimerDoesNotPublishEventReleasedByConsumer(t *testing.T) {
+	repo.Repo = repo.NewMemoryRepository()
+	executor.Default = &stubExecutor{}
+	defer func() { repo.Limits = repo.RunLimits{} }()
+	repo.Limits = repo.RunLimits{Global: 1}
+
+	// a takes the only slot
+	deliver(t, queueTask(t, utils.Task{Name: "a"}), "ack")
+	task, _ := taskOf(t, queueTask(t, utils.Task{Name: "b"}))
+
+	// The consumer gets b's message before its claimer records the publish,
+	// defers it and has it released as soon as the slot frees up.
+	queue.Tasks = &hookQueue{publish: func(msg []byte) {
+		var published utils.OutboxEvent
+		_ = json.Unmarshal(msg, &published)
+		if published.TaskID != task.TaskId {
+			return
+		}
+		deliver(t, msg, "ack")
+		repo.Limits = repo.RunLimits{}
+		if err := scheduler.ReleaseDeferred(); err != nil {
+			t.Fatal(err)
+		}
+	}}
+	OutboxClaimJob(1)
+
+	events, err := repo.Repo.ClaimOutboxEvents(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, claimed := range events {
+		if claimed.TaskID == task.TaskId {
+			return
+		}
+	}
+	t.Fatalf("the released event of b cannot be claimed again")
+}
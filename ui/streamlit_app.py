@@ -41,13 +41,15 @@ with tab_upload:
     st.subheader("Upload workflow")
     wf = st.file_uploader("Workflow file", key="upload_wf")
     req = st.file_uploader("requirements file", key="upload_req")
+    params = st.text_area("params (JSON, optional)", key="upload_params")
 
     if st.button("Upload", type="primary", disabled=not (wf and req)):
         files = {
             "file": (wf.name, wf.getvalue(), "application/octet-stream"),
             "requirements": (req.name, req.getvalue(), "text/plain"),
         }
-        r = _post(f"{api_base}/upload", files=files)
+        data = {"params": params} if params.strip() else None
+        r = _post(f"{api_base}/upload", data=data, files=files)
         _show_response(r)
 
 with tab_update: